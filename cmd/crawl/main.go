@@ -5,47 +5,579 @@ import (
 	"flag"
 	"fmt"
 	"github.com/priteshgudge/webcrawler/crawlerlib"
+	"io"
 	"log"
+	"net/http"
 	"os"
 	"runtime"
+	"strings"
+	"time"
 )
 
+// stringsFlag collects the values of a flag that can be repeated
+type stringsFlag []string
 
-func main() {
-	log.SetFlags(log.Ldate | log.Lshortfile)
-	flag.CommandLine.SetOutput(os.Stdout)
-
-	baseURL := flag.String("url", "https://monzo.com", "Starting URL")
-	maxDepth := flag.Int("max-depth", 3, "Max depth to Crawl")
-	sitemapFile := flag.String("sitemap", "sitemap.xml", "File location to write sitemap to")
-	scraperConcurrency := flag.Int("concurrency", runtime.NumCPU()*2, "Number of concurrent scrapers")
-	domain := flag.String("domain", "monzo.com", "Domain for URLs")
-	help := flag.Bool("help", false, "Show Options")
-	flag.Parse()
-
-	if *help {
-		fmt.Fprintf(os.Stdout, "Usage of %s:\n", os.Args[0])
-		flag.PrintDefaults()
-		return
+// String returns the collected values
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ", ")
+}
+
+// Set collects one more value
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// crawlFlags are the flags shared by every command that crawls
+type crawlFlags struct {
+	baseURL        *string
+	sites          stringsFlag
+	siteFile       *string
+	maxDepth       *int
+	maxPages       *int
+	maxHostPages   *int
+	maxBytes       *int64
+	maxBodyBytes   *int64
+	maxDuration    *time.Duration
+	trapRepeats    *int
+	trapPathLength *int
+	trapVariants   *int
+	trapTemplate   *int
+	concurrency    *int
+	domain         *string
+	scopeFile      *string
+	scopeRules     stringsFlag
+	ignoreRobots   *bool
+	hostInFlight   *int
+	hostDelay      *time.Duration
+	hostBurst      *int
+	hostLimits     stringsFlag
+	seedMode       *string
+	seedSitemaps   stringsFlag
+	checkAssets    *bool
+	followNofollow *bool
+	schemes        stringsFlag
+	normalizeSkip  stringsFlag
+	stripParams    stringsFlag
+	trailingSlash  *string
+	maxAttempts    *int
+	retryDelay     *time.Duration
+	userAgent      *string
+	proxyURL       *string
+	caBundle       *string
+	insecure       *bool
+	requestTimeout *time.Duration
+	fetchTimeout   *time.Duration
+	headers        stringsFlag
+	quiet          *bool
+	help           *bool
+	set            map[string]bool // set holds the flags given on the command line
+}
+
+// newCrawlFlags defines the crawl flags on the flag set
+func newCrawlFlags(fs *flag.FlagSet) *crawlFlags {
+	cf := &crawlFlags{
+		baseURL:        fs.String("url", "https://monzo.com", "Starting URL"),
+		siteFile:       fs.String("site-file", "", "File of sites to crawl along with the --site ones, one per line as --site takes them"),
+		maxDepth:       fs.Int("max-depth", 3, "Max depth to Crawl"),
+		maxPages:       fs.Int("max-pages", 0, "Max pages to fetch in total, 0 for no limit"),
+		maxHostPages:   fs.Int("max-pages-per-host", 0, "Max pages to fetch from a single host, 0 for no limit"),
+		maxBytes:       fs.Int64("max-bytes", 0, "Max page body bytes to download in total, 0 for no limit"),
+		maxBodyBytes:   fs.Int64("max-body-bytes", 0, "Max bytes to read from a single page, longer pages are truncated, 0 for no limit"),
+		maxDuration:    fs.Duration("max-duration", 0, "Max time to schedule pages for, 0 for no limit"),
+		trapRepeats:    fs.Int("trap-max-segment-repeats", 0, "Times a path segment, or a run of segments, may repeat back to back before the url is quarantined as a trap, 0 for the default, -1 to turn off"),
+		trapPathLength: fs.Int("trap-max-path-length", 0, "Path length in bytes past which a url is quarantined as a trap, 0 for the default, -1 to turn off"),
+		trapVariants:   fs.Int("trap-max-query-variants", 0, "Query variants crawled per path before the rest are quarantined as traps, 0 for the default, -1 to turn off"),
+		trapTemplate:   fs.Int("trap-max-template-urls", 0, "URLs crawled per template like /calendar/{n}/{n} before the rest are quarantined as traps, off unless set"),
+		concurrency:    fs.Int("concurrency", runtime.NumCPU()*2, "Number of concurrent scrapers"),
+		domain:         fs.String("domain", "monzo.com", "Domain for URLs"),
+		scopeFile:      fs.String("scope-file", "", "File of include and exclude scope rules, one per line, checked before --scope-rule ones"),
+		ignoreRobots:   fs.Bool("ignore-robots", false, "Ignore robots.txt, only for sites you own"),
+		hostInFlight:   fs.Int("host-max-inflight", crawlerlib.DefaultHostLimit.MaxInFlight, "Max concurrent requests per host, 0 for no limit"),
+		hostDelay:      fs.Duration("host-delay", 0, "Min delay between requests to the same host"),
+		hostBurst:      fs.Int("host-burst", 1, "Requests allowed back to back to a host before host-delay applies"),
+		maxAttempts:    fs.Int("max-attempts", crawlerlib.DefaultRetryPolicy.MaxAttempts, "Max attempts per url, 1 disables retries"),
+		retryDelay:     fs.Duration("retry-delay", crawlerlib.DefaultRetryPolicy.BaseDelay, "Wait before the first retry, doubled for every retry after"),
+		userAgent:      fs.String("user-agent", crawlerlib.DefaultUserAgent, "User-Agent sent with every request"),
+		proxyURL:       fs.String("proxy", "", "HTTP/HTTPS proxy url, defaults to the environment proxy"),
+		caBundle:       fs.String("ca-bundle", "", "PEM file with extra CAs to trust"),
+		insecure:       fs.Bool("insecure-skip-verify", false, "Skip TLS certificate checks, for staging sites only"),
+		requestTimeout: fs.Duration("request-timeout", 30*time.Second, "Timeout of a single request"),
+		fetchTimeout:   fs.Duration("fetch-timeout", 0, "Timeout of fetching a url including retries, 0 for no limit"),
+		seedMode:       fs.String("seed", "links", "Where the crawl starts: links from the start URL, sitemaps of the start URL or both"),
+		checkAssets:    fs.Bool("check-assets", false, "Check the images, scripts, stylesheets and non html pages linked from the pages with HEAD"),
+		followNofollow: fs.Bool("follow-nofollow", false, "Follow rel=nofollow links and the links of nofollow pages"),
+		trailingSlash:  fs.String("trailing-slash", "keep", "Trailing slash of url paths: keep, add or remove"),
+		quiet:          fs.Bool("quiet", false, "Don't log the crawl progress"),
+		help:           fs.Bool("help", false, "Show Options"),
+	}
+
+	fs.Var(&cf.sites, "site", "Site to crawl as url[,domain=REGEX][,max-depth=N][,max-pages=N][,scope=RULE], --url is only crawled too when given, can be repeated")
+	fs.Var(&cf.headers, "header", "Extra request header as 'Name: value', can be repeated")
+	fs.Var(&cf.scopeRules, "scope-rule", "Scope rule as 'include|exclude [url|host|path] pattern', the pattern a glob or re:regex, can be repeated")
+	fs.Var(&cf.hostLimits, "host-limit", "Per host limit as pattern[,inflight=N][,delay=D][,burst=N], can be repeated")
+	fs.Var(&cf.seedSitemaps, "seed-sitemap", "Sitemap to read seed urls from, defaults to the ones in robots.txt, can be repeated")
+	fs.Var(&cf.schemes, "scheme", "URL scheme to crawl, defaults to "+strings.Join(crawlerlib.DefaultSchemes, ", ")+", links to others like mailto are only counted, can be repeated")
+	fs.Var(&cf.normalizeSkip, "normalize-skip", "URL normalization step to leave out, one of "+strings.Join(crawlerlib.NormalizeSteps, ", ")+", can be repeated")
+	fs.Var(&cf.stripParams, "strip-param", "Query param stripped from urls, a trailing * matches a prefix, defaults to "+strings.Join(crawlerlib.DefaultTrackingParams, ", ")+", can be repeated")
+	return cf
+}
+
+// crawlOptions returns the crawler options the flags ask for
+func crawlOptions(cf *crawlFlags) ([]crawlerlib.Option, error) {
+	politeness := crawlerlib.Politeness{
+		Default: crawlerlib.HostLimit{MaxInFlight: *cf.hostInFlight, Delay: *cf.hostDelay, Burst: *cf.hostBurst},
+	}
+	for _, s := range cf.hostLimits {
+		l, err := crawlerlib.ParseHostLimit(s)
+		if err != nil {
+			return nil, err
+		}
+		politeness.Hosts = append(politeness.Hosts, l)
+	}
+
+	fetcherConfig := crawlerlib.FetcherConfig{
+		RequestTimeout:     *cf.requestTimeout,
+		TotalTimeout:       *cf.fetchTimeout,
+		UserAgent:          *cf.userAgent,
+		Headers:            make(http.Header),
+		ProxyURL:           *cf.proxyURL,
+		CABundle:           *cf.caBundle,
+		InsecureSkipVerify: *cf.insecure,
+	}
+	for _, h := range cf.headers {
+		kv := strings.SplitN(h, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid header %q, expected 'Name: value'", h)
+		}
+		fetcherConfig.Headers.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+
+	var scopeRules []crawlerlib.ScopeRule
+	if *cf.scopeFile != "" {
+		f, err := os.Open(*cf.scopeFile)
+		if err != nil {
+			return nil, err
+		}
+
+		scopeRules, err = crawlerlib.ReadScopeRules(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", *cf.scopeFile, err)
+		}
+	}
+	for _, s := range cf.scopeRules {
+		r, err := crawlerlib.ParseScopeRule(s)
+		if err != nil {
+			return nil, err
+		}
+		scopeRules = append(scopeRules, r)
+	}
+
+	sites, err := siteFlags(cf)
+	if err != nil {
+		return nil, err
+	}
+
+	seedMode, err := crawlerlib.ParseSeedMode(*cf.seedMode)
+	if err != nil {
+		return nil, err
+	}
+
+	trailingSlash, err := crawlerlib.ParseTrailingSlash(*cf.trailingSlash)
+	if err != nil {
+		return nil, err
+	}
+
+	retry := crawlerlib.DefaultRetryPolicy
+	retry.MaxAttempts = *cf.maxAttempts
+	retry.BaseDelay = *cf.retryDelay
+
+	opts := []crawlerlib.Option{
+		crawlerlib.WithMaxDepth(*cf.maxDepth),
+		crawlerlib.WithBudget(crawlerlib.Budget{
+			MaxPages:        *cf.maxPages,
+			MaxPagesPerHost: *cf.maxHostPages,
+			MaxBytes:        *cf.maxBytes,
+			MaxBodyBytes:    *cf.maxBodyBytes,
+			MaxDuration:     *cf.maxDuration,
+		}),
+		crawlerlib.WithTraps(crawlerlib.TrapConfig{
+			MaxSegmentRepeats: *cf.trapRepeats,
+			MaxPathLength:     *cf.trapPathLength,
+			MaxQueryVariants:  *cf.trapVariants,
+			MaxTemplateURLs:   *cf.trapTemplate,
+		}),
+		crawlerlib.WithScopeRules(scopeRules...),
+		crawlerlib.WithSites(sites...),
+		crawlerlib.WithConcurrency(*cf.concurrency),
+		crawlerlib.WithIgnoreRobots(*cf.ignoreRobots),
+		crawlerlib.WithPoliteness(politeness),
+		crawlerlib.WithRetryPolicy(retry),
+		crawlerlib.WithFetcher(fetcherConfig),
+		crawlerlib.WithSeedMode(seedMode),
+		crawlerlib.WithSitemaps(cf.seedSitemaps...),
+		crawlerlib.WithCheckAssets(*cf.checkAssets),
+		crawlerlib.WithFollowNofollow(*cf.followNofollow),
+		crawlerlib.WithExtract(crawlerlib.ExtractConfig{Schemes: cf.schemes}),
+		crawlerlib.WithNormalize(crawlerlib.NormalizeConfig{
+			Skip:           cf.normalizeSkip,
+			TrackingParams: cf.stripParams,
+			TrailingSlash:  trailingSlash,
+		}),
+	}
+	if *cf.quiet {
+		opts = append(opts, crawlerlib.WithOutput(nil))
+	}
+
+	// the default domain is only meant for the default url
+	if len(sites) == 0 || cf.set["domain"] {
+		opts = append(opts, crawlerlib.WithScope(*cf.domain))
+	}
+
+	return opts, nil
+}
+
+// siteFlags returns the sites of --site-file followed by the --site ones
+func siteFlags(cf *crawlFlags) ([]crawlerlib.Site, error) {
+	var sites []crawlerlib.Site
+	if *cf.siteFile != "" {
+		f, err := os.Open(*cf.siteFile)
+		if err != nil {
+			return nil, err
+		}
+
+		sites, err = crawlerlib.ReadSites(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", *cf.siteFile, err)
+		}
+	}
+
+	for _, s := range cf.sites {
+		site, err := crawlerlib.ParseSite(s)
+		if err != nil {
+			return nil, err
+		}
+		sites = append(sites, site)
+	}
+
+	return sites, nil
+}
+
+// parseFlags parses the command line args, printing the usage and returning false for --help
+func parseFlags(fs *flag.FlagSet, cf *crawlFlags, args []string) bool {
+	fs.Parse(args)
+	cf.set = make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { cf.set[f.Name] = true })
+	if *cf.help {
+		fmt.Fprintf(os.Stdout, "Usage of %s:\n", fs.Name())
+		fs.PrintDefaults()
+		return false
 	}
 
-	if *baseURL == "" {
+	if *cf.baseURL == "" {
 		log.Fatal("start URL cannot be empty")
 	}
 
+	return true
+}
+
+// runCrawl crawls with the options the flags ask for, followed by the extra options
+func runCrawl(cf *crawlFlags, extra ...crawlerlib.Option) *crawlerlib.Response {
+	opts, err := crawlOptions(cf)
+	if err != nil {
+		log.Fatalf("invalid crawl options: %v\n", err)
+	}
+	opts = append(opts, extra...)
+
+	crawler, err := crawlerlib.New(opts...)
+	if err != nil {
+		log.Fatalf("invalid crawl options: %v\n", err)
+	}
+
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	defer cancelFunc()
 
-	log.Printf("Scraping url: %s  maxDepth: %d concurrency: %d", *baseURL, *maxDepth, *scraperConcurrency)
-	resp, err := crawlerlib.StartWithDepthAndDomainRegex(ctx, *baseURL, *maxDepth,*domain,  *scraperConcurrency)
+	// with sites the url is only crawled when given
+	var seeds []string
+	if (len(cf.sites) == 0 && *cf.siteFile == "") || cf.set["url"] {
+		seeds = append(seeds, *cf.baseURL)
+	}
+
+	if len(seeds) > 0 {
+		log.Printf("Scraping url: %s  maxDepth: %d concurrency: %d", *cf.baseURL, *cf.maxDepth, *cf.concurrency)
+	} else {
+		log.Printf("Scraping sites  maxDepth: %d concurrency: %d", *cf.maxDepth, *cf.concurrency)
+	}
+	resp, err := crawler.Run(ctx, seeds...)
 	if err != nil {
 		log.Fatalf("couldn't start scrape: %v\n", err)
 	}
 
+	return resp
+}
+
+func main() {
+	log.SetFlags(log.Ldate | log.Lshortfile)
+
+	args := os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case "check-links":
+			checkLinks(args[1:])
+			return
+		case "sitemap-audit":
+			sitemapAudit(args[1:])
+			return
+		case "canonicals":
+			canonicals(args[1:])
+			return
+		}
+	}
+
+	crawl(args)
+}
+
+// crawl crawls the site and writes its sitemap, or prints the crawl stats when no sitemap file is given
+func crawl(args []string) {
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	fs.SetOutput(os.Stdout)
+	cf := newCrawlFlags(fs)
+	sitemapFile := fs.String("sitemap", "sitemap.xml", "File location to write sitemap to")
+	sitemapGzip := fs.Bool("sitemap-gzip", false, "Gzip the sitemap files")
+	sitemapBaseURL := fs.String("sitemap-base-url", "", "URL the sitemap files are served from, defaults to the root of the start URL")
+	sitemapChangeFreq := fs.String("sitemap-changefreq", "weekly", "Sitemap changefreq of urls matching no changefreq rule, empty to leave it out")
+	var changeFreqRules stringsFlag
+	fs.Var(&changeFreqRules, "sitemap-changefreq-rule", "Sitemap changefreq for urls matching a regex as pattern=changefreq, can be repeated")
+	sitemapPriority := fs.String("sitemap-priority", "depth", "Sitemap priority formula: depth or inbound")
+	sitemapImages := fs.Bool("sitemap-images", false, "List the images of every page in the sitemap")
+	sitemapVideos := fs.Bool("sitemap-videos", false, "List the videos of every page in the sitemap")
+	sitemapAlternates := fs.Bool("sitemap-hreflang", false, "List the hreflang alternates of every page in the sitemap")
+	graphOut := fs.String("graph-out", "", "File to write the link graph to")
+	graphFormat := fs.String("graph-format", "dot", "Link graph format: dot, graphml or json")
+	graphCollapse := fs.Int("graph-collapse", 0, "Merge urls sharing their first N path segments into one graph node, 0 keeps every url")
+	graphInScope := fs.Bool("graph-in-scope-only", false, "Leave out of scope urls out of the link graph")
+	graphAssets := fs.Bool("graph-assets", false, "Add the links to images, scripts and stylesheets to the link graph")
+	assetsOut := fs.String("assets-out", "", "File to write the asset report to, needs --check-assets")
+	assetsFormat := fs.String("assets-format", "csv", "Asset report format: text, csv or json")
+	if !parseFlags(fs, cf, args) {
+		return
+	}
+
+	sitemapOpts := crawlerlib.SitemapOptions{
+		Gzip:       *sitemapGzip,
+		BaseURL:    *sitemapBaseURL,
+		ChangeFreq: *sitemapChangeFreq,
+		Images:     *sitemapImages,
+		Videos:     *sitemapVideos,
+		Alternates: *sitemapAlternates,
+	}
+	for _, r := range changeFreqRules {
+		rule, err := crawlerlib.ParseChangeFreqRule(r)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sitemapOpts.ChangeFreqs = append(sitemapOpts.ChangeFreqs, rule)
+	}
+
+	switch *sitemapPriority {
+	case "depth":
+		sitemapOpts.Priority = crawlerlib.DepthPriority
+	case "inbound":
+		sitemapOpts.Priority = crawlerlib.InboundPriority
+	default:
+		log.Fatalf("unknown sitemap priority %q, expected depth or inbound", *sitemapPriority)
+	}
+
+	switch *graphFormat {
+	case "dot", "graphml", "json":
+	default:
+		log.Fatalf("unknown graph format %q, expected dot, graphml or json", *graphFormat)
+	}
+
+	switch *assetsFormat {
+	case "text", "csv", "json":
+	default:
+		log.Fatalf("unknown asset report format %q, expected text, csv or json", *assetsFormat)
+	}
+
+	resp := runCrawl(cf)
+	if *assetsOut != "" {
+		err := writeFile(*assetsOut, func(w io.Writer) error { return resp.WriteAssets(w, *assetsFormat) })
+		if err != nil {
+			log.Fatalf("failed to write asset report: %v\n", err)
+		}
+	}
+
+	if *graphOut != "" {
+		err := writeFile(*graphOut, func(w io.Writer) error {
+			return resp.WriteGraph(w, *graphFormat, crawlerlib.GraphOptions{
+				CollapseDepth: *graphCollapse,
+				InScopeOnly:   *graphInScope,
+				Assets:        *graphAssets,
+			})
+		})
+		if err != nil {
+			log.Fatalf("failed to write link graph: %v\n", err)
+		}
+	}
+
 	if *sitemapFile != "" {
-		crawlerlib.Sitemap(resp, *sitemapFile)
+		files, err := crawlerlib.SitemapWithOptions(resp, *sitemapFile, sitemapOpts)
+		if err != nil {
+			log.Fatalf("failed to write sitemap: %v\n", err)
+		}
+
+		log.Printf("sitemap written to %s", strings.Join(files, ", "))
 		return
 	}
 
 	fmt.Print(resp)
 }
+
+// checkLinks crawls the site and reports the broken links, exiting with 1 when there are any
+func checkLinks(args []string) {
+	fs := flag.NewFlagSet(os.Args[0]+" check-links", flag.ExitOnError)
+	fs.SetOutput(os.Stdout)
+	cf := newCrawlFlags(fs)
+	format := fs.String("format", "text", "Report format: text, csv or json")
+	out := fs.String("out", "", "File to write the report to, defaults to stdout")
+	if !parseFlags(fs, cf, args) {
+		return
+	}
+
+	switch *format {
+	case "text", "csv", "json":
+	default:
+		log.Fatalf("unknown report format %q, expected text, csv or json", *format)
+	}
+
+	resp := runCrawl(cf)
+	write := func(w io.Writer) error { return resp.WriteBrokenLinks(w, *format) }
+	var err error
+	if *out == "" {
+		err = write(os.Stdout)
+	} else {
+		err = writeFile(*out, write)
+	}
+
+	if err != nil {
+		log.Fatalf("failed to write broken link report: %v\n", err)
+	}
+
+	if resp.Interrupted {
+		log.Fatal("crawl was interrupted, the report is incomplete")
+	}
+
+	if len(resp.BrokenLinks()) > 0 {
+		os.Exit(1)
+	}
+}
+
+// sitemapAudit crawls the site along with the urls of its sitemaps and reports how the two differ,
+// exiting with 1 when there are orphan, missing, errored or redirected urls
+func sitemapAudit(args []string) {
+	fs := flag.NewFlagSet(os.Args[0]+" sitemap-audit", flag.ExitOnError)
+	fs.SetOutput(os.Stdout)
+	cf := newCrawlFlags(fs)
+	format := fs.String("format", "text", "Report format: text, csv or json")
+	out := fs.String("out", "", "File to write the report to, defaults to stdout")
+	if !parseFlags(fs, cf, args) {
+		return
+	}
+
+	switch *format {
+	case "text", "csv", "json":
+	default:
+		log.Fatalf("unknown report format %q, expected text, csv or json", *format)
+	}
+
+	// the sitemap urls are crawled too so their status is known
+	resp := runCrawl(cf, crawlerlib.WithSeedMode(crawlerlib.SeedBoth))
+	if len(resp.SitemapURLs) == 0 {
+		log.Fatal("no urls found in the sitemaps, see --seed-sitemap")
+	}
+
+	var urls []string
+	for u := range resp.SitemapURLs {
+		urls = append(urls, u)
+	}
+
+	audit := crawlerlib.AuditSitemap(urls, resp)
+	write := func(w io.Writer) error { return audit.Write(w, *format) }
+	var err error
+	if *out == "" {
+		err = write(os.Stdout)
+	} else {
+		err = writeFile(*out, write)
+	}
+
+	if err != nil {
+		log.Fatalf("failed to write sitemap audit: %v\n", err)
+	}
+
+	if resp.Interrupted {
+		log.Fatal("crawl was interrupted, the report is incomplete")
+	}
+
+	if audit.Problems() > 0 {
+		os.Exit(1)
+	}
+}
+
+// canonicals crawls the site and reports its duplicate urls and broken canonicals,
+// exiting with 1 when there are canonical chains, bad canonicals or conflicts
+func canonicals(args []string) {
+	fs := flag.NewFlagSet(os.Args[0]+" canonicals", flag.ExitOnError)
+	fs.SetOutput(os.Stdout)
+	cf := newCrawlFlags(fs)
+	format := fs.String("format", "text", "Report format: text or json")
+	out := fs.String("out", "", "File to write the report to, defaults to stdout")
+	if !parseFlags(fs, cf, args) {
+		return
+	}
+
+	switch *format {
+	case "text", "json":
+	default:
+		log.Fatalf("unknown report format %q, expected text or json", *format)
+	}
+
+	resp := runCrawl(cf)
+	report := resp.Canonicals()
+	write := func(w io.Writer) error { return report.Write(w, *format) }
+	var err error
+	if *out == "" {
+		err = write(os.Stdout)
+	} else {
+		err = writeFile(*out, write)
+	}
+
+	if err != nil {
+		log.Fatalf("failed to write canonical report: %v\n", err)
+	}
+
+	if resp.Interrupted {
+		log.Fatal("crawl was interrupted, the report is incomplete")
+	}
+
+	if report.Problems() > 0 {
+		os.Exit(1)
+	}
+}
+
+// writeFile creates the file and writes to it with write
+func writeFile(file string, write func(w io.Writer) error) error {
+	fh, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+
+	if err := write(fh); err != nil {
+		fh.Close()
+		return err
+	}
+
+	return fh.Close()
+}
@@ -0,0 +1,223 @@
+package crawlerlib
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Asset is a non html url linked from the crawled pages, checked without reading its body
+type Asset struct {
+	URL         string `json:"url"`             // URL of the asset
+	StatusCode  int    `json:"status"`          // StatusCode of the last attempt, 0 if there was no response
+	ContentType string `json:"content_type"`    // ContentType the asset is served with
+	Size        int64  `json:"size"`            // Size in bytes from Content-Length or Content-Range, -1 if unknown
+	Method      string `json:"method"`          // Method of the request that checked it, GET when HEAD failed
+	Error       string `json:"error,omitempty"` // Error the check failed with, empty if it passed
+}
+
+// assetExtensions are the path extensions of the files followed links may point to that aren't pages
+var assetExtensions = map[string]bool{
+	".pdf": true, ".doc": true, ".docx": true, ".xls": true, ".xlsx": true, ".ppt": true, ".pptx": true,
+	".zip": true, ".gz": true, ".tar": true, ".rar": true, ".7z": true, ".exe": true, ".dmg": true,
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".svg": true, ".webp": true, ".ico": true,
+	".mp3": true, ".mp4": true, ".webm": true, ".mov": true, ".avi": true, ".wav": true,
+	".css": true, ".js": true, ".woff": true, ".woff2": true, ".ttf": true,
+}
+
+// isAssetURL says if the url path ends with the extension of a file that isn't a page
+func isAssetURL(u *url.URL) bool {
+	return assetExtensions[strings.ToLower(path.Ext(u.Path))]
+}
+
+// assetRequest makes a request for the headers of the asset, GETs only ask for its first byte.
+// The body is closed unread
+func assetRequest(ctx context.Context, f *fetcher, method string, u *url.URL) (*http.Response, error) {
+	req, err := newRequest(ctx, f, method, u)
+	if err != nil {
+		return nil, err
+	}
+
+	if method == http.MethodGet {
+		req.Header.Set("Range", "bytes=0-0")
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body.Close()
+	return resp, nil
+}
+
+// assetSize returns the size of the asset from the response headers, -1 if unknown
+func assetSize(resp *http.Response) int64 {
+	if resp.StatusCode != http.StatusPartialContent {
+		return resp.ContentLength
+	}
+
+	// Content-Range: bytes 0-0/1234, the size is * when unknown
+	cr := resp.Header.Get("Content-Range")
+	i := strings.LastIndex(cr, "/")
+	if i == -1 {
+		return -1
+	}
+
+	size, err := strconv.ParseInt(cr[i+1:], 10, 64)
+	if err != nil {
+		return -1
+	}
+
+	return size
+}
+
+// fetchAsset makes a single attempt to check the asset with HEAD, falling back to a ranged GET
+// when HEAD fails as some servers don't support it
+func fetchAsset(ctx context.Context, f *fetcher, depth int, u *url.URL) (md *scraperDump) {
+	md = &scraperDump{
+		depth:     depth + 1,
+		sourceURL: u,
+		page:      &Page{URL: u.String(), Depth: depth},
+		asset:     &Asset{URL: u.String(), Method: http.MethodHead, Size: -1},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, f.requestTimeout)
+	defer cancel()
+	resp, err := assetRequest(ctx, f, http.MethodHead, u)
+	if err != nil || (resp.StatusCode >= 400 && !isThrottled(resp.StatusCode)) {
+		md.asset.Method = http.MethodGet
+		resp, err = assetRequest(ctx, f, http.MethodGet, u)
+	}
+
+	if err != nil {
+		md.err = err
+		md.asset.Error = err.Error()
+		return md
+	}
+
+	md.statusCode = resp.StatusCode
+	md.page.StatusCode = resp.StatusCode
+	md.asset.StatusCode = resp.StatusCode
+	md.asset.ContentType = resp.Header.Get("Content-Type")
+	md.asset.Size = assetSize(resp)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		md.err = fmt.Errorf("url responsed with code %d", resp.StatusCode)
+		md.asset.Error = md.err.Error()
+		if isThrottled(resp.StatusCode) {
+			md.retryAfter, _ = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		}
+	}
+
+	return md
+}
+
+// checkAsset checks the asset, retrying failed attempts as the fetcher retry policy allows
+func checkAsset(ctx context.Context, f *fetcher, depth int, u *url.URL) *scraperDump {
+	return retryFetch(ctx, f, depth, u, fetchAsset)
+}
+
+// assetProcessor queues the assets linked from the source url page to be checked, once each.
+// Only in scope assets robots.txt allows are checked
+func assetProcessor() processor {
+	return processorFunc(func(g *delegator, md *scraperDump) (proceed bool) {
+		if !g.checkAssets {
+			return true
+		}
+
+		var urls []*url.URL
+		for _, l := range md.links {
+			u := l.url.String()
+			if l.follow || !isAssetKind(l.kind) || g.queuedAssets[u] || !inScope(g, l.url) {
+				continue
+			}
+
+			g.queuedAssets[u] = true
+			urls = append(urls, l.url)
+		}
+
+		queueAssets(g, md.sourceURL, urls)
+		return true
+	})
+}
+
+// queueAssets queues the assets robots.txt allows to be checked, the ones whose robots.txt is being
+// fetched once it is
+func queueAssets(g *delegator, sourceURL *url.URL, urls []*url.URL) {
+	g.unchecked = append(g.unchecked, filterRobots(g, sourceURL, urls, func(urls []*url.URL) {
+		queueAssets(g, sourceURL, urls)
+	})...)
+}
+
+// failedAssets returns the urls of the assets that failed their check, sorted
+func failedAssets(r *Response) []string {
+	var urls []string
+	for u, a := range r.Assets {
+		if a.Error != "" {
+			urls = append(urls, u)
+		}
+	}
+
+	sort.Strings(urls)
+	return urls
+}
+
+// WriteAssets writes every asset checked with its status, content type and size in the given
+// format, one of text, csv or json. Assets are sorted by url
+func (r *Response) WriteAssets(w io.Writer, format string) error {
+	var urls []string
+	for u := range r.Assets {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+
+	switch strings.ToLower(format) {
+	case "text":
+		bw := bufio.NewWriter(w)
+		fmt.Fprintf(bw, "Assets checked: %d  Failed: %d\n", len(urls), len(failedAssets(r)))
+		for _, u := range urls {
+			a := r.Assets[u]
+			if a.Error != "" {
+				fmt.Fprintf(bw, "%s FAILED (%s)\n", u, a.Error)
+				continue
+			}
+
+			size := "size unknown"
+			if a.Size >= 0 {
+				size = fmt.Sprintf("%d bytes", a.Size)
+			}
+			fmt.Fprintf(bw, "%s %d %s %s\n", u, a.StatusCode, a.ContentType, size)
+		}
+		return bw.Flush()
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"url", "status", "content_type", "size", "method", "error"})
+		for _, u := range urls {
+			a := r.Assets[u]
+			cw.Write([]string{u, strconv.Itoa(a.StatusCode), a.ContentType, strconv.FormatInt(a.Size, 10), a.Method, a.Error})
+		}
+		cw.Flush()
+		return cw.Error()
+	case "json":
+		assets := []*Asset{}
+		for _, u := range urls {
+			assets = append(assets, r.Assets[u])
+		}
+
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(assets)
+	default:
+		return fmt.Errorf("unknown report format %q, expected text, csv or json", format)
+	}
+}
@@ -0,0 +1,181 @@
+package crawlerlib
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testAssetSite serves a page linking to assets, /noHead.pdf only answers ranged GETs
+func testAssetSite() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<link rel="stylesheet" href="/style.css"><img src="/logo.png"><img src="/gone.png">
+			<img src="https://cdn.example.com/x.png"><a href="/noHead.pdf">PDF</a><a href="/page">Page</a>`)
+		case "/page":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<img src="/logo.png">`)
+		case "/style.css":
+			w.Header().Set("Content-Type", "text/css")
+			fmt.Fprint(w, "body {}")
+		case "/logo.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Header().Set("Content-Length", "1234")
+		case "/noHead.pdf":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.Header().Set("Content-Type", "application/pdf")
+			if r.Header.Get("Range") == "bytes=0-0" {
+				w.Header().Set("Content-Range", "bytes 0-0/5000")
+				w.WriteHeader(http.StatusPartialContent)
+				fmt.Fprint(w, "%")
+				return
+			}
+			fmt.Fprint(w, "%PDF")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func Test_checkAsset(t *testing.T) {
+	ts := testAssetSite()
+	defer ts.Close()
+
+	tests := []struct {
+		path     string
+		expected Asset
+		error    bool
+	}{
+		{
+			path:     "/logo.png",
+			expected: Asset{StatusCode: 200, ContentType: "image/png", Size: 1234, Method: "HEAD"},
+		},
+		{
+			path:     "/noHead.pdf",
+			expected: Asset{StatusCode: 206, ContentType: "application/pdf", Size: 5000, Method: "GET"},
+		},
+		{
+			path:     "/gone.png",
+			expected: Asset{StatusCode: 404, ContentType: "text/plain; charset=utf-8", Size: 19, Method: "GET", Error: "url responsed with code 404"},
+			error:    true,
+		},
+	}
+
+	f, _ := newFetcher(FetcherConfig{Client: ts.Client()})
+	for _, c := range tests {
+		u, _ := url.Parse(ts.URL + c.path)
+		md := checkAsset(context.Background(), f, 0, u)
+		if (md.err != nil) != c.error {
+			t.Fatalf("%s: expected error %t but got %v", c.path, c.error, md.err)
+		}
+
+		c.expected.URL = u.String()
+		if !reflect.DeepEqual(*md.asset, c.expected) {
+			t.Fatalf("%s: expected %+v but got %+v", c.path, c.expected, *md.asset)
+		}
+	}
+}
+
+func TestCrawler_RunCheckAssets(t *testing.T) {
+	ts := testAssetSite()
+	defer ts.Close()
+
+	c, err := New(WithCheckAssets(true), WithIgnoreRobots(true), WithOutput(nil), WithConcurrency(2),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := c.Run(context.Background(), ts.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var assets []string
+	for u := range resp.Assets {
+		assets = append(assets, strings.TrimPrefix(u, ts.URL))
+	}
+
+	sort.Strings(assets)
+	expected := []string{"/gone.png", "/logo.png", "/noHead.pdf", "/style.css"}
+	if !reflect.DeepEqual(assets, expected) {
+		t.Fatalf("expected assets %v but got %v", expected, assets)
+	}
+
+	if _, ok := resp.Pages[ts.URL+"/noHead.pdf"]; ok || len(resp.ErrorURLs) != 0 {
+		t.Fatalf("expected assets apart from the pages but got pages %v errors %v", resp.Pages, resp.ErrorURLs)
+	}
+
+	broken := resp.BrokenLinks()
+	if len(broken) != 1 || broken[0].URL != ts.URL+"/gone.png" || broken[0].Referrers[0].Page != ts.URL+"/" {
+		t.Fatalf("expected /gone.png to be broken but got %+v", broken)
+	}
+
+	var buf bytes.Buffer
+	if err := resp.WriteAssets(&buf, "csv"); err != nil {
+		t.Fatal(err)
+	}
+
+	row := ts.URL + "/noHead.pdf,206,application/pdf,5000,GET,"
+	if !strings.Contains(buf.String(), row) {
+		t.Fatalf("expected csv to contain %s but got\n%s", row, buf.String())
+	}
+}
+
+func TestCrawler_RunCheckAssetsBudget(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<a href="/doc.pdf">1</a><a href="/report">2</a><a href="/a">3</a><a href="/b">4</a>`)
+		case "/doc.pdf":
+			if r.Method != http.MethodHead {
+				t.Errorf("expected /doc.pdf to be checked with HEAD but got %s", r.Method)
+			}
+			w.Header().Set("Content-Type", "application/pdf")
+		case "/report":
+			w.Header().Set("Content-Type", "application/pdf")
+			fmt.Fprint(w, "%PDF")
+		default:
+			w.Header().Set("Content-Type", "text/html")
+		}
+	}))
+	defer ts.Close()
+
+	c, err := New(WithCheckAssets(true), WithIgnoreRobots(true), WithOutput(nil), WithConcurrency(1),
+		WithBudget(Budget{MaxPages: 4}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := c.Run(context.Background(), ts.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pages, assets []string
+	for u := range resp.Pages {
+		pages = append(pages, strings.TrimPrefix(u, ts.URL))
+	}
+	for u := range resp.Assets {
+		assets = append(assets, strings.TrimPrefix(u, ts.URL))
+	}
+
+	sort.Strings(pages)
+	sort.Strings(assets)
+	if !reflect.DeepEqual(pages, []string{"/", "/a", "/b"}) || !reflect.DeepEqual(assets, []string{"/doc.pdf", "/report"}) {
+		t.Fatalf("expected the assets left out of the page budget but got pages %v assets %v", pages, assets)
+	}
+}
@@ -0,0 +1,129 @@
+package crawlerlib
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+)
+
+// The budgets of a crawl, as reported in Response.BudgetsHit
+const (
+	BudgetPages        = "max-pages"          // BudgetPages is the number of pages fetched
+	BudgetPagesPerHost = "max-pages-per-host" // BudgetPagesPerHost is the number of pages fetched from a host
+	BudgetBytes        = "max-bytes"          // BudgetBytes is the number of body bytes downloaded
+	BudgetBodyBytes    = "max-body-bytes"     // BudgetBodyBytes is the number of bytes read from a single body
+	BudgetDuration     = "max-duration"       // BudgetDuration is how long the crawl runs
+)
+
+// Budget limits how much a crawl fetches, zero values mean no limit. Once a budget is spent no more
+// pages are scheduled, the pages being fetched are finished and the rest are kept in Response.Frontier
+type Budget struct {
+	MaxPages        int           // MaxPages fetched in total, retries and assets left out
+	MaxPagesPerHost int           // MaxPagesPerHost fetched from a single host, other hosts are still crawled
+	MaxBytes        int64         // MaxBytes of page bodies downloaded in total
+	MaxBodyBytes    int64         // MaxBodyBytes read from a single page, the page is scraped up to there and marked truncated
+	MaxDuration     time.Duration // MaxDuration the crawl schedules pages for
+}
+
+// validateBudget fails on negative budgets
+func validateBudget(b Budget) error {
+	if b.MaxPages < 0 || b.MaxPagesPerHost < 0 || b.MaxBytes < 0 || b.MaxBodyBytes < 0 || b.MaxDuration < 0 {
+		return fmt.Errorf("budgets can't be negative, got %+v", b)
+	}
+
+	return nil
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+// Read reads from the underlying reader, counting the bytes read
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// hitBudget records that the budget was hit, once
+func hitBudget(g *delegator, budget string) {
+	for _, b := range g.budgetsHit {
+		if b == budget {
+			return
+		}
+	}
+
+	logf(g.logger, "budget %s reached\n", budget)
+	g.budgetsHit = append(g.budgetsHit, budget)
+}
+
+// budgetSpent returns the budget that stops the whole crawl once spent, empty while there is some left
+func budgetSpent(g *delegator) string {
+	switch {
+	case g.budget.MaxBytes > 0 && g.bytes >= g.budget.MaxBytes:
+		return BudgetBytes
+	case g.budget.MaxDuration > 0 && time.Since(g.started) >= g.budget.MaxDuration:
+		return BudgetDuration
+	case g.budget.MaxPages > 0 && g.fetched >= g.budget.MaxPages:
+		return BudgetPages
+	}
+
+	return ""
+}
+
+// budgetAllows says if the budget allows to fetch the page, recording the budget hit when it doesn't
+func budgetAllows(g *delegator, u *url.URL) bool {
+	if b := budgetSpent(g); b != "" {
+		hitBudget(g, b)
+		return false
+	}
+
+	if g.budget.MaxPagesPerHost > 0 && g.hostPages[u.Host] >= g.budget.MaxPagesPerHost {
+		hitBudget(g, BudgetPagesPerHost)
+		return false
+	}
+
+	return true
+}
+
+// countFetch counts the page handed to the scrapers against the budget
+func countFetch(g *delegator, u *url.URL) {
+	g.fetched++
+	g.hostPages[u.Host]++
+}
+
+// uncountFetch takes back the count of a page requeued after it was handed to the scrapers, or served
+// as an asset
+func uncountFetch(g *delegator, u *url.URL) {
+	g.fetched--
+	g.hostPages[u.Host]--
+}
+
+// stopScheduling moves every url waiting to be crawled to the frontier and drops the assets
+// waiting to be checked, used once a budget stops the crawl
+func stopScheduling(g *delegator) {
+	for d, urls := range g.unScrapped {
+		g.frontier[d] = append(g.frontier[d], urls...)
+		delete(g.unScrapped, d)
+	}
+
+	g.uncheckedAssets = append(g.uncheckedAssets, g.unchecked...)
+	g.unchecked = nil
+}
+
+// frontierURLs returns the urls left to crawl when the crawl stopped, by depth
+func frontierURLs(g *delegator) map[int][]*url.URL {
+	frontier := make(map[int][]*url.URL)
+	for d, urls := range g.frontier {
+		frontier[d] = append(frontier[d], urls...)
+	}
+
+	for d, urls := range g.unScrapped {
+		frontier[d] = append(frontier[d], urls...)
+	}
+
+	return frontier
+}
@@ -0,0 +1,81 @@
+package crawlerlib
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCrawler_RunBudget(t *testing.T) {
+	// every page links to two more, so only a budget stops the crawl
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("slow") != "" {
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		i, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/n/"))
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<a href="/n/%d?%s">l</a><a href="/n/%d?%s">r</a>`, 2*i, r.URL.RawQuery, 2*i+1, r.URL.RawQuery)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name      string
+		seed      string
+		budget    Budget
+		pages     int
+		hit       []string
+		frontier  int
+		truncated bool
+	}{
+		{name: "max pages", seed: "/n/1", budget: Budget{MaxPages: 3}, pages: 3, hit: []string{BudgetPages}, frontier: 4},
+		{name: "max pages per host", seed: "/n/1", budget: Budget{MaxPagesPerHost: 3}, pages: 3, hit: []string{BudgetPagesPerHost}, frontier: 4},
+		{name: "max bytes", seed: "/n/1", budget: Budget{MaxBytes: 1}, pages: 1, hit: []string{BudgetBytes}, frontier: 2},
+		{name: "max body bytes", seed: "/n/1", budget: Budget{MaxBodyBytes: 10, MaxPages: 5}, pages: 1, hit: []string{BudgetBodyBytes}, truncated: true},
+		{name: "max duration", seed: "/n/1?slow=1", budget: Budget{MaxDuration: 20 * time.Millisecond}, pages: 1, hit: []string{BudgetDuration}, frontier: 2},
+	}
+
+	for _, c := range tests {
+		cr, err := New(WithIgnoreRobots(true), WithOutput(nil), WithConcurrency(1), WithMaxDepth(-1), WithBudget(c.budget))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := cr.Run(context.Background(), ts.URL+c.seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(resp.Pages) != c.pages {
+			t.Fatalf("%s: expected %d pages but got %d", c.name, c.pages, len(resp.Pages))
+		}
+
+		if !reflect.DeepEqual(resp.BudgetsHit, c.hit) {
+			t.Fatalf("%s: expected budgets %v to be hit but got %v", c.name, c.hit, resp.BudgetsHit)
+		}
+
+		var frontier int
+		for _, urls := range resp.Frontier {
+			frontier += len(urls)
+		}
+		if frontier != c.frontier {
+			t.Fatalf("%s: expected %d urls in the frontier but got %d: %v", c.name, c.frontier, frontier, resp.Frontier)
+		}
+
+		if p := resp.Pages[ts.URL+c.seed]; p.Truncated != c.truncated {
+			t.Fatalf("%s: expected the seed truncated to be %t", c.name, c.truncated)
+		}
+	}
+}
+
+func TestNew_Budget(t *testing.T) {
+	if _, err := New(WithBudget(Budget{MaxPages: -1})); err == nil {
+		t.Fatal("expected a negative budget to fail")
+	}
+}
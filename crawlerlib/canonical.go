@@ -0,0 +1,226 @@
+package crawlerlib
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// CanonicalCluster is a canonical url along with the crawled urls declaring it canonical
+type CanonicalCluster struct {
+	Canonical  string   `json:"canonical"`  // Canonical url of the cluster
+	Duplicates []string `json:"duplicates"` // Duplicates are the other urls of the same page
+}
+
+// CanonicalIssue is a page whose canonical declaration search engines can't use as is
+type CanonicalIssue struct {
+	URL       string   `json:"url"`             // URL of the page
+	Canonical string   `json:"canonical"`       // Canonical the page declares
+	Problem   string   `json:"problem"`         // Problem with the declaration
+	Chain     []string `json:"chain,omitempty"` // Chain of canonicals from the page, set for chains and loops
+}
+
+// CanonicalReport groups the duplicate urls of a crawl under their canonical url and lists the
+// canonical declarations that are broken
+type CanonicalReport struct {
+	Clusters   []CanonicalCluster `json:"clusters"`    // Clusters of the canonical urls with duplicates
+	Chains     []CanonicalIssue   `json:"chains"`      // Chains are canonicals declaring another canonical, loops included
+	BadTargets []CanonicalIssue   `json:"bad_targets"` // BadTargets are canonicals that aren't 200 html pages in scope
+	Conflicts  []CanonicalIssue   `json:"conflicts"`   // Conflicts are pages declaring different canonicals in their tags and headers
+}
+
+// headerCanonicals returns the urls of the Link headers of u with rel=canonical, resolved against u
+func headerCanonicals(u *url.URL, links []string) (canonicals []string) {
+	for _, v := range links {
+		for {
+			start := strings.IndexByte(v, '<')
+			if start == -1 {
+				break
+			}
+
+			end := strings.IndexByte(v[start:], '>')
+			if end == -1 {
+				break
+			}
+
+			target := v[start+1 : start+end]
+			v = v[start+end+1:]
+
+			// the params of a link run till the next link
+			params := v
+			if next := strings.IndexByte(v, '<'); next != -1 {
+				params = v[:next]
+			}
+
+			for _, p := range strings.Split(params, ";") {
+				kv := strings.SplitN(p, "=", 2)
+				if len(kv) != 2 || !strings.EqualFold(strings.TrimSpace(kv[0]), "rel") {
+					continue
+				}
+
+				rel := strings.Trim(strings.TrimRight(strings.TrimSpace(kv[1]), ", "), `"`)
+				if c := resolveSrc(u, target); hasToken(rel, "canonical") && c != "" {
+					canonicals = appendUnique(canonicals, c)
+				}
+			}
+		}
+	}
+
+	return canonicals
+}
+
+// isDuplicate says if the page declares another url canonical
+func isDuplicate(p *Page) bool {
+	return p.Canonical != "" && p.Canonical != p.URL
+}
+
+// canonicalProblem says why the canonical url can't be used, empty if it is a 200 html page in scope
+func canonicalProblem(r *Response, c string) string {
+	if u, err := url.Parse(c); err != nil || (r.DomainRegex != nil && !r.DomainRegex.MatchString(u.Hostname())) {
+		return "canonical is out of scope"
+	}
+
+	if _, ok := r.Assets[c]; ok {
+		return "canonical is not an html page"
+	}
+
+	p, crawled := r.Pages[c]
+	err, failed := r.ErrorURLs[c]
+	switch {
+	case crawled && p.StatusCode != 0 && p.StatusCode != 200:
+		return fmt.Sprintf("canonical responded with %d", p.StatusCode)
+	case crawled && p.RedirectTo != "":
+		return "canonical redirects to " + p.RedirectTo
+	case failed:
+		return "canonical failed: " + err.Error()
+	case !crawled:
+		return "canonical was not crawled"
+	}
+
+	return ""
+}
+
+// canonicalChain returns the canonicals followed from the page till one is its own canonical,
+// and whether the chain loops back
+func canonicalChain(r *Response, p *Page) (chain []string, loop bool) {
+	chain = []string{p.URL, p.Canonical}
+	seen := map[string]bool{p.URL: true, p.Canonical: true}
+	for {
+		next, ok := r.Pages[chain[len(chain)-1]]
+		if !ok || !isDuplicate(next) {
+			return chain, false
+		}
+
+		chain = append(chain, next.Canonical)
+		if seen[next.Canonical] {
+			return chain, true
+		}
+		seen[next.Canonical] = true
+	}
+}
+
+// Canonicals returns the canonical report of the crawl, sorted by url
+func (r *Response) Canonicals() *CanonicalReport {
+	cr := &CanonicalReport{
+		Clusters:   []CanonicalCluster{},
+		Chains:     []CanonicalIssue{},
+		BadTargets: []CanonicalIssue{},
+		Conflicts:  []CanonicalIssue{},
+	}
+
+	var urls []string
+	for u := range r.Pages {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+
+	clusters := make(map[string]int)
+	for _, u := range urls {
+		p := r.Pages[u]
+		if len(p.Canonicals) > 1 {
+			cr.Conflicts = append(cr.Conflicts, CanonicalIssue{
+				URL:       u,
+				Canonical: p.Canonical,
+				Problem:   "declares " + strings.Join(p.Canonicals, ", "),
+			})
+		}
+
+		if !isDuplicate(p) {
+			continue
+		}
+
+		i, ok := clusters[p.Canonical]
+		if !ok {
+			i = len(cr.Clusters)
+			clusters[p.Canonical] = i
+			cr.Clusters = append(cr.Clusters, CanonicalCluster{Canonical: p.Canonical})
+		}
+		cr.Clusters[i].Duplicates = append(cr.Clusters[i].Duplicates, u)
+
+		if chain, loop := canonicalChain(r, p); len(chain) > 2 {
+			problem := "canonical chain"
+			if loop {
+				problem = "canonical loop"
+			}
+			cr.Chains = append(cr.Chains, CanonicalIssue{URL: u, Canonical: p.Canonical, Problem: problem, Chain: chain})
+			continue
+		}
+
+		if problem := canonicalProblem(r, p.Canonical); problem != "" {
+			cr.BadTargets = append(cr.BadTargets, CanonicalIssue{URL: u, Canonical: p.Canonical, Problem: problem})
+		}
+	}
+
+	sort.Slice(cr.Clusters, func(i, j int) bool { return cr.Clusters[i].Canonical < cr.Clusters[j].Canonical })
+	return cr
+}
+
+// Problems returns the number of canonical chains, bad targets and conflicts
+func (cr *CanonicalReport) Problems() int {
+	return len(cr.Chains) + len(cr.BadTargets) + len(cr.Conflicts)
+}
+
+// Write writes the report in the given format, text or json
+func (cr *CanonicalReport) Write(w io.Writer, format string) error {
+	switch strings.ToLower(format) {
+	case "text":
+		bw := bufio.NewWriter(w)
+		fmt.Fprintf(bw, "Canonical clusters: %d  Chains: %d  Bad canonicals: %d  Conflicts: %d\n",
+			len(cr.Clusters), len(cr.Chains), len(cr.BadTargets), len(cr.Conflicts))
+		for _, c := range cr.Clusters {
+			fmt.Fprintf(bw, "\nDuplicates of %s:\n", c.Canonical)
+			for _, d := range c.Duplicates {
+				fmt.Fprintf(bw, "  %s\n", d)
+			}
+		}
+		if len(cr.Chains) > 0 {
+			fmt.Fprintln(bw, "\nChains:")
+			for _, c := range cr.Chains {
+				fmt.Fprintf(bw, "  %s (%s)\n", strings.Join(c.Chain, " -> "), c.Problem)
+			}
+		}
+		if len(cr.BadTargets) > 0 {
+			fmt.Fprintln(bw, "\nBad canonicals:")
+			for _, c := range cr.BadTargets {
+				fmt.Fprintf(bw, "  %s -> %s (%s)\n", c.URL, c.Canonical, c.Problem)
+			}
+		}
+		if len(cr.Conflicts) > 0 {
+			fmt.Fprintln(bw, "\nConflicts:")
+			for _, c := range cr.Conflicts {
+				fmt.Fprintf(bw, "  %s %s\n", c.URL, c.Problem)
+			}
+		}
+		return bw.Flush()
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(cr)
+	default:
+		return fmt.Errorf("unknown report format %q, expected text or json", format)
+	}
+}
@@ -0,0 +1,161 @@
+package crawlerlib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func Test_headerCanonicals(t *testing.T) {
+	u, _ := url.Parse("http://t.com/page?utm=1")
+	tests := []struct {
+		links    []string
+		expected []string
+	}{
+		{links: []string{`<http://t.com/page>; rel="canonical"`}, expected: []string{"http://t.com/page"}},
+		{
+			links:    []string{`</style.css>; rel=preload; as=style, </page>; rel=canonical`, `<https://t.com/page>; rel="Canonical"`},
+			expected: []string{"http://t.com/page", "https://t.com/page"},
+		},
+		{links: []string{`</next>; rel="next"`, `<mailto:a@t.com>; rel=canonical`}},
+	}
+
+	for _, c := range tests {
+		if got := headerCanonicals(u, c.links); !reflect.DeepEqual(got, c.expected) {
+			t.Fatalf("expected %v for %v but got %v", c.expected, c.links, got)
+		}
+	}
+}
+
+func Test_fetchURLCanonical(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", `</from-header>; rel="canonical"`)
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/tagged" {
+			fmt.Fprint(w, `<link rel="canonical" href="/from-tag">`)
+		}
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		path       string
+		canonical  string
+		canonicals []string
+	}{
+		{path: "/plain", canonical: "/from-header", canonicals: []string{"/from-header"}},
+		{path: "/tagged", canonical: "/from-tag", canonicals: []string{"/from-header", "/from-tag"}},
+	}
+
+	f, _ := newFetcher(FetcherConfig{Client: ts.Client()})
+	for _, c := range tests {
+		u, _ := url.Parse(ts.URL + c.path)
+		md := fetchURL(context.Background(), f, 0, u)
+		var canonicals []string
+		for _, cu := range md.page.Canonicals {
+			canonicals = append(canonicals, strings.TrimPrefix(cu, ts.URL))
+		}
+
+		if md.page.Canonical != ts.URL+c.canonical || !reflect.DeepEqual(canonicals, c.canonicals) {
+			t.Fatalf("%s: expected canonical %s %v but got %s %v", c.path, c.canonical, c.canonicals, md.page.Canonical, md.page.Canonicals)
+		}
+
+		var followed []string
+		for _, fu := range md.urls {
+			followed = append(followed, strings.TrimPrefix(fu.String(), ts.URL))
+		}
+
+		sort.Strings(followed)
+		if !reflect.DeepEqual(followed, c.canonicals) {
+			t.Fatalf("%s: expected the canonicals %v to be followed but got %v", c.path, c.canonicals, followed)
+		}
+	}
+}
+
+// testCanonicalResponse returns a crawl with duplicate urls, a canonical chain, a loop and bad canonicals
+func testCanonicalResponse() *Response {
+	pages := []*Page{
+		{URL: "http://t.com/a", StatusCode: 200, Canonical: "http://t.com/a", Canonicals: []string{"http://t.com/a"}},
+		{URL: "http://t.com/a?utm=1", StatusCode: 200, Canonical: "http://t.com/a", Canonicals: []string{"http://t.com/a"}},
+		{URL: "http://t.com/a/", StatusCode: 200, Canonical: "http://t.com/a", Canonicals: []string{"http://t.com/a", "http://t.com/a/"}},
+		{URL: "http://t.com/old", StatusCode: 200, Canonical: "http://t.com/b"},
+		{URL: "http://t.com/b", StatusCode: 200, Canonical: "http://t.com/a"},
+		{URL: "http://t.com/x", StatusCode: 200, Canonical: "http://t.com/y"},
+		{URL: "http://t.com/y", StatusCode: 200, Canonical: "http://t.com/x"},
+		{URL: "http://t.com/c", StatusCode: 200, Canonical: "http://t.com/gone"},
+		{URL: "http://t.com/gone", StatusCode: 404},
+		{URL: "http://t.com/d", StatusCode: 200, Canonical: "http://other.com/d"},
+	}
+
+	resp := &Response{
+		DomainRegex: regexp.MustCompile(`^t\.com$`),
+		Pages:       make(map[string]*Page),
+		ErrorURLs:   map[string]error{"http://t.com/gone": errors.New("url responsed with code 404")},
+	}
+	for _, p := range pages {
+		resp.Pages[p.URL] = p
+	}
+
+	return resp
+}
+
+func TestResponse_Canonicals(t *testing.T) {
+	expected := &CanonicalReport{
+		Clusters: []CanonicalCluster{
+			{Canonical: "http://other.com/d", Duplicates: []string{"http://t.com/d"}},
+			{Canonical: "http://t.com/a", Duplicates: []string{"http://t.com/a/", "http://t.com/a?utm=1", "http://t.com/b"}},
+			{Canonical: "http://t.com/b", Duplicates: []string{"http://t.com/old"}},
+			{Canonical: "http://t.com/gone", Duplicates: []string{"http://t.com/c"}},
+			{Canonical: "http://t.com/x", Duplicates: []string{"http://t.com/y"}},
+			{Canonical: "http://t.com/y", Duplicates: []string{"http://t.com/x"}},
+		},
+		Chains: []CanonicalIssue{
+			{URL: "http://t.com/old", Canonical: "http://t.com/b", Problem: "canonical chain", Chain: []string{"http://t.com/old", "http://t.com/b", "http://t.com/a"}},
+			{URL: "http://t.com/x", Canonical: "http://t.com/y", Problem: "canonical loop", Chain: []string{"http://t.com/x", "http://t.com/y", "http://t.com/x"}},
+			{URL: "http://t.com/y", Canonical: "http://t.com/x", Problem: "canonical loop", Chain: []string{"http://t.com/y", "http://t.com/x", "http://t.com/y"}},
+		},
+		BadTargets: []CanonicalIssue{
+			{URL: "http://t.com/c", Canonical: "http://t.com/gone", Problem: "canonical responded with 404"},
+			{URL: "http://t.com/d", Canonical: "http://other.com/d", Problem: "canonical is out of scope"},
+		},
+		Conflicts: []CanonicalIssue{
+			{URL: "http://t.com/a/", Canonical: "http://t.com/a", Problem: "declares http://t.com/a, http://t.com/a/"},
+		},
+	}
+
+	got := testCanonicalResponse().Canonicals()
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %+v but got %+v", expected, got)
+	}
+
+	if got.Problems() != 6 {
+		t.Fatalf("expected 6 problems but got %d", got.Problems())
+	}
+
+	var buf bytes.Buffer
+	if err := got.Write(&buf, "text"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{
+		"Canonical clusters: 6  Chains: 3  Bad canonicals: 2  Conflicts: 1\n",
+		"  http://t.com/old -> http://t.com/b -> http://t.com/a (canonical chain)\n",
+		"  http://t.com/c -> http://t.com/gone (canonical responded with 404)\n",
+	} {
+		if !strings.Contains(buf.String(), s) {
+			t.Fatalf("expected report to contain %q but got\n%s", s, buf.String())
+		}
+	}
+
+	if err := got.Write(&buf, "csv"); err == nil {
+		t.Fatal("expected an error for csv")
+	}
+}
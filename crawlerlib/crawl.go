@@ -12,14 +12,29 @@ import (
 
 // Response holds the scrapped response
 type Response struct {
-	BaseURL      *url.URL            // starting url at maxDepth 0
-	UniqueURLs   map[string]int      // UniqueURLs holds the map of unique urls we crawled and times its repeated
-	URLsPerDepth map[int][]*url.URL  // URLsPerDepth holds url found in each depth
-	SkippedURLs  map[string][]string // SkippedURLs holds urls from different domains(if domainRegex is given) and invalid URLs
-	ErrorURLs    map[string]error    // errorURLs holds details as to why reason this url was not crawled
-	DomainRegex  *regexp.Regexp      // restricts crawling the urls to given domain
-	MaxDepth     int                 // MaxDepth of crawl, -1 means no limit for maxDepth
-	Interrupted  bool                // says if delegator was interrupted while scraping
+	BaseURL      *url.URL                // starting url at maxDepth 0
+	UniqueURLs   map[string]int          // UniqueURLs holds the map of unique urls we crawled and times its repeated, noindex pages and duplicates left out
+	URLsPerDepth map[int][]*url.URL      // URLsPerDepth holds url found in each depth
+	SkippedURLs  map[string][]string     // SkippedURLs holds urls from different domains(if domainRegex is given) and invalid URLs
+	SkipReasons  map[string]string       // SkipReasons holds why a skipped url was not crawled, when known
+	ErrorURLs    map[string]error        // errorURLs holds details as to why reason this url was not crawled
+	DomainRegex  *regexp.Regexp          // restricts crawling the urls to given domain
+	MaxDepth     int                     // MaxDepth of crawl, -1 means no limit for maxDepth
+	Interrupted  bool                    // says if delegator was interrupted while scraping
+	HostStats    map[string]HostStats    // HostStats holds the requests made and the throttling state per host
+	Pages        map[string]*Page        // Pages holds the fetch details of every url crawled, including attempts and their errors
+	Links        *LinkGraph              // Links holds every link found on the crawled pages
+	Assets       map[string]*Asset       // Assets holds the non html urls checked when the crawl checks assets
+	Sites        []*SiteResponse         // Sites splits the response between the sites of the crawl, in order
+	Schemes      map[string]*SchemeStats // Schemes counts the links to urls of schemes that aren't crawled, by scheme
+
+	BudgetsHit      []string           // BudgetsHit are the budgets the crawl reached in the order they were hit, e.g. BudgetPages
+	Frontier        map[int][]*url.URL // Frontier holds the urls queued but never crawled by depth, as a budget or interruption left them
+	UncheckedAssets []*url.URL         // UncheckedAssets are the assets queued but never checked as a budget stopped the crawl
+	Traps           []*Trap            // Traps are the suspected crawler traps in the order they were found, their urls weren't crawled
+
+	SitemapURLs   map[string]string // SitemapURLs maps the urls listed in the sitemaps read to the sitemap listing them
+	SitemapErrors map[string]error  // SitemapErrors holds why a sitemap couldn't be read
 }
 
 // String returns a human readable format of the response
@@ -28,6 +43,13 @@ func (r Response) String() string {
 	buffer.WriteString(strings.Repeat("=", 10) + "\n")
 	buffer.WriteString(fmt.Sprintf("Scrape stats for: %s\n", r.BaseURL))
 	buffer.WriteString(fmt.Sprintf("Max Depth: %d  Regex: %s  Interrupted: %t\n", r.MaxDepth, r.DomainRegex, r.Interrupted))
+	if len(r.BudgetsHit) > 0 {
+		var frontier int
+		for _, urls := range r.Frontier {
+			frontier += len(urls)
+		}
+		buffer.WriteString(fmt.Sprintf("Budgets hit: %s  Frontier: %d urls\n", strings.Join(r.BudgetsHit, ", "), frontier))
+	}
 	buffer.WriteString(strings.Repeat("=", 10) + "\n")
 	if len(r.UniqueURLs) < 1 {
 		return buffer.String()
@@ -39,6 +61,20 @@ func (r Response) String() string {
 	}
 	buffer.WriteString(strings.Repeat("-", 10) + "\n")
 
+	if len(r.Sites) > 1 {
+		buffer.WriteString("\n")
+		buffer.WriteString("Sites:\n")
+		buffer.WriteString(strings.Repeat("-", 10) + "\n")
+		for _, s := range r.Sites {
+			buffer.WriteString(fmt.Sprintf("%s unique: %d crawled: %d errors: %d", s.Site.URL, len(s.UniqueURLs), len(s.Pages), len(s.ErrorURLs)))
+			if s.BudgetReached {
+				buffer.WriteString(fmt.Sprintf(" (budget of %d pages reached)", s.Site.MaxPages))
+			}
+			buffer.WriteString("\n")
+		}
+		buffer.WriteString(strings.Repeat("-", 10) + "\n")
+	}
+
 	if len(r.URLsPerDepth) > 0 {
 		var keys []int
 		for i := range r.URLsPerDepth {
@@ -69,86 +105,227 @@ func (r Response) String() string {
 				if _, ok := localUnique[u]; ok {
 					continue
 				}
-				buffer.WriteString(u + "\n")
+				if reason, ok := r.SkipReasons[u]; ok {
+					buffer.WriteString(u + " (" + reason + ")\n")
+				} else {
+					buffer.WriteString(u + "\n")
+				}
 				localUnique[u] = true
 			}
 		}
 		buffer.WriteString(strings.Repeat("-", 10) + "\n")
 	}
 
+	if len(r.Traps) > 0 {
+		buffer.WriteString("\n")
+		buffer.WriteString("Suspected crawler traps:\n")
+		buffer.WriteString(strings.Repeat("-", 10) + "\n")
+		for _, t := range r.Traps {
+			buffer.WriteString(fmt.Sprintf("%s %s: %d urls quarantined\n", t.Kind, t.Pattern, t.URLs))
+			for _, e := range t.Examples {
+				buffer.WriteString("  " + e + "\n")
+			}
+		}
+		buffer.WriteString(strings.Repeat("-", 10) + "\n")
+	}
+
 	if len(r.ErrorURLs) > 0 {
 		buffer.WriteString("\n")
 		buffer.WriteString("Failed URLs:\n")
 		buffer.WriteString(strings.Repeat("-", 10) + "\n")
-		for u := range r.ErrorURLs {
+		for u, err := range r.ErrorURLs {
+			if p, ok := r.Pages[u]; ok && p.Attempts > 1 {
+				buffer.WriteString(fmt.Sprintf("%s (%v after %d attempts)\n", u, err, p.Attempts))
+				continue
+			}
 			buffer.WriteString(u + "\n")
 		}
 		buffer.WriteString(strings.Repeat("-", 10) + "\n")
 	}
 
+	var flaky []string
+	for u, p := range r.Pages {
+		if _, failed := r.ErrorURLs[u]; !failed && len(p.Errors) > 0 {
+			flaky = append(flaky, u)
+		}
+	}
+
+	if len(flaky) > 0 {
+		sort.Strings(flaky)
+		buffer.WriteString("\n")
+		buffer.WriteString("URLs crawled after retries:\n")
+		buffer.WriteString(strings.Repeat("-", 10) + "\n")
+		for _, u := range flaky {
+			buffer.WriteString(fmt.Sprintf("%s (%d attempts)\n", u, r.Pages[u].Attempts))
+		}
+		buffer.WriteString(strings.Repeat("-", 10) + "\n")
+	}
+
+	if len(r.Assets) > 0 {
+		failed := failedAssets(&r)
+		buffer.WriteString("\n")
+		buffer.WriteString(fmt.Sprintf("Assets checked: %d  Failed: %d\n", len(r.Assets), len(failed)))
+		buffer.WriteString(strings.Repeat("-", 10) + "\n")
+		for _, u := range failed {
+			buffer.WriteString(fmt.Sprintf("%s (%s)\n", u, r.Assets[u].Error))
+		}
+		buffer.WriteString(strings.Repeat("-", 10) + "\n")
+	}
+
+	if len(r.Schemes) > 0 {
+		var schemes []string
+		for s := range r.Schemes {
+			schemes = append(schemes, s)
+		}
+		sort.Strings(schemes)
+		buffer.WriteString("\n")
+		buffer.WriteString("Links to other schemes:\n")
+		buffer.WriteString(strings.Repeat("-", 10) + "\n")
+		for _, s := range schemes {
+			stats := r.Schemes[s]
+			buffer.WriteString(fmt.Sprintf("%s: %d links\n", s, stats.Links))
+			var targets []string
+			for t := range stats.Targets {
+				targets = append(targets, t)
+			}
+			sort.Strings(targets)
+			for _, t := range targets {
+				buffer.WriteString(fmt.Sprintf("  %s (%d pages)\n", t, len(stats.Targets[t])))
+			}
+		}
+		buffer.WriteString(strings.Repeat("-", 10) + "\n")
+	}
+
+	var throttled []string
+	for host, hs := range r.HostStats {
+		if hs.Throttled > 0 {
+			throttled = append(throttled, host)
+		}
+	}
+
+	if len(throttled) > 0 {
+		sort.Strings(throttled)
+		buffer.WriteString("\n")
+		buffer.WriteString("Throttled hosts:\n")
+		buffer.WriteString(strings.Repeat("-", 10) + "\n")
+		for _, host := range throttled {
+			hs := r.HostStats[host]
+			buffer.WriteString(fmt.Sprintf("%s requests: %d throttled: %d backoff: %v\n", host, hs.Requests, hs.Throttled, hs.Backoff))
+		}
+		buffer.WriteString(strings.Repeat("-", 10) + "\n")
+	}
+
 	return buffer.String()
 }
 
 // delegatorToResponse will convert delegator data to response
 func delegatorToResponse(g *delegator) *Response {
+	unique := indexedURLs(g)
 	return &Response{
 		BaseURL:      g.baseURL,
-		UniqueURLs:   g.scrappedUnique,
+		UniqueURLs:   unique,
 		URLsPerDepth: g.scrapped,
 		SkippedURLs:  g.skippedURLs,
+		SkipReasons:  g.skipReasons,
 		ErrorURLs:    g.errorURLs,
 		DomainRegex:  g.domainRegex,
 		MaxDepth:     g.maxDepth,
 		Interrupted:  g.interrupted,
+		HostStats:    hostStats(g.scheduler),
+		Pages:        g.pages,
+		Links:        g.links,
+		Assets:       g.assets,
+		Schemes:      g.schemes,
+		Sites:        siteResponses(g, unique),
+
+		BudgetsHit:      g.budgetsHit,
+		Frontier:        frontierURLs(g),
+		UncheckedAssets: append(g.uncheckedAssets, g.unchecked...),
+		Traps:           foundTraps(g.traps),
+
+		SitemapURLs:   g.sitemapURLs,
+		SitemapErrors: g.sitemapErrors,
 	}
 }
 
-// start will start the scrapping
-func start(ctx context.Context, u string, maxDepth int, regex string, concurrency int) (resp *Response, err error) {
-	baseURL, err := url.Parse(u)
-	if err != nil {
-		return nil, fmt.Errorf("failed to scrape url: %v\n", err)
+// indexedURLs returns the unique urls crawled, leaving out the pages that ask not to be indexed
+// and the duplicates of another canonical url
+func indexedURLs(g *delegator) map[string]int {
+	urls := make(map[string]int)
+	for u, n := range g.scrappedUnique {
+		if p, ok := g.pages[u]; ok && (p.NoIndex || isDuplicate(p)) {
+			continue
+		}
+		urls[u] = n
 	}
 
-	g := newDelegator(baseURL, maxDepth)
-	if regex != "" {
-		setDomainRegex(g, regex)
-	}
+	return urls
+}
 
-	var scrapers []*scraper
-	for i := 0; i < concurrency; i++ {
-		m := newScraper(fmt.Sprintf("Scraper %d", i), g.submitDumpCh)
-		scrapers = append(scrapers, m)
-		go startScraper(ctx, m)
+// Config holds the settings of a crawl, see New for setting them one by one
+type Config struct {
+	MaxDepth       int             // MaxDepth of crawl, -1 means no limit for maxDepth
+	DomainRegex    string          // DomainRegex restricts crawling to matching hosts, defaults to the base url host
+	Concurrency    int             // Concurrency is the number of scrapers
+	IgnoreRobots   bool            // IgnoreRobots crawls urls even if robots.txt disallows them, meant for sites we own
+	Politeness     Politeness      // Politeness limits the concurrency and rate of requests per host
+	Retry          RetryPolicy     // Retry decides which failed fetches are retried, zero value means DefaultRetryPolicy
+	Fetcher        FetcherConfig   // Fetcher configures the http client, timeouts and headers of the requests
+	SeedMode       SeedMode        // SeedMode says if the crawl starts from the seeds, the urls in their sitemaps or both
+	Sitemaps       []string        // Sitemaps to read the seeds from, defaults to the ones robots.txt of the seeds declare
+	Extract        ExtractConfig   // Extract says which links are extracted from the pages and which of them are crawled
+	Sites          []Site          // Sites are crawled before the seeds given to Run, each with its own scope and limits
+	Scope          []ScopeRule     // Scope are include and exclude rules checked in order against urls of in scope hosts
+	Traps          TrapConfig      // Traps holds the thresholds of the crawler trap detection, zero values use DefaultTrapConfig
+	Budget         Budget          // Budget limits the pages, bytes and time the crawl spends, zero values mean no limit
+	Normalize      NormalizeConfig // Normalize says how urls are normalized before they are deduplicated and scoped
+	CheckAssets    bool            // CheckAssets checks images, scripts, stylesheets and non html pages with HEAD instead of failing them
+	FollowNofollow bool            // FollowNofollow follows links marked rel=nofollow and the links of nofollow pages
+}
 
+// start will start the scrapping
+func start(ctx context.Context, u string, cfg Config) (resp *Response, err error) {
+	c, err := New(WithConfig(cfg))
+	if err != nil {
+		return nil, err
 	}
 
-	g.scrapers = scrapers
-	startDelegator(ctx, g)
-	return delegatorToResponse(g), nil
+	return c.Run(ctx, u)
+}
+
+// StartWithConfig will start the scrapping with the given config
+func StartWithConfig(ctx context.Context, url string, cfg Config) (resp *Response, err error) {
+	return start(ctx, url, cfg)
 }
 
 // StartWithDepth will start the scrapping with given max depth and base url domain
 func StartWithDepth(ctx context.Context, url string, maxDepth int, concurrency int) (resp *Response, err error) {
-	return start(ctx, url, maxDepth, "", concurrency)
+	return start(ctx, url, Config{MaxDepth: maxDepth, Concurrency: concurrency})
 }
 
 // StartWithDepthAndDomainRegex will start the scrapping with max depth and regex
 func StartWithDepthAndDomainRegex(ctx context.Context, url string, maxDepth int, domainRegex string, concurrency int) (resp *Response, err error) {
-	return start(ctx, url, maxDepth, domainRegex,concurrency)
+	return start(ctx, url, Config{MaxDepth: maxDepth, DomainRegex: domainRegex, Concurrency: concurrency})
 }
 
 // StartWithDomainRegex will start the scrapping with no depth limit(-1) and regex
 func StartWithDomainRegex(ctx context.Context, url, domainRegex string, concurrency int) (resp *Response, err error) {
-	return start(ctx, url, -1, domainRegex, concurrency)
+	return start(ctx, url, Config{MaxDepth: -1, DomainRegex: domainRegex, Concurrency: concurrency})
 }
 
 // Start will start the scrapping with no depth limit(-1) and base url domain
 func Start(ctx context.Context, url string, concurrency int) (resp *Response, err error) {
-	return start(ctx, url, -1, "", concurrency)
+	return start(ctx, url, Config{MaxDepth: -1, Concurrency: concurrency})
 }
 
-// Sitemap generates a sitemap from the given response
+// Sitemap generates a sitemap from the given response, every url weekly with a priority of 0.5
 func Sitemap(resp *Response, file string) error {
-	return generateSiteMap(file, resp.UniqueURLs)
+	_, err := generateSiteMap(file, resp, SitemapOptions{ChangeFreq: "weekly", Priority: flatPriority})
+	return err
+}
+
+// SitemapWithOptions generates a sitemap from the given response and returns the files written.
+// Large sitemaps are split into parts next to file and file becomes their index
+func SitemapWithOptions(resp *Response, file string, opts SitemapOptions) ([]string, error) {
+	return generateSiteMap(file, resp, opts)
 }
@@ -0,0 +1,464 @@
+package crawlerlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// Crawler crawls sites with the settings it was created with. A Crawler can be Run many times
+type Crawler struct {
+	cfg         Config         // cfg holds the crawl settings
+	domainRegex *regexp.Regexp // domainRegex is the compiled cfg.DomainRegex, nil to use the seed hosts
+	scope       *scope         // scope is the compiled cfg.Scope, nil when there are no rules
+	fetcher     *fetcher       // fetcher is copied for every run
+	processors  []Processor    // processors are run on every page crawled after the built in ones
+	logger      *log.Logger    // logger for the crawl progress, nil uses the standard logger
+}
+
+// Option configures a Crawler
+type Option func(c *Crawler) error
+
+// Processor is called for every page crawled successfully along with the in scope links found on it.
+// It returns the links that are to be crawled further
+type Processor interface {
+	Process(page *Page, links []*url.URL) []*url.URL
+}
+
+// ProcessorFunc lets a func be used as a Processor
+type ProcessorFunc func(page *Page, links []*url.URL) []*url.URL
+
+// Process calls f
+func (f ProcessorFunc) Process(page *Page, links []*url.URL) []*url.URL {
+	return f(page, links)
+}
+
+// New returns a Crawler with the given options. Without options it crawls with no depth
+// limit, twice as many scrapers as CPUs and stays on the seed hosts
+func New(opts ...Option) (*Crawler, error) {
+	c := &Crawler{
+		cfg: Config{
+			MaxDepth:    -1,
+			Concurrency: runtime.NumCPU() * 2,
+		},
+	}
+
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.cfg.Concurrency < 1 {
+		return nil, fmt.Errorf("concurrency must be at least 1, got %d", c.cfg.Concurrency)
+	}
+
+	if c.cfg.MaxDepth < -1 {
+		return nil, fmt.Errorf("max depth must be -1 for no limit or more, got %d", c.cfg.MaxDepth)
+	}
+
+	if c.cfg.DomainRegex != "" {
+		r, err := regexp.Compile(c.cfg.DomainRegex)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile domain regex: %v", err)
+		}
+		c.domainRegex = r
+	}
+
+	s, err := newScope(c.cfg.Scope)
+	if err != nil {
+		return nil, err
+	}
+	c.scope = s
+
+	for _, site := range c.cfg.Sites {
+		seeds, err := parseSeeds([]string{site.URL})
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := newSite(site, seeds[0], c.domainRegex); err != nil {
+			return nil, err
+		}
+	}
+
+	// fail early on bad host limits, every run gets its own scheduler
+	if _, err := newHostScheduler(c.cfg.Politeness); err != nil {
+		return nil, err
+	}
+
+	f, err := newFetcher(c.cfg.Fetcher)
+	if err != nil {
+		return nil, err
+	}
+
+	f.extractor, err = newExtractor(c.cfg.Extract)
+	if err != nil {
+		return nil, err
+	}
+
+	f.extractor.normalizer, err = NewNormalizer(c.cfg.Normalize)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateBudget(c.cfg.Budget); err != nil {
+		return nil, err
+	}
+
+	f.maxBodyBytes = c.cfg.Budget.MaxBodyBytes
+	f.checkAssets = c.cfg.CheckAssets
+	f.followNofollow = c.cfg.FollowNofollow
+	f.retry = c.cfg.Retry
+	if f.retry.MaxAttempts == 0 {
+		f.retry = DefaultRetryPolicy
+	}
+
+	if f.retry.MaxAttempts < 0 {
+		return nil, fmt.Errorf("max attempts must be at least 1, got %d", f.retry.MaxAttempts)
+	}
+
+	if c.cfg.SeedMode < SeedLinks || c.cfg.SeedMode > SeedBoth {
+		return nil, fmt.Errorf("unknown seed mode %d", c.cfg.SeedMode)
+	}
+
+	c.fetcher = f
+	return c, nil
+}
+
+// WithConfig replaces the whole config of the crawler, options after it can still change it
+func WithConfig(cfg Config) Option {
+	return func(c *Crawler) error {
+		c.cfg = cfg
+		return nil
+	}
+}
+
+// WithMaxDepth sets the max depth of the crawl, -1 means no limit
+func WithMaxDepth(maxDepth int) Option {
+	return func(c *Crawler) error {
+		c.cfg.MaxDepth = maxDepth
+		return nil
+	}
+}
+
+// WithScope restricts crawling to hosts matching the domain regex
+func WithScope(domainRegex string) Option {
+	return func(c *Crawler) error {
+		c.cfg.DomainRegex = domainRegex
+		return nil
+	}
+}
+
+// WithConcurrency sets the number of scrapers
+func WithConcurrency(concurrency int) Option {
+	return func(c *Crawler) error {
+		c.cfg.Concurrency = concurrency
+		return nil
+	}
+}
+
+// WithFetcher configures the http client, timeouts and headers of the requests
+func WithFetcher(cfg FetcherConfig) Option {
+	return func(c *Crawler) error {
+		c.cfg.Fetcher = cfg
+		return nil
+	}
+}
+
+// WithRetryPolicy sets which failed fetches are retried
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(c *Crawler) error {
+		c.cfg.Retry = p
+		return nil
+	}
+}
+
+// WithPoliteness sets the per host request limits
+func WithPoliteness(p Politeness) Option {
+	return func(c *Crawler) error {
+		c.cfg.Politeness = p
+		return nil
+	}
+}
+
+// WithIgnoreRobots crawls urls even if robots.txt disallows them, meant for sites we own
+func WithIgnoreRobots(ignore bool) Option {
+	return func(c *Crawler) error {
+		c.cfg.IgnoreRobots = ignore
+		return nil
+	}
+}
+
+// WithSeedMode sets where the crawl gets its depth 0 urls from
+func WithSeedMode(mode SeedMode) Option {
+	return func(c *Crawler) error {
+		c.cfg.SeedMode = mode
+		return nil
+	}
+}
+
+// WithScopeRules adds include and exclude rules urls on the in scope hosts must pass. Rules are checked
+// in order and the first match decides, urls matching no rule are out of scope when any rule includes.
+// Seeds are crawled whatever the rules
+func WithScopeRules(rules ...ScopeRule) Option {
+	return func(c *Crawler) error {
+		c.cfg.Scope = append(c.cfg.Scope, rules...)
+		return nil
+	}
+}
+
+// WithSites adds sites crawled in the same run, each with its own scope, depth and page budget.
+// Urls are crawled once across the sites
+func WithSites(sites ...Site) Option {
+	return func(c *Crawler) error {
+		c.cfg.Sites = append(c.cfg.Sites, sites...)
+		return nil
+	}
+}
+
+// WithBudget limits the pages, bytes and time the crawl spends. Once a budget is spent no more
+// pages are scheduled and the urls left are kept in the frontier of the response
+func WithBudget(b Budget) Option {
+	return func(c *Crawler) error {
+		c.cfg.Budget = b
+		return nil
+	}
+}
+
+// WithTraps sets the thresholds of the crawler trap detection, urls looking like traps are
+// quarantined and reported rather than crawled
+func WithTraps(cfg TrapConfig) Option {
+	return func(c *Crawler) error {
+		c.cfg.Traps = cfg
+		return nil
+	}
+}
+
+// WithSitemaps sets the sitemaps seeds are read from when the seed mode uses sitemaps
+func WithSitemaps(sitemaps ...string) Option {
+	return func(c *Crawler) error {
+		c.cfg.Sitemaps = append(c.cfg.Sitemaps, sitemaps...)
+		return nil
+	}
+}
+
+// WithExtract sets which links are extracted from the pages and which of them are crawled
+func WithExtract(cfg ExtractConfig) Option {
+	return func(c *Crawler) error {
+		c.cfg.Extract = cfg
+		return nil
+	}
+}
+
+// WithNormalize sets how urls are normalized before they are deduplicated and checked against the scope
+func WithNormalize(cfg NormalizeConfig) Option {
+	return func(c *Crawler) error {
+		c.cfg.Normalize = cfg
+		return nil
+	}
+}
+
+// WithCheckAssets checks the images, scripts and stylesheets linked from the pages and records non html
+// pages as assets rather than failing them. Assets are checked with HEAD and never parsed
+func WithCheckAssets(check bool) Option {
+	return func(c *Crawler) error {
+		c.cfg.CheckAssets = check
+		return nil
+	}
+}
+
+// WithFollowNofollow follows the links marked rel=nofollow and the links of pages whose robots meta tag
+// or X-Robots-Tag header say nofollow, which are not followed by default
+func WithFollowNofollow(follow bool) Option {
+	return func(c *Crawler) error {
+		c.cfg.FollowNofollow = follow
+		return nil
+	}
+}
+
+// WithProcessors adds processors run on every page crawled
+func WithProcessors(processors ...Processor) Option {
+	return func(c *Crawler) error {
+		for _, p := range processors {
+			if p == nil {
+				return errors.New("processor can't be nil")
+			}
+		}
+
+		c.processors = append(c.processors, processors...)
+		return nil
+	}
+}
+
+// WithOutput sets where the crawl progress is logged, nil discards it.
+// The standard logger is used by default
+func WithOutput(w io.Writer) Option {
+	return func(c *Crawler) error {
+		if w == nil {
+			w = ioutil.Discard
+		}
+
+		c.logger = log.New(w, "", log.LstdFlags)
+		return nil
+	}
+}
+
+// parseSeeds parses the seed urls, every seed must be absolute
+func parseSeeds(seeds []string) ([]*url.URL, error) {
+	if len(seeds) == 0 {
+		return nil, errors.New("at least one seed url is needed")
+	}
+
+	var urls []*url.URL
+	for _, s := range seeds {
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid seed url: %v", err)
+		}
+
+		if u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid seed url: %s is not absolute", s)
+		}
+
+		urls = append(urls, u)
+	}
+
+	return urls, nil
+}
+
+// seedHostsRegex returns a regex matching the hosts of the seeds
+func seedHostsRegex(seeds []*url.URL) *regexp.Regexp {
+	var hosts []string
+	for _, u := range seeds {
+		hosts = append(hosts, regexp.QuoteMeta(u.Hostname()))
+	}
+
+	return regexp.MustCompile("^(?:" + strings.Join(hosts, "|") + ")$")
+}
+
+// seedFromSitemaps reads the sitemaps of the crawl and sets the urls they list as seeds
+func seedFromSitemaps(ctx context.Context, g *delegator, f *fetcher, cfg Config) {
+	sitemaps := cfg.Sitemaps
+	if len(sitemaps) == 0 {
+		sitemaps = discoverSitemaps(ctx, f, f.robots, g.seeds)
+	}
+
+	found, order, errs := readSitemaps(ctx, f, sitemaps)
+	g.sitemapErrors = errs
+	for s, err := range errs {
+		logf(g.logger, "failed to read sitemap %s: %v\n", s, err)
+	}
+
+	// the sitemap urls are compared to the crawled ones, so they are normalized the same way
+	var locs []string
+	for _, loc := range order {
+		n := f.extractor.normalizer.NormalizeString(loc)
+		if _, ok := g.sitemapURLs[n]; !ok {
+			g.sitemapURLs[n] = found[loc]
+			locs = append(locs, n)
+		}
+	}
+
+	seeds := sitemapSeeds(g, locs)
+	logf(g.logger, "found %d seed urls in %d sitemaps\n", len(seeds), len(sitemaps))
+	if cfg.SeedMode == SeedSitemaps {
+		g.seeds = seeds
+		return
+	}
+
+	seen := make(map[string]bool)
+	for _, u := range g.seeds {
+		seen[u.String()] = true
+	}
+
+	for _, u := range seeds {
+		if !seen[u.String()] {
+			g.seeds = append(g.seeds, u)
+		}
+	}
+}
+
+// Run crawls the sites of the config and the seeds till there is nothing left to crawl or ctx is done.
+// Every seed is a site with the crawl scope and limits. The first site is the base url of the response
+func (c *Crawler) Run(ctx context.Context, seeds ...string) (*Response, error) {
+	sites := append([]Site(nil), c.cfg.Sites...)
+	for _, s := range seeds {
+		sites = append(sites, Site{URL: s})
+	}
+
+	var siteURLs []string
+	for _, s := range sites {
+		siteURLs = append(siteURLs, s.URL)
+	}
+
+	urls, err := parseSeeds(siteURLs)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, u := range urls {
+		urls[i] = c.fetcher.extractor.normalizer.Normalize(u)
+	}
+
+	g := newDelegator(urls[0], c.cfg.MaxDepth)
+	g.seeds = urls
+	g.logger = c.logger
+	g.checkAssets = c.cfg.CheckAssets
+	g.scope = c.scope
+	g.budget = c.cfg.Budget
+	g.traps = newTrapDetector(c.cfg.Traps)
+
+	for i, s := range sites {
+		cs, err := newSite(s, urls[i], c.domainRegex)
+		if err != nil {
+			return nil, err
+		}
+
+		if cs.cfg.MaxDepth == nil {
+			d := c.cfg.MaxDepth
+			cs.cfg.MaxDepth = &d
+		}
+		g.sites = append(g.sites, cs)
+	}
+	g.domainRegex = sitesRegex(g.sites)
+
+	for _, p := range c.processors {
+		g.custom = append(g.custom, customProcessor(p))
+	}
+
+	scheduler, err := newHostScheduler(c.cfg.Politeness)
+	if err != nil {
+		return nil, err
+	}
+
+	f := *c.fetcher
+	f.scheduler = scheduler
+	g.scheduler = scheduler
+	if !c.cfg.IgnoreRobots {
+		f.robots = newRobotsCache(ctx, &f)
+		g.robots = f.robots
+	}
+
+	if c.cfg.SeedMode != SeedLinks {
+		seedFromSitemaps(ctx, g, &f, c.cfg)
+	}
+
+	// the work queue holds a url per scraper so a scraper done with its url finds the next one waiting
+	g.workCh = make(chan *frontierItem, c.cfg.Concurrency)
+	for i := 0; i < c.cfg.Concurrency; i++ {
+		m := newScraper(fmt.Sprintf("Scraper %d", i), &f, g.workCh, g.submitDumpCh)
+		m.logger = c.logger
+		go startScraper(ctx, m)
+	}
+
+	startDelegator(ctx, g)
+	return delegatorToResponse(g), nil
+}
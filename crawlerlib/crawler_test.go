@@ -0,0 +1,224 @@
+package crawlerlib
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSite serves linked html pages, pages maps a path to the paths it links to
+func testSite(pages map[string][]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		links, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		for _, l := range links {
+			fmt.Fprintf(w, `<a href="%s">%s</a>`, l, l)
+		}
+	}))
+}
+
+func Test_New(t *testing.T) {
+	tests := []struct {
+		opts  []Option
+		error bool
+	}{
+		{},
+		{opts: []Option{WithMaxDepth(2), WithScope(`test\.com`), WithConcurrency(4)}},
+		{opts: []Option{WithScope(`test(`)}, error: true},
+		{opts: []Option{WithConcurrency(0)}, error: true},
+		{opts: []Option{WithMaxDepth(-2)}, error: true},
+		{opts: []Option{WithPoliteness(Politeness{Hosts: []HostLimit{{Pattern: `[`}}})}, error: true},
+		{opts: []Option{WithFetcher(FetcherConfig{ProxyURL: "::"})}, error: true},
+		{opts: []Option{WithProcessors(nil)}, error: true},
+		{opts: []Option{WithConfig(Config{MaxDepth: 1})}, error: true},
+	}
+
+	for i, c := range tests {
+		_, err := New(c.opts...)
+		if (err != nil) != c.error {
+			t.Fatalf("case %d: expected error %t but got %v", i, c.error, err)
+		}
+	}
+}
+
+func TestCrawler_RunProcessorsAtMaxDepth(t *testing.T) {
+	ts := testSite(map[string][]string{
+		"/":    {"/a", "/b"},
+		"/a":   {"/a/1"},
+		"/b":   {},
+		"/a/1": {},
+	})
+	defer ts.Close()
+
+	tests := []struct {
+		maxDepth int
+		expected []string
+	}{
+		{maxDepth: 1, expected: []string{"/"}},
+		{maxDepth: 2, expected: []string{"/", "/a", "/b"}},
+		{maxDepth: -1, expected: []string{"/", "/a", "/a/1", "/b"}},
+	}
+
+	for _, c := range tests {
+		var pages []string
+		cr, err := New(WithMaxDepth(c.maxDepth), WithIgnoreRobots(true), WithOutput(nil),
+			WithProcessors(ProcessorFunc(func(p *Page, links []*url.URL) []*url.URL {
+				pages = append(pages, strings.TrimPrefix(p.URL, ts.URL))
+				return links
+			})))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := cr.Run(context.Background(), ts.URL+"/"); err != nil {
+			t.Fatal(err)
+		}
+
+		sort.Strings(pages)
+		if fmt.Sprint(pages) != fmt.Sprint(c.expected) {
+			t.Fatalf("max depth %d: expected processed pages %v but got %v", c.maxDepth, c.expected, pages)
+		}
+	}
+}
+
+func TestCrawler_Run(t *testing.T) {
+	ts := testSite(map[string][]string{
+		"/":    {"/a", "/b", "https://external.com/"},
+		"/a":   {"/", "/a/1", "/missing"},
+		"/b":   {"/a", "/b/1"},
+		"/a/1": {"/a/2"},
+		"/a/2": {},
+		"/b/1": {},
+	})
+	defer ts.Close()
+
+	processed := make(map[string]bool)
+	c, err := New(
+		WithMaxDepth(-1),
+		WithConcurrency(3),
+		WithIgnoreRobots(true),
+		WithOutput(nil),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 1}),
+		WithProcessors(ProcessorFunc(func(p *Page, links []*url.URL) []*url.URL {
+			processed[strings.TrimPrefix(p.URL, ts.URL)] = true
+			var kept []*url.URL
+			for _, l := range links {
+				if !strings.HasPrefix(l.Path, "/b/") {
+					kept = append(kept, l)
+				}
+			}
+			return kept
+		})),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := c.Run(ctx, ts.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Interrupted {
+		t.Fatal("expected the crawl to finish without being interrupted")
+	}
+
+	var pages []string
+	for p := range processed {
+		pages = append(pages, p)
+	}
+
+	sort.Strings(pages)
+	expected := []string{"/", "/a", "/a/1", "/a/2", "/b"}
+	if fmt.Sprint(pages) != fmt.Sprint(expected) {
+		t.Fatalf("expected processed pages %v but got %v", expected, pages)
+	}
+
+	if _, ok := resp.ErrorURLs[ts.URL+"/missing"]; !ok {
+		t.Fatalf("expected /missing to fail but got %v", resp.ErrorURLs)
+	}
+
+	if _, ok := resp.UniqueURLs[ts.URL+"/b/1"]; ok {
+		t.Fatal("expected /b/1 to be dropped by the processor")
+	}
+
+	if _, err := c.Run(ctx); err == nil {
+		t.Fatal("expected an error without seeds")
+	}
+
+	if _, err := c.Run(ctx, "/relative"); err == nil {
+		t.Fatal("expected an error for a relative seed")
+	}
+}
+
+func TestCrawler_RunNofollow(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<a href="/meta">meta</a><a href="/header">header</a><a href="/ad" rel="sponsored NoFollow">ad</a>`)
+		case "/meta":
+			fmt.Fprint(w, `<meta name="robots" content="noindex, nofollow"><a href="/meta/1">1</a>`)
+		case "/header":
+			w.Header().Set("X-Robots-Tag", "noindex")
+			fmt.Fprint(w, `<a href="/header/1">1</a>`)
+		default:
+			fmt.Fprint(w, "leaf")
+		}
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		follow   bool
+		expected []string
+	}{
+		{follow: false, expected: []string{"/", "/header", "/header/1", "/meta"}},
+		{follow: true, expected: []string{"/", "/ad", "/header", "/header/1", "/meta", "/meta/1"}},
+	}
+
+	for _, c := range tests {
+		cr, err := New(WithFollowNofollow(c.follow), WithIgnoreRobots(true), WithOutput(nil), WithConcurrency(2))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := cr.Run(context.Background(), ts.URL+"/")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var crawled []string
+		for u := range resp.Pages {
+			crawled = append(crawled, strings.TrimPrefix(u, ts.URL))
+		}
+
+		sort.Strings(crawled)
+		if fmt.Sprint(crawled) != fmt.Sprint(c.expected) {
+			t.Fatalf("follow %t: expected crawled pages %v but got %v", c.follow, c.expected, crawled)
+		}
+
+		meta := resp.Pages[ts.URL+"/meta"]
+		if !meta.NoIndex || !meta.NoFollow || fmt.Sprint(meta.Robots) != "[noindex nofollow]" {
+			t.Fatalf("expected /meta to be noindex and nofollow but got %v", meta.Robots)
+		}
+
+		for _, u := range []string{"/meta", "/header"} {
+			if _, ok := resp.UniqueURLs[ts.URL+u]; ok {
+				t.Fatalf("expected noindex page %s to be left out of the unique urls", u)
+			}
+		}
+	}
+}
@@ -2,76 +2,131 @@ package crawlerlib
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"log"
 	"net/url"
 	"regexp"
+	"time"
 )
 
 // delegator acts a medium for the scrapers and does the following
 // 1. Distributed the urls to scrapers
 // 2. limit domain
 type delegator struct {
-	baseURL        *url.URL            // starting url at maxDepth 0
-	scrapers        []*scraper         // scrapers that are controlled by this delegator
-	scrappedUnique map[string]int      // scrappedUnique holds the map of unique urls we crawled and times its repeated
-	unScrapped     map[int][]*url.URL  // unScrapped are those that are yet to be crawled by the scrapers
-	scrapped       map[int][]*url.URL  // scrapped holds url found in each depth
-	skippedURLs    map[string][]string // skippedURLs contains urls from different domains(if domainRegex is failed) and all invalid urls
-	errorURLs      map[string]error    // reason why this url was not crawled
-	submitDumpCh   chan *scraperDumps  // submitDump listens for scrapers to submit their dumps
-	domainRegex    *regexp.Regexp      // restricts crawling the urls that pass the
-	maxDepth       int                 // maxDepth of crawl, -1 means no limit for maxDepth
-	interrupted    bool                // says if delegator was interrupted while scraping
-	processors     []processor         // list of url processors
-}
-
-// scraperPayload holds the urls for the scraper to crawl and scrape
-type scraperPayload struct {
-	currentDepth int        // depth at which these urls are scrapped from
-	urls         []*url.URL // urls to be crawled
+	baseURL         *url.URL                // starting url at maxDepth 0
+	seeds           []*url.URL              // seeds are crawled at depth 0, defaults to baseURL
+	scrappedUnique  map[string]int          // scrappedUnique holds the map of unique urls we crawled and times its repeated
+	unScrapped      map[int][]*url.URL      // unScrapped are those that are yet to be crawled by the scrapers
+	scrapped        map[int][]*url.URL      // scrapped holds url found in each depth
+	skippedURLs     map[string][]string     // skippedURLs contains urls from different domains(if domainRegex is failed) and all invalid urls
+	skipReasons     map[string]string       // skipReasons holds why a skipped url was not crawled, when known
+	errorURLs       map[string]error        // reason why this url was not crawled
+	pages           map[string]*Page        // pages holds the fetch details of every url crawled
+	links           *LinkGraph              // links holds the edges between the pages crawled and the urls they link to
+	sitemapURLs     map[string]string       // sitemapURLs maps the urls listed in the sitemaps read to the sitemap listing them
+	sitemapErrors   map[string]error        // sitemapErrors holds why a sitemap couldn't be read
+	checkAssets     bool                    // checkAssets checks the assets linked from the pages
+	assets          map[string]*Asset       // assets holds the result of every asset checked
+	queuedAssets    map[string]bool         // queuedAssets are the assets queued to be checked so far
+	unchecked       []*url.URL              // unchecked are the assets yet to be checked by the scrapers
+	schemes         map[string]*SchemeStats // schemes counts the links to urls of schemes that aren't crawled
+	workCh          chan *frontierItem      // workCh is the bounded queue the scrapers pull urls from one at a time
+	submitDumpCh    chan *scraperDumps      // submitDump listens for scrapers to submit their dumps
+	inFlight        int                     // inFlight is the number of urls handed to the scrapers whose dumps are yet to come
+	hostWait        time.Duration           // hostWait is the shortest time till a host of the urls left to crawl is eligible
+	throttled       map[string]*Page        // throttled holds the pages of the urls requeued after a 429 or 503 response
+	domainRegex     *regexp.Regexp          // restricts crawling the urls that pass the
+	maxDepth        int                     // maxDepth of crawl, -1 means no limit for maxDepth
+	interrupted     bool                    // says if delegator was interrupted while scraping
+	sites           []*site                 // sites of the crawl in order, a url belongs to the first one whose scope it is in
+	queued          map[string]bool         // queued are the urls queued to be crawled so far, seeds included
+	urlSites        map[string]*site        // urlSites maps the queued urls to their site
+	scope           *scope                  // scope holds the include and exclude rules urls must pass on top of domainRegex
+	traps           *trapDetector           // traps quarantines the urls that look like crawler traps, nil to crawl them all
+	processors      []processor             // list of url processors
+	custom          []processor             // custom are the processors given to the crawler, run on every page fetched
+	linkProcessors  []processor             // linkProcessors decide which of the in scope links are crawled, after the custom processors
+	robots          *robotsCache            // robots restricts crawling to urls allowed by robots.txt, nil to ignore robots.txt
+	robotsWaits     []*robotsWait           // robotsWaits hold the urls put off till robots.txt of their origin is fetched
+	scheduler       *hostScheduler          // scheduler holds the per host limits and throttling state of the scrapers
+	logger          *log.Logger             // logger for the crawl progress, nil uses the standard logger
+	budget          Budget                  // budget limits the pages, bytes and time the crawl spends
+	budgetsHit      []string                // budgetsHit are the budgets reached, in the order they were hit
+	started         time.Time               // started is when the delegator started scheduling
+	fetched         int                     // fetched is the number of pages handed to the scrapers
+	hostPages       map[string]int          // hostPages is the number of pages handed to the scrapers per host
+	bytes           int64                   // bytes is the number of page body bytes downloaded
+	frontier        map[int][]*url.URL      // frontier holds the urls left out by a budget by depth
+	uncheckedAssets []*url.URL              // uncheckedAssets are the assets left out by a budget
 }
 
 // scraperDump is the crawl dump by single scraper of a given sourceURL
 type scraperDump struct {
-	depth       int        // depth at which the urls are scrapped(+1 of sourceURL depth)
-	sourceURL   *url.URL   // sourceURL the scraper crawled
-	urls        []*url.URL // urls obtained from sourceURL page
-	invalidURLs []string   // urls which couldn't be normalized
-	err         error      // reason why url is not crawled
+	depth       int           // depth at which the urls are scrapped(+1 of sourceURL depth)
+	sourceURL   *url.URL      // sourceURL the scraper crawled
+	statusCode  int           // statusCode of the response, 0 if there was no response
+	retryAfter  time.Duration // retryAfter is the Retry-After of a throttled response
+	page        *Page         // page holds the fetch details of sourceURL
+	urls        []*url.URL    // urls obtained from sourceURL page
+	links       []*pageLink   // links of the sourceURL page along with their anchor metadata
+	invalidURLs []string      // urls which couldn't be normalized
+	schemeLinks []schemeLink  // schemeLinks are the links of sourceURL page to schemes that aren't crawled
+	asset       *Asset        // asset is set when sourceURL was checked as an asset rather than crawled
+	err         error         // reason why url is not crawled
 }
 
-// scraperDumps holds the crawled data and chan to confirm that dumps are accepted
+// scraperDumps holds the crawled data of a url handed to a scraper
 type scraperDumps struct {
 	scraper string
-	got    chan bool
-	mds    []*scraperDump
+	item    *frontierItem // item is the url the scraper was handed
+	mds     []*scraperDump
 }
 
 // newDelegator returns a new delegator with given base url and maxDepth
 func newDelegator(baseURL *url.URL, maxDepth int) *delegator {
 	g := &delegator{
 		baseURL:        baseURL,
+		seeds:          []*url.URL{baseURL},
 		scrappedUnique: make(map[string]int),
 		unScrapped:     make(map[int][]*url.URL),
 		scrapped:       make(map[int][]*url.URL),
 		skippedURLs:    make(map[string][]string),
+		skipReasons:    make(map[string]string),
 		errorURLs:      make(map[string]error),
+		pages:          make(map[string]*Page),
+		links:          newLinkGraph(),
+		sitemapURLs:    make(map[string]string),
+		sitemapErrors:  make(map[string]error),
+		assets:         make(map[string]*Asset),
+		queuedAssets:   make(map[string]bool),
+		queued:         make(map[string]bool),
+		urlSites:       make(map[string]*site),
+		schemes:        make(map[string]*SchemeStats),
+		hostPages:      make(map[string]int),
+		throttled:      make(map[string]*Page),
+		frontier:       make(map[int][]*url.URL),
+		traps:          newTrapDetector(TrapConfig{}),
 		submitDumpCh:   make(chan *scraperDumps),
 		maxDepth:       maxDepth,
 		processors: []processor{
+			pageProcessor(),
+			linkGraphProcessor(),
+			schemeProcessor(),
+			assetProcessor(),
 			uniqueURLProcessor(),
 			errorCheckProcessor(),
 			skippedURLProcessor(),
-			maxDepthCheckProcessor(),
 			domainFilterProcessor(),
 		},
+		linkProcessors: []processor{
+			robotsProcessor(),
+			maxDepthCheckProcessor(),
+			trapProcessor(),
+		},
 	}
 
 	r, _ := regexp.Compile(baseURL.Hostname())
 	g.domainRegex = r
-	log.Printf("delegator: setting default domain regex to %v\n", r)
 	return g
 }
 
@@ -82,132 +137,187 @@ func setDomainRegex(g *delegator, regexStr string) error {
 		return fmt.Errorf("failed to compile domain regex: %v\n", err)
 	}
 
-	log.Printf("updated domain regex: %v\n", r)
+	logf(g.logger, "updated domain regex: %v\n", r)
 	g.domainRegex = r
 	return nil
 }
 
-// getIdleScrapers will return all the idle scrapers
-func getIdleScrapers(g *delegator) (idleScrapers []*scraper) {
-	for _, m := range g.scrapers {
-		if isBusy(m) {
-			continue
-		}
+// processDump will process a single scraperDump
+func processDump(g *delegator, md *scraperDump) {
+	// assets are reported apart from the pages
+	if md.asset != nil {
+		g.assets[md.sourceURL.String()] = md.asset
+		return
+	}
 
-		idleScrapers = append(idleScrapers, m)
+	g.bytes += md.page.Size
+	if md.page.Truncated {
+		hitBudget(g, BudgetBodyBytes)
 	}
 
-	return idleScrapers
-}
+	g.scrapped[md.depth-1] = append(g.scrapped[md.depth-1], md.sourceURL)
+	for _, p := range g.processors {
+		r := p.process(g, md)
+		if !r {
+			return
+		}
+	}
 
-// pushPayloadToscraper will push payload to scraper
-func pushPayloadToScraper(m *scraper, depth int, urls []*url.URL) {
-	m.payloadCh <- &scraperPayload{
-		currentDepth: depth,
-		urls:         urls,
+	// custom processors see every page fetched along with its in scope links, pages at the max depth included
+	for _, p := range g.custom {
+		p.process(g, md)
 	}
+
+	crawlLinks(g, md)
 }
 
-// distributePayload will distribute the given urls to idle scrapers, error when there are no idle scrapers
-func distributePayload(g *delegator, depth int, urls []*url.URL) error {
-	ims := getIdleScrapers(g)
-	if len(ims) == 0 {
-		return errors.New("all scrapers are busy")
+// crawlLinks runs the link processors on the links of the dump and queues the ones left to be crawled
+func crawlLinks(g *delegator, md *scraperDump) {
+	for _, p := range g.linkProcessors {
+		if !p.process(g, md) {
+			return
+		}
 	}
 
-	if len(urls) <= len(ims) {
-		for i, u := range urls {
-			go pushPayloadToScraper(ims[i], depth, []*url.URL{u})
+	// add the md.urls to unscrapped and md.source to scraped, once each. Followed links to files
+	// are checked as assets instead when assets are checked, they aren't pages
+	var urls []*url.URL
+	for _, u := range md.urls {
+		if g.checkAssets && isAssetURL(u) {
+			if !g.queuedAssets[u.String()] {
+				g.queuedAssets[u.String()] = true
+				g.unchecked = append(g.unchecked, u)
+			}
+			continue
 		}
-		return nil
+
+		s, _ := siteOf(g, u)
+		if queueURL(g, md.sourceURL, u, s) {
+			urls = append(urls, u)
+		}
+	}
+
+	if len(urls) > 0 {
+		g.unScrapped[md.depth] = append(g.unScrapped[md.depth], urls...)
 	}
+}
 
-	wd := len(urls) / len(ims)
-	i := 0
-	for mi, m := range ims {
-		if mi+1 == len(ims) {
-			go pushPayloadToScraper(m, depth, urls[i:])
+// processDumps processes the dumps of a url the scrapers took and stops scheduling once a budget is spent
+func processDumps(g *delegator, mds *scraperDumps) {
+	logf(g.logger, "got new dump from %s\n", mds.scraper)
+	g.inFlight--
+	for _, md := range mds.mds {
+		if mds.item != nil && requeueThrottled(g, mds.item, md) {
 			continue
 		}
 
-		go pushPayloadToScraper(m, depth, urls[i:i+wd])
-		i += wd
+		// a page served as something other than html is an asset, the page budget doesn't cover it
+		if mds.item != nil && !mds.item.asset && md.asset != nil {
+			uncountFetch(g, mds.item.url)
+		}
+		processDump(g, md)
+	}
 
+	// a spent budget stops the crawl, the pages being crawled are finished
+	if b := budgetSpent(g); b != "" && hasPending(g) {
+		hitBudget(g, b)
+		stopScheduling(g)
 	}
-	return nil
 }
 
-// processDump will process a single scraperDump
-func processDump(g *delegator, md *scraperDump) {
-	g.scrapped[md.depth-1] = append(g.scrapped[md.depth-1], md.sourceURL)
-	for _, p := range g.processors {
-		r := p.process(g, md)
-		if !r {
-			return
+// queueSeed queues the seed to be crawled at depth 0 if robots.txt allows it, once it is fetched
+func queueSeed(g *delegator, u *url.URL) {
+	allowed := filterRobots(g, u, []*url.URL{u}, func([]*url.URL) {
+		queueSeed(g, u)
+	})
+
+	for _, a := range allowed {
+		if queueURL(g, u, a, seedSite(g, a)) {
+			g.unScrapped[0] = append(g.unScrapped[0], a)
 		}
 	}
+}
+
+// startDelegator initiates delegator to start scraping. urls are handed to the scrapers through
+// the bounded work queue once their host is eligible, no more than there are scrapers so every url
+// handed out is picked up right away. The crawl is done when nothing is left to hand out and every
+// url handed out came back
+func startDelegator(ctx context.Context, g *delegator) {
+	// scrapers stop once the queue is closed
+	defer close(g.workCh)
 
-	// add the md.urls to unscrapped and md.source to scraped
-	if len(md.urls) > 0 {
-		g.unScrapped[md.depth] = append(g.unScrapped[md.depth], md.urls...)
+	logf(g.logger, "Starting Delegator with Base URL: %s domain regex: %v\n", g.baseURL, g.domainRegex)
+	for _, u := range g.seeds {
+		queueSeed(g, u)
 	}
-}
 
-// processDumps process the scraper dumps and signals when the crawl is complete
-func processDumps(g *delegator, mds []*scraperDump) (finished bool) {
-	log.Println("processing dumps...")
-	for _, md := range mds {
-		processDump(g, md)
+	if !hasPending(g) {
+		logln(g.logger, "no seed urls to crawl, all are disallowed by robots.txt or none were found")
+		return
 	}
-	log.Println("processing done...")
 
-	if len(getIdleScrapers(g)) < 1 {
-		log.Println("all scrapers are busy. deferring payload distribution...")
-		return false
+	g.started = time.Now()
+	var deadline <-chan time.Time
+	if g.budget.MaxDuration > 0 {
+		t := time.NewTimer(g.budget.MaxDuration)
+		defer t.Stop()
+		deadline = t.C
 	}
 
-	if len(g.unScrapped) > 0 {
-		for d, urls := range g.unScrapped {
-			err := distributePayload(g, d, urls)
-			if err != nil {
-				log.Printf("failed to distribute load: %v\n", err)
-				break
-			}
-			delete(g.unScrapped, d)
-			log.Printf("distributed payload at depth: %d\n", d)
+	for {
+		// robots.txt fetched while the waits are run wakes us up
+		var arrived <-chan struct{}
+		if len(g.robotsWaits) > 0 {
+			arrived = robotsArrived(g.robots)
+			runRobotsWaits(g)
 		}
 
-		return false
-	}
+		g.hostWait = 0
+		var wake <-chan struct{}
+		if g.inFlight < cap(g.workCh) {
+			// slots released while the urls are scanned, e.g. by a scraper waiting to retry, wake us up
+			if g.scheduler != nil {
+				wake = released(g.scheduler)
+			}
 
-	if len(getIdleScrapers(g)) == len(g.scrapers) && len(g.unScrapped) == 0 {
-		log.Println("scrapping done...")
-		return true
-	}
+			// a scraper is idle, so the url is sent without blocking
+			if next := nextItem(g); next != nil {
+				g.workCh <- next
+				handedOut(g, next)
+				continue
+			}
+		}
 
-	log.Println("no urls to scrape at the moment...")
-	return false
-}
+		if g.inFlight == 0 && !hasPending(g) {
+			logln(g.logger, "scrapping done...")
+			return
+		}
 
-// startDelegator initiates delegator to start scraping
-func startDelegator(ctx context.Context, g *delegator) {
-	log.Printf("Starting Delegator with Base URL: %s\n", g.baseURL)
-	distributePayload(g, 0, []*url.URL{g.baseURL})
+		// hosts waiting for a token are eligible again after hostWait, the others once their slot is released
+		var eligible <-chan time.Time
+		var timer *time.Timer
+		if g.hostWait > 0 {
+			timer = time.NewTimer(g.hostWait)
+			eligible = timer.C
+		}
 
-	for {
 		select {
 		case <-ctx.Done():
-			log.Println("scrapping interrupted...")
+			logln(g.logger, "scrapping interrupted...")
 			g.interrupted = true
 			return
+		case <-deadline:
+			hitBudget(g, BudgetDuration)
+			stopScheduling(g)
+		case <-eligible:
+		case <-wake:
+		case <-arrived:
 		case mds := <-g.submitDumpCh:
-			go func(got chan<- bool) { got <- true }(mds.got)
-			log.Printf("got new dump from %s\n", mds.scraper)
-			done := processDumps(g, mds.mds)
-			if done {
-				log.Println("stopping Delegator...")
-				return
-			}
+			processDumps(g, mds)
+		}
+
+		if timer != nil {
+			timer.Stop()
 		}
 	}
 }
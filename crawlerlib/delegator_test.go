@@ -1,135 +1,134 @@
 package crawlerlib
 
 import (
+	"context"
 	"fmt"
 	"net/url"
-	"sync"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 )
 
-func Test_getIdleScrapers(t *testing.T) {
-	g := &delegator{
-		scrapers: []*scraper{
-			{
-				name: "scraper 1",
-				busy: true,
-				mu:   &sync.RWMutex{},
-			},
-
-			{
-				name: "scraper 2",
-				mu:   &sync.RWMutex{},
-			},
-
-			{
-				name: "scraper 3",
-				mu:   &sync.RWMutex{},
-			},
-
-			{
-				name: "scraper 4",
-				busy: true,
-				mu:   &sync.RWMutex{},
-			},
-		},
-	}
-
-	expectedIdleScrapers := map[string]bool{
-		"scraper 2": true,
-		"scraper 3": true,
+func Test_nextItem(t *testing.T) {
+	parse := func(s string) *url.URL {
+		u, _ := url.Parse(s)
+		return u
 	}
 
-	idleScrapers := getIdleScrapers(g)
-	if len(idleScrapers) != len(expectedIdleScrapers) {
-		t.Fatalf("expected idle scrapers %d but got %d", len(expectedIdleScrapers), len(idleScrapers))
-	}
-
-	for _, s := range idleScrapers {
-		_, ok := expectedIdleScrapers[s.name]
-		if !ok {
-			t.Fatalf("expected %s to be idle but busy\n", s.name)
-		}
-	}
-}
-
-func Test_distributePayload(t *testing.T) {
 	tests := []struct {
-		scrapers int
-		busy    int
-		urls    int
+		budget     Budget
+		limit      HostLimit
+		expected   []string
+		frontier   map[int][]*url.URL
+		unchecked  int
+		budgetsHit []string
 	}{
 		{
-			scrapers: 4,
-			busy:    4,
+			expected: []string{"http://a.com/1", "http://a.com/2", "http://b.com/3", "http://a.com/x.png"},
+			frontier: map[int][]*url.URL{},
 		},
 
 		{
-			scrapers: 5,
-			urls:    4,
+			budget:     Budget{MaxPages: 2},
+			expected:   []string{"http://a.com/1", "http://a.com/2"},
+			frontier:   map[int][]*url.URL{2: {parse("http://b.com/3")}},
+			unchecked:  1,
+			budgetsHit: []string{BudgetPages},
 		},
 
 		{
-			scrapers: 5,
-			busy:    1,
-			urls:    4,
+			budget:     Budget{MaxPagesPerHost: 1},
+			expected:   []string{"http://a.com/1", "http://b.com/3", "http://a.com/x.png"},
+			frontier:   map[int][]*url.URL{1: {parse("http://a.com/2")}},
+			budgetsHit: []string{BudgetPagesPerHost},
 		},
 
 		{
-			scrapers: 5,
-			urls:    10,
+			limit:    HostLimit{MaxInFlight: 1},
+			expected: []string{"http://a.com/1", "http://b.com/3"},
+			frontier: map[int][]*url.URL{},
 		},
 	}
 
-	scraperCreateF := func(g *delegator, total, busy int) (scrapers []*scraper) {
-		for i := 0; i < total; i++ {
-			scrapers = append(scrapers, newScraper(fmt.Sprintf("scraper %d", i), g.submitDumpCh))
+	for _, c := range tests {
+		g := newDelegator(parse("http://a.com"), -1)
+		g.budget = c.budget
+		if c.limit != (HostLimit{}) {
+			g.scheduler, _ = newHostScheduler(Politeness{Default: c.limit})
+		}
+		g.unScrapped[2] = []*url.URL{parse("http://b.com/3")}
+		g.unScrapped[1] = []*url.URL{parse("http://a.com/1"), parse("http://a.com/2")}
+		g.unchecked = []*url.URL{parse("http://a.com/x.png")}
+
+		var got []string
+		for item := nextItem(g); item != nil; item = nextItem(g) {
+			got = append(got, item.url.String())
+			handedOut(g, item)
 		}
 
-		for i := 0; i < busy; i++ {
-			scrapers[i].busy = true
+		if !reflect.DeepEqual(got, c.expected) {
+			t.Fatalf("expected %v to be handed out but got %v", c.expected, got)
 		}
 
-		return scrapers
-	}
+		if g.inFlight != len(c.expected) {
+			t.Fatalf("expected %d urls in flight but got %d", len(c.expected), g.inFlight)
+		}
+
+		if !reflect.DeepEqual(g.frontier, c.frontier) {
+			t.Fatalf("expected frontier %v but got %v", c.frontier, g.frontier)
+		}
 
-	urlCreateF := func(total int) (urls []*url.URL) {
-		for i := 0; i < total; i++ {
-			u, _ := url.Parse(fmt.Sprintf("http://test.com/%d", i))
-			urls = append(urls, u)
+		if len(g.uncheckedAssets) != c.unchecked {
+			t.Fatalf("expected %d unchecked assets but got %d", c.unchecked, len(g.uncheckedAssets))
 		}
 
-		return urls
+		if !reflect.DeepEqual(g.budgetsHit, c.budgetsHit) {
+			t.Fatalf("expected budgets %v to be hit but got %v", c.budgetsHit, g.budgetsHit)
+		}
+	}
+}
+
+func Test_startDelegator(t *testing.T) {
+	tests := []struct {
+		scrapers int
+		pages    int
+	}{
+		{scrapers: 1, pages: 1},
+		{scrapers: 1, pages: 31},
+		{scrapers: 4, pages: 31},
+		{scrapers: 16, pages: 255},
 	}
 
 	for _, c := range tests {
-		baseURL, _ := url.Parse("http://test.com")
-		g := newDelegator(baseURL, 1)
-		g.scrapers = scraperCreateF(g, c.scrapers, c.busy)
-		urls := urlCreateF(c.urls)
-
-		testCh := make(chan int)
-		for _, m := range g.scrapers {
-			go func(m *scraper) {
-				for mp := range m.payloadCh {
-					testCh <- len(mp.urls)
+		baseURL, _ := url.Parse("http://test.com/n/1")
+		g := newDelegator(baseURL, -1)
+		g.workCh = make(chan *frontierItem, c.scrapers)
+
+		// scrapers fake a binary tree of pages where /n/i links to /n/2i and /n/2i+1
+		for i := 0; i < c.scrapers; i++ {
+			go func(name string) {
+				for item := range g.workCh {
+					n, _ := strconv.Atoi(strings.TrimPrefix(item.url.Path, "/n/"))
+					md := &scraperDump{depth: item.depth + 1, sourceURL: item.url, page: &Page{URL: item.url.String()}}
+					for _, child := range []int{2 * n, 2*n + 1} {
+						if child <= c.pages {
+							u, _ := url.Parse(fmt.Sprintf("http://test.com/n/%d", child))
+							md.urls = append(md.urls, u)
+						}
+					}
+					g.submitDumpCh <- &scraperDumps{scraper: name, item: item, mds: []*scraperDump{md}}
 				}
-			}(m)
+			}(fmt.Sprintf("scraper %d", i))
 		}
-		distributePayload(g, 1, urls)
 
-		count := 0
-		busyScrapers := c.urls
-		if busyScrapers > (c.scrapers - c.busy) {
-			busyScrapers = c.scrapers - c.busy
-		}
-		for i := 0; i < busyScrapers; i++ {
-			count += <-testCh
+		startDelegator(context.Background(), g)
+		if len(g.pages) != c.pages {
+			t.Fatalf("expected %d pages to be crawled but got %d", c.pages, len(g.pages))
 		}
 
-		if c.urls != count {
-			t.Fatalf("expected %d urls but got %d", c.urls, count)
+		if g.inFlight != 0 || len(g.unScrapped) != 0 {
+			t.Fatalf("expected nothing left to crawl but %d urls are in flight and %d depths are queued", g.inFlight, len(g.unScrapped))
 		}
-
 	}
 }
-
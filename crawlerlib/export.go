@@ -0,0 +1,326 @@
+package crawlerlib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// GraphOptions shapes the link graph written by the exporters
+type GraphOptions struct {
+	CollapseDepth int  // CollapseDepth merges urls sharing their first N path segments into one node, 0 keeps every url
+	InScopeOnly   bool // InScopeOnly drops the nodes outside the crawl scope along with their edges
+	Assets        bool // Assets keeps the links to images, scripts, stylesheets and other page resources
+}
+
+// GraphNode is a url, or a group of urls when collapsed, in an exported graph
+type GraphNode struct {
+	ID         string `json:"id"`              // ID is the url, or the shared path prefix of the collapsed urls
+	Depth      int    `json:"depth"`           // Depth the url was found at, -1 if it was never reached
+	StatusCode int    `json:"status"`          // StatusCode of the url, 0 if it wasn't fetched or there was no response
+	Error      string `json:"error,omitempty"` // Error the url failed with
+	InScope    bool   `json:"inScope"`         // InScope says if the url was within the crawl scope
+	URLs       int    `json:"urls"`            // URLs is the number of urls in the node, more than 1 only when collapsed
+	Failed     int    `json:"failed"`          // Failed is the number of urls in the node that failed to crawl
+}
+
+// GraphEdge is the links from one node to another in an exported graph
+type GraphEdge struct {
+	Source  string   `json:"source"`            // Source node id
+	Target  string   `json:"target"`            // Target node id
+	Anchors []string `json:"anchors,omitempty"` // Anchors are the distinct non empty anchor texts of the links
+	Count   int      `json:"count"`             // Count is the number of links merged into the edge
+}
+
+// exportGraph is the node and edge list written by the exporters
+type exportGraph struct {
+	Nodes []*GraphNode `json:"nodes"`
+	Edges []*GraphEdge `json:"edges"`
+}
+
+// collapseURL returns the node id of the url, its scheme, host and first depth path segments
+func collapseURL(u string, depth int) string {
+	if depth <= 0 {
+		return u
+	}
+
+	pu, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+
+	segments := strings.Split(strings.Trim(pu.Path, "/"), "/")
+	path := "/"
+	for i, s := range segments {
+		if i == depth || s == "" {
+			break
+		}
+		path += s + "/"
+	}
+
+	return pu.Scheme + "://" + pu.Host + path
+}
+
+// urlDepths returns the lowest depth each url was found at
+func urlDepths(r *Response) map[string]int {
+	depths := make(map[string]int)
+	for d, urls := range r.URLsPerDepth {
+		for _, u := range urls {
+			if cur, ok := depths[u.String()]; !ok || d < cur {
+				depths[u.String()] = d
+			}
+		}
+	}
+
+	for u, p := range r.Pages {
+		if cur, ok := depths[u]; !ok || p.Depth < cur {
+			depths[u] = p.Depth
+		}
+	}
+
+	return depths
+}
+
+// buildExportGraph builds the node and edge list of the response link graph
+func buildExportGraph(r *Response, opts GraphOptions) *exportGraph {
+	depths := urlDepths(r)
+	inScope := make(map[string]bool)
+	var urls []string
+	addURL := func(u string, scope bool) {
+		cur, ok := inScope[u]
+		if !ok {
+			urls = append(urls, u)
+		}
+		inScope[u] = cur || scope
+	}
+
+	// crawled pages are in scope, even a seed without links
+	for u := range r.Pages {
+		addURL(u, true)
+	}
+
+	var edges []*LinkEdge
+	if r.Links != nil {
+		for _, e := range r.Links.Edges {
+			if opts.Assets || !isAssetKind(e.Kind) {
+				edges = append(edges, e)
+			}
+		}
+	}
+
+	for _, e := range edges {
+		addURL(e.Source, true)
+		addURL(e.Target, e.InScope)
+	}
+
+	// the shallowest url of a collapsed node speaks for it
+	sort.Slice(urls, func(i, j int) bool {
+		di, iok := depths[urls[i]]
+		dj, jok := depths[urls[j]]
+		if iok != jok {
+			return iok
+		}
+		if di != dj {
+			return di < dj
+		}
+		return urls[i] < urls[j]
+	})
+
+	nodes := make(map[string]*GraphNode)
+	for _, u := range urls {
+		if opts.InScopeOnly && !inScope[u] {
+			continue
+		}
+
+		id := collapseURL(u, opts.CollapseDepth)
+		n, ok := nodes[id]
+		if !ok {
+			n = &GraphNode{ID: id, Depth: -1}
+			if d, ok := depths[u]; ok {
+				n.Depth = d
+			}
+			if p, ok := r.Pages[u]; ok {
+				n.StatusCode = p.StatusCode
+			}
+			if err, ok := r.ErrorURLs[u]; ok {
+				n.Error = err.Error()
+			}
+			nodes[id] = n
+		}
+
+		n.URLs++
+		n.InScope = n.InScope || inScope[u]
+		if _, ok := r.ErrorURLs[u]; ok {
+			n.Failed++
+		}
+	}
+
+	type edgeKey struct{ source, target string }
+	merged := make(map[edgeKey]*GraphEdge)
+	anchors := make(map[edgeKey]map[string]bool)
+	for _, e := range edges {
+		k := edgeKey{collapseURL(e.Source, opts.CollapseDepth), collapseURL(e.Target, opts.CollapseDepth)}
+		if nodes[k.source] == nil || nodes[k.target] == nil {
+			continue
+		}
+
+		// links within a collapsed node say nothing about the site structure
+		if opts.CollapseDepth > 0 && k.source == k.target {
+			continue
+		}
+
+		ge, ok := merged[k]
+		if !ok {
+			ge = &GraphEdge{Source: k.source, Target: k.target}
+			merged[k] = ge
+			anchors[k] = make(map[string]bool)
+		}
+
+		ge.Count++
+		if e.Anchor != "" && !anchors[k][e.Anchor] {
+			anchors[k][e.Anchor] = true
+			ge.Anchors = append(ge.Anchors, e.Anchor)
+		}
+	}
+
+	eg := &exportGraph{Nodes: []*GraphNode{}, Edges: []*GraphEdge{}}
+	for _, n := range nodes {
+		eg.Nodes = append(eg.Nodes, n)
+	}
+
+	for _, e := range merged {
+		eg.Edges = append(eg.Edges, e)
+	}
+
+	sort.Slice(eg.Nodes, func(i, j int) bool { return eg.Nodes[i].ID < eg.Nodes[j].ID })
+	sort.Slice(eg.Edges, func(i, j int) bool {
+		if eg.Edges[i].Source != eg.Edges[j].Source {
+			return eg.Edges[i].Source < eg.Edges[j].Source
+		}
+		return eg.Edges[i].Target < eg.Edges[j].Target
+	})
+
+	return eg
+}
+
+// dotQuote returns s as a quoted Graphviz DOT string, new lines become line breaks of the label
+func dotQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// xmlEscape returns s escaped for xml text and attribute values
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// WriteDOT writes the link graph in Graphviz DOT format. Failed nodes are red and out of scope nodes grey
+func (r *Response) WriteDOT(w io.Writer, opts GraphOptions) error {
+	eg := buildExportGraph(r, opts)
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph crawl {")
+	for _, n := range eg.Nodes {
+		label := fmt.Sprintf("%s\ndepth: %d", n.ID, n.Depth)
+		if n.StatusCode != 0 {
+			label += fmt.Sprintf(" status: %d", n.StatusCode)
+		}
+		if n.URLs > 1 {
+			label += fmt.Sprintf("\nurls: %d failed: %d", n.URLs, n.Failed)
+		} else if n.Error != "" {
+			label += "\nerror: " + n.Error
+		}
+
+		attrs := "label=" + dotQuote(label)
+		switch {
+		case n.Failed > 0:
+			attrs += ", color=red"
+		case !n.InScope:
+			attrs += ", color=grey, fontcolor=grey"
+		}
+
+		fmt.Fprintf(bw, "  %s [%s];\n", dotQuote(n.ID), attrs)
+	}
+
+	for _, e := range eg.Edges {
+		attrs := ""
+		if e.Count > 1 {
+			attrs = fmt.Sprintf(" [weight=%d, label=\"%d\"]", e.Count, e.Count)
+		}
+
+		fmt.Fprintf(bw, "  %s -> %s%s;\n", dotQuote(e.Source), dotQuote(e.Target), attrs)
+	}
+
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// WriteGraphML writes the link graph in GraphML format
+func (r *Response) WriteGraphML(w io.Writer, opts GraphOptions) error {
+	eg := buildExportGraph(r, opts)
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, `<?xml version="1.0" encoding="UTF-8"?>`)
+	fmt.Fprintln(bw, `<graphml xmlns="http://graphml.graphdrawing.org/xmlns">`)
+	fmt.Fprintln(bw, `  <key id="depth" for="node" attr.name="depth" attr.type="int"/>`)
+	fmt.Fprintln(bw, `  <key id="status" for="node" attr.name="status" attr.type="int"/>`)
+	fmt.Fprintln(bw, `  <key id="error" for="node" attr.name="error" attr.type="string"/>`)
+	fmt.Fprintln(bw, `  <key id="inScope" for="node" attr.name="inScope" attr.type="boolean"/>`)
+	fmt.Fprintln(bw, `  <key id="urls" for="node" attr.name="urls" attr.type="int"/>`)
+	fmt.Fprintln(bw, `  <key id="failed" for="node" attr.name="failed" attr.type="int"/>`)
+	fmt.Fprintln(bw, `  <key id="anchors" for="edge" attr.name="anchors" attr.type="string"/>`)
+	fmt.Fprintln(bw, `  <key id="count" for="edge" attr.name="count" attr.type="int"/>`)
+	fmt.Fprintln(bw, `  <graph id="crawl" edgedefault="directed">`)
+	for _, n := range eg.Nodes {
+		fmt.Fprintf(bw, "    <node id=\"%s\">\n", xmlEscape(n.ID))
+		fmt.Fprintf(bw, "      <data key=\"depth\">%d</data>\n", n.Depth)
+		fmt.Fprintf(bw, "      <data key=\"status\">%d</data>\n", n.StatusCode)
+		if n.Error != "" {
+			fmt.Fprintf(bw, "      <data key=\"error\">%s</data>\n", xmlEscape(n.Error))
+		}
+		fmt.Fprintf(bw, "      <data key=\"inScope\">%t</data>\n", n.InScope)
+		fmt.Fprintf(bw, "      <data key=\"urls\">%d</data>\n", n.URLs)
+		fmt.Fprintf(bw, "      <data key=\"failed\">%d</data>\n", n.Failed)
+		fmt.Fprintln(bw, "    </node>")
+	}
+
+	for i, e := range eg.Edges {
+		fmt.Fprintf(bw, "    <edge id=\"e%d\" source=\"%s\" target=\"%s\">\n", i, xmlEscape(e.Source), xmlEscape(e.Target))
+		if len(e.Anchors) > 0 {
+			fmt.Fprintf(bw, "      <data key=\"anchors\">%s</data>\n", xmlEscape(strings.Join(e.Anchors, " | ")))
+		}
+		fmt.Fprintf(bw, "      <data key=\"count\">%d</data>\n", e.Count)
+		fmt.Fprintln(bw, "    </edge>")
+	}
+
+	fmt.Fprintln(bw, "  </graph>")
+	fmt.Fprintln(bw, "</graphml>")
+	return bw.Flush()
+}
+
+// WriteGraphJSON writes the link graph as a json object with nodes and edges lists
+func (r *Response) WriteGraphJSON(w io.Writer, opts GraphOptions) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(buildExportGraph(r, opts))
+}
+
+// WriteGraph writes the link graph in the given format, one of dot, graphml or json
+func (r *Response) WriteGraph(w io.Writer, format string, opts GraphOptions) error {
+	switch strings.ToLower(format) {
+	case "dot":
+		return r.WriteDOT(w, opts)
+	case "graphml":
+		return r.WriteGraphML(w, opts)
+	case "json":
+		return r.WriteGraphJSON(w, opts)
+	default:
+		return fmt.Errorf("unknown graph format %q, expected dot, graphml or json", format)
+	}
+}
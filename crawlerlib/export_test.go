@@ -0,0 +1,168 @@
+package crawlerlib
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testGraphResponse returns a response of a small crawl with a failed url, an image and an external link
+func testGraphResponse() *Response {
+	lg := newLinkGraph()
+	edges := []*LinkEdge{
+		{Source: "http://t.com/", Target: "http://t.com/blog/a", Anchor: "A", InScope: true, Kind: LinkAnchor, Follow: true},
+		{Source: "http://t.com/", Target: "http://t.com/blog/b", Anchor: "B", InScope: true, Kind: LinkAnchor, Follow: true},
+		{Source: "http://t.com/", Target: "http://ext.com/", Anchor: "Ext", Kind: LinkAnchor, Follow: true},
+		{Source: "http://t.com/", Target: "http://t.com/logo", Anchor: "Logo", InScope: true, Kind: LinkImage},
+		{Source: "http://t.com/blog/a", Target: "http://t.com/blog/b", Anchor: "B", InScope: true, Kind: LinkAnchor, Follow: true},
+		{Source: "http://t.com/blog/a", Target: "http://t.com/", Anchor: "Home", InScope: true, Kind: LinkAnchor, Follow: true},
+	}
+	for _, e := range edges {
+		addEdge(lg, e)
+	}
+
+	return &Response{
+		ErrorURLs: map[string]error{
+			"http://t.com/blog/b": errors.New("url responsed with code 404"),
+			"http://t.com/logo":   errors.New("unknown content type: image/png"),
+		},
+		Pages: map[string]*Page{
+			"http://t.com/":       {URL: "http://t.com/", Depth: 0, StatusCode: 200},
+			"http://t.com/blog/a": {URL: "http://t.com/blog/a", Depth: 1, StatusCode: 200},
+			"http://t.com/blog/b": {URL: "http://t.com/blog/b", Depth: 1, StatusCode: 404},
+			"http://t.com/logo":   {URL: "http://t.com/logo", Depth: 1, StatusCode: 200},
+		},
+		Links: lg,
+	}
+}
+
+func Test_collapseURL(t *testing.T) {
+	tests := []struct {
+		url      string
+		depth    int
+		expected string
+	}{
+		{url: "http://t.com/blog/2020/a", depth: 0, expected: "http://t.com/blog/2020/a"},
+		{url: "http://t.com/blog/2020/a", depth: 1, expected: "http://t.com/blog/"},
+		{url: "http://t.com/blog/2020/a", depth: 2, expected: "http://t.com/blog/2020/"},
+		{url: "http://t.com/blog", depth: 2, expected: "http://t.com/blog/"},
+		{url: "http://t.com", depth: 1, expected: "http://t.com/"},
+	}
+
+	for _, c := range tests {
+		if got := collapseURL(c.url, c.depth); got != c.expected {
+			t.Fatalf("expected %s for %s at depth %d but got %s", c.expected, c.url, c.depth, got)
+		}
+	}
+}
+
+func TestResponse_WriteGraphJSON(t *testing.T) {
+	tests := []struct {
+		opts  GraphOptions
+		nodes int
+		edges int
+		check func(eg *exportGraph) bool
+	}{
+		{
+			nodes: 5,
+			edges: 5,
+			check: func(eg *exportGraph) bool {
+				b := eg.Nodes[3]
+				return b.ID == "http://t.com/blog/b" && b.Depth == 1 && b.StatusCode == 404 &&
+					b.Error == "url responsed with code 404" && b.Failed == 1
+			},
+		},
+		{
+			opts:  GraphOptions{InScopeOnly: true},
+			nodes: 4,
+			edges: 4,
+		},
+		{
+			opts:  GraphOptions{Assets: true},
+			nodes: 5,
+			edges: 6,
+		},
+		{
+			opts:  GraphOptions{CollapseDepth: 1},
+			nodes: 4,
+			edges: 3,
+			check: func(eg *exportGraph) bool {
+				blog := eg.Nodes[2]
+				home := eg.Edges[1]
+				return blog.ID == "http://t.com/blog/" && blog.URLs == 2 && blog.Failed == 1 && blog.StatusCode == 200 &&
+					home.Target == "http://t.com/blog/" && home.Count == 2
+			},
+		},
+	}
+
+	for i, c := range tests {
+		var buf bytes.Buffer
+		if err := testGraphResponse().WriteGraphJSON(&buf, c.opts); err != nil {
+			t.Fatal(err)
+		}
+
+		var eg exportGraph
+		if err := json.Unmarshal(buf.Bytes(), &eg); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(eg.Nodes) != c.nodes || len(eg.Edges) != c.edges {
+			t.Fatalf("case %d: expected %d nodes %d edges but got %d %d", i, c.nodes, c.edges, len(eg.Nodes), len(eg.Edges))
+		}
+
+		if c.check != nil && !c.check(&eg) {
+			t.Fatalf("case %d: unexpected graph %s", i, buf.String())
+		}
+	}
+}
+
+func TestResponse_WriteGraph(t *testing.T) {
+	tests := []struct {
+		format   string
+		contains []string
+		error    bool
+	}{
+		{
+			format: "dot",
+			contains: []string{
+				"digraph crawl {",
+				`"http://t.com/" -> "http://t.com/blog/a";`,
+				`"http://t.com/blog/b" [label="http://t.com/blog/b\ndepth: 1 status: 404\nerror: url responsed with code 404", color=red];`,
+				`"http://ext.com/" [label="http://ext.com/\ndepth: -1", color=grey, fontcolor=grey];`,
+			},
+		},
+		{
+			format: "graphml",
+			contains: []string{
+				`<node id="http://t.com/blog/b">`,
+				`<data key="error">url responsed with code 404</data>`,
+				`<edge id="e0" source="http://t.com/" target="http://ext.com/">`,
+			},
+		},
+		{format: "json", contains: []string{`"nodes": [`}},
+		{format: "csv", error: true},
+	}
+
+	for _, c := range tests {
+		var buf bytes.Buffer
+		err := testGraphResponse().WriteGraph(&buf, c.format, GraphOptions{})
+		if (err != nil) != c.error {
+			t.Fatalf("%s: expected error %t but got %v", c.format, c.error, err)
+		}
+
+		for _, s := range c.contains {
+			if !strings.Contains(buf.String(), s) {
+				t.Fatalf("%s: expected output to contain %s but got\n%s", c.format, s, buf.String())
+			}
+		}
+
+		if c.format == "graphml" {
+			if err := xml.Unmarshal(buf.Bytes(), new(struct{})); err != nil {
+				t.Fatalf("graphml is not valid xml: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package crawlerlib
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LinkKind is the element and attribute a link was found in
+type LinkKind string
+
+const (
+	LinkAnchor       LinkKind = "a[href]"         // LinkAnchor is a hyperlink
+	LinkArea         LinkKind = "area[href]"      // LinkArea is a hyperlink of an image map
+	LinkResource     LinkKind = "link[href]"      // LinkResource is a stylesheet, icon, feed or other resource of the page
+	LinkCanonical    LinkKind = "link[canonical]" // LinkCanonical is the canonical url of the page from a link tag or Link header
+	LinkImage        LinkKind = "img[src]"        // LinkImage is an image
+	LinkImageSrcset  LinkKind = "img[srcset]"     // LinkImageSrcset is a candidate of the srcset of an image
+	LinkSourceSrcset LinkKind = "source[srcset]"  // LinkSourceSrcset is a candidate of the srcset of a picture source
+	LinkScript       LinkKind = "script[src]"     // LinkScript is an external script
+	LinkFrame        LinkKind = "iframe[src]"     // LinkFrame is a page embedded in an iframe
+	LinkForm         LinkKind = "form[action]"    // LinkForm is where a form is submitted to
+	LinkRefresh      LinkKind = "meta[refresh]"   // LinkRefresh is where a meta refresh tag redirects to
+)
+
+// LinkKinds are all the kinds of links extracted from pages
+var LinkKinds = []LinkKind{
+	LinkAnchor, LinkArea, LinkResource, LinkCanonical, LinkImage, LinkImageSrcset,
+	LinkSourceSrcset, LinkScript, LinkFrame, LinkForm, LinkRefresh,
+}
+
+// DefaultFollowKinds are the kinds of links crawled for more links when none are configured
+var DefaultFollowKinds = []LinkKind{LinkAnchor, LinkArea, LinkCanonical, LinkFrame, LinkRefresh}
+
+// ExtractConfig says which links are extracted from the pages and which of them are crawled
+type ExtractConfig struct {
+	Kinds   []LinkKind // Kinds of links extracted, empty means LinkKinds
+	Follow  []LinkKind // Follow are the kinds of links crawled, empty means DefaultFollowKinds. Others are only recorded
+	Schemes []string   // Schemes of the urls extracted, empty means DefaultSchemes. Links to other schemes are only counted
+}
+
+// extractor is a validated ExtractConfig
+type extractor struct {
+	kinds      map[LinkKind]bool // kinds of links extracted
+	follow     map[LinkKind]bool // follow are the kinds of links crawled
+	schemes    map[string]bool   // schemes of the urls extracted
+	normalizer *Normalizer       // normalizer rewrites the extracted urls, nil leaves them as they are
+}
+
+// newExtractor returns the extractor of the config, failing on unknown kinds and invalid schemes
+func newExtractor(cfg ExtractConfig) (*extractor, error) {
+	kinds, follow, schemes := cfg.Kinds, cfg.Follow, cfg.Schemes
+	if len(kinds) == 0 {
+		kinds = LinkKinds
+	}
+
+	if len(follow) == 0 {
+		follow = DefaultFollowKinds
+	}
+
+	if len(schemes) == 0 {
+		schemes = DefaultSchemes
+	}
+
+	ex := &extractor{kinds: make(map[LinkKind]bool), follow: make(map[LinkKind]bool), schemes: make(map[string]bool)}
+	for _, k := range kinds {
+		if !isLinkKind(k) {
+			return nil, fmt.Errorf("unknown link kind %q", k)
+		}
+		ex.kinds[k] = true
+	}
+
+	for _, k := range follow {
+		if !isLinkKind(k) {
+			return nil, fmt.Errorf("unknown link kind %q", k)
+		}
+		ex.follow[k] = true
+	}
+
+	for _, s := range schemes {
+		scheme := hrefScheme(s + ":")
+		if scheme == "" || scheme != strings.ToLower(s) {
+			return nil, fmt.Errorf("invalid url scheme %q", s)
+		}
+		ex.schemes[scheme] = true
+	}
+
+	return ex, nil
+}
+
+// isAssetKind says if links of the kind point to resources of the page rather than other pages
+func isAssetKind(k LinkKind) bool {
+	switch k {
+	case LinkResource, LinkImage, LinkImageSrcset, LinkSourceSrcset, LinkScript:
+		return true
+	}
+
+	return false
+}
+
+// isLinkKind says if k is one of LinkKinds
+func isLinkKind(k LinkKind) bool {
+	for _, lk := range LinkKinds {
+		if lk == k {
+			return true
+		}
+	}
+
+	return false
+}
+
+// refreshURL returns the url of the content of a meta refresh tag like "5; url=/next",
+// empty if it only reloads the page
+func refreshURL(content string) string {
+	i := strings.IndexAny(content, ";,")
+	if i == -1 {
+		return ""
+	}
+
+	v := strings.TrimSpace(content[i+1:])
+	if len(v) > 3 && strings.EqualFold(v[:3], "url") {
+		if rest := strings.TrimSpace(v[3:]); strings.HasPrefix(rest, "=") {
+			v = strings.TrimSpace(rest[1:])
+		}
+	}
+
+	if len(v) > 1 && (v[0] == '"' || v[0] == '\'') {
+		if end := strings.IndexByte(v[1:], v[0]); end != -1 {
+			v = v[1 : end+1]
+		}
+	}
+
+	return v
+}
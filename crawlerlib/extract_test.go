@@ -0,0 +1,117 @@
+package crawlerlib
+
+import (
+	"bytes"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func Test_newExtractor(t *testing.T) {
+	tests := []struct {
+		cfg   ExtractConfig
+		error bool
+	}{
+		{cfg: ExtractConfig{}},
+		{cfg: ExtractConfig{Kinds: []LinkKind{LinkAnchor, LinkImage}, Follow: []LinkKind{LinkAnchor}}},
+		{cfg: ExtractConfig{Kinds: []LinkKind{"a"}}, error: true},
+		{cfg: ExtractConfig{Follow: []LinkKind{"img"}}, error: true},
+		{cfg: ExtractConfig{Schemes: []string{"HTTPS", "ftp"}}},
+		{cfg: ExtractConfig{Schemes: []string{"http:"}}, error: true},
+		{cfg: ExtractConfig{Schemes: []string{"1http"}}, error: true},
+	}
+
+	for i, c := range tests {
+		if _, err := newExtractor(c.cfg); (err != nil) != c.error {
+			t.Fatalf("case %d: expected error %t but got %v", i, c.error, err)
+		}
+	}
+}
+
+func Test_refreshURL(t *testing.T) {
+	tests := []struct {
+		content  string
+		expected string
+	}{
+		{content: "5; url=/next", expected: "/next"},
+		{content: "0;URL='http://t.com/a b'", expected: "http://t.com/a b"},
+		{content: `3, url = "/quoted"`, expected: "/quoted"},
+		{content: "0; /bare", expected: "/bare"},
+		{content: "30", expected: ""},
+	}
+
+	for _, c := range tests {
+		if got := refreshURL(c.content); got != c.expected {
+			t.Fatalf("expected %q for %q but got %q", c.expected, c.content, got)
+		}
+	}
+}
+
+func Test_scrapeHTMLLinks(t *testing.T) {
+	sourceURL, _ := url.Parse("http://www.test.com/dir/page")
+	rawHTML := `<html><head><base href="/base/"><base href="/ignored/">
+	<link rel="stylesheet" href="style.css"><script src="app.js"></script><script>inline()</script>
+	<meta http-equiv="Refresh" content="10; url=next"></head>
+	<body><a href="a">A</a><a href="javascript:void(0)">JS</a>
+	<img src="img.png" srcset="img-2x.png 2x" alt="Img"><img src="data:image/png;base64,xx">
+	<picture><source srcset="pic.webp"></picture>
+	<map><area href="/area" alt="Area"></map>
+	<iframe src="https://embed.com/v"></iframe>
+	<form action="/search"></form><form></form></body></html>`
+
+	type link struct {
+		url    string
+		kind   LinkKind
+		follow bool
+		anchor string
+	}
+
+	tests := []struct {
+		cfg      ExtractConfig
+		expected []link
+		invalid  []string
+	}{
+		{
+			expected: []link{
+				{url: "http://www.test.com/base/style.css", kind: LinkResource},
+				{url: "http://www.test.com/base/app.js", kind: LinkScript},
+				{url: "http://www.test.com/base/next", kind: LinkRefresh, follow: true},
+				{url: "http://www.test.com/base/a", kind: LinkAnchor, follow: true, anchor: "A"},
+				{url: "http://www.test.com/base/img.png", kind: LinkImage, anchor: "Img"},
+				{url: "http://www.test.com/base/img-2x.png", kind: LinkImageSrcset, anchor: "Img"},
+				{url: "http://www.test.com/base/pic.webp", kind: LinkSourceSrcset},
+				{url: "http://www.test.com/area", kind: LinkArea, follow: true, anchor: "Area"},
+				{url: "https://embed.com/v", kind: LinkFrame, follow: true},
+				{url: "http://www.test.com/search", kind: LinkForm},
+			},
+		},
+		{
+			cfg: ExtractConfig{Kinds: []LinkKind{LinkAnchor, LinkImage}, Follow: []LinkKind{LinkImage}},
+			expected: []link{
+				{url: "http://www.test.com/base/a", kind: LinkAnchor, anchor: "A"},
+				{url: "http://www.test.com/base/img.png", kind: LinkImage, follow: true, anchor: "Img"},
+			},
+		},
+	}
+
+	for i, c := range tests {
+		ex, err := newExtractor(c.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hp := scrapeHTML(ex, sourceURL, bytes.NewReader([]byte(rawHTML)))
+		var got []link
+		for _, l := range hp.links {
+			got = append(got, link{url: l.url.String(), kind: l.kind, follow: l.follow, anchor: l.anchor})
+		}
+
+		if !reflect.DeepEqual(got, c.expected) {
+			t.Fatalf("case %d: expected links %+v but got %+v", i, c.expected, got)
+		}
+
+		if !reflect.DeepEqual(hp.invalidURLs, c.invalid) {
+			t.Fatalf("case %d: expected invalid urls %v but got %v", i, c.invalid, hp.invalidURLs)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package crawlerlib
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultUserAgent is sent with every request when no user agent is configured
+const DefaultUserAgent = "webcrawler/1.0 (+https://github.com/priteshgudge/webcrawler)"
+
+// defaultRequestTimeout bounds a single request when no request timeout is configured
+const defaultRequestTimeout = 30 * time.Second
+
+// FetcherConfig configures how pages are requested
+type FetcherConfig struct {
+	Client             *http.Client      // Client is used as is when set, Transport, ProxyURL and the TLS options must be empty
+	Transport          http.RoundTripper // Transport of the client, defaults to a copy of http.DefaultTransport
+	RequestTimeout     time.Duration     // RequestTimeout bounds a single request including reading the body, defaults to 30s
+	TotalTimeout       time.Duration     // TotalTimeout bounds fetching a url including retries, 0 means no limit
+	UserAgent          string            // UserAgent sent with every request, its product token picks the robots.txt group
+	Headers            http.Header       // Headers are sent with every request
+	ProxyURL           string            // ProxyURL is used for HTTP and HTTPS requests, defaults to the environment proxy
+	CABundle           string            // CABundle is a PEM file with CAs to trust on top of the system ones
+	InsecureSkipVerify bool              // InsecureSkipVerify skips TLS certificate checks, meant for staging sites
+}
+
+// fetcher holds the state scrapers share when fetching pages
+type fetcher struct {
+	client         *http.Client   // client makes the requests
+	header         http.Header    // header is sent with every request, includes the User-Agent
+	requestTimeout time.Duration  // requestTimeout bounds a single attempt
+	totalTimeout   time.Duration  // totalTimeout bounds all attempts of a url, 0 means no limit
+	robots         *robotsCache   // robots is nil when robots.txt is ignored
+	scheduler      *hostScheduler // scheduler enforces the per host request limits
+	retry          RetryPolicy    // retry decides which failed fetches are tried again
+	extractor      *extractor     // extractor says which links are extracted from the pages and followed
+	checkAssets    bool           // checkAssets records non html pages as assets instead of failing them
+	followNofollow bool           // followNofollow follows the links nofollow directives ask us not to
+	maxBodyBytes   int64          // maxBodyBytes read from a single page, 0 means no limit
+}
+
+// newFetcher returns a fetcher for the given config. retries are disabled till a retry policy is set
+// and every kind of link is extracted and normalized with the default steps till an extractor is set
+func newFetcher(cfg FetcherConfig) (*fetcher, error) {
+	client, err := newHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &fetcher{
+		client:         client,
+		header:         make(http.Header),
+		requestTimeout: cfg.RequestTimeout,
+		totalTimeout:   cfg.TotalTimeout,
+		retry:          RetryPolicy{MaxAttempts: 1},
+	}
+	f.extractor, _ = newExtractor(ExtractConfig{})
+	f.extractor.normalizer, _ = NewNormalizer(NormalizeConfig{})
+
+	for k, vs := range cfg.Headers {
+		for _, v := range vs {
+			f.header.Add(k, v)
+		}
+	}
+
+	f.header.Set("User-Agent", DefaultUserAgent)
+	if cfg.UserAgent != "" {
+		f.header.Set("User-Agent", cfg.UserAgent)
+	}
+
+	if f.requestTimeout == 0 {
+		f.requestTimeout = defaultRequestTimeout
+	}
+
+	return f, nil
+}
+
+// newHTTPClient returns the client described by the config
+func newHTTPClient(cfg FetcherConfig) (*http.Client, error) {
+	custom := cfg.ProxyURL != "" || cfg.CABundle != "" || cfg.InsecureSkipVerify
+	if cfg.Client != nil {
+		if cfg.Transport != nil || custom {
+			return nil, errors.New("transport, proxy and TLS options can't be used with a custom client")
+		}
+
+		return cfg.Client, nil
+	}
+
+	if !custom {
+		if cfg.Transport != nil {
+			return &http.Client{Transport: cfg.Transport}, nil
+		}
+
+		return &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()}, nil
+	}
+
+	base := http.DefaultTransport
+	if cfg.Transport != nil {
+		base = cfg.Transport
+	}
+
+	ht, ok := base.(*http.Transport)
+	if !ok {
+		return nil, errors.New("proxy and TLS options need an *http.Transport")
+	}
+
+	t := ht.Clone()
+	if cfg.ProxyURL != "" {
+		pu, err := url.Parse(cfg.ProxyURL)
+		if err != nil || pu.Host == "" {
+			return nil, fmt.Errorf("invalid proxy url: %s", cfg.ProxyURL)
+		}
+
+		t.Proxy = http.ProxyURL(pu)
+	}
+
+	if cfg.CABundle != "" || cfg.InsecureSkipVerify {
+		if t.TLSClientConfig == nil {
+			t.TLSClientConfig = &tls.Config{}
+		}
+
+		t.TLSClientConfig.InsecureSkipVerify = cfg.InsecureSkipVerify
+	}
+
+	if cfg.CABundle != "" {
+		pool, err := loadCABundle(cfg.CABundle)
+		if err != nil {
+			return nil, err
+		}
+
+		t.TLSClientConfig.RootCAs = pool
+	}
+
+	return &http.Client{Transport: t}, nil
+}
+
+// loadCABundle returns the system cert pool with the certs of the PEM file added
+func loadCABundle(file string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %v", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", file)
+	}
+
+	return pool, nil
+}
+
+// newRequest returns a request for the url carrying the fetcher headers
+func newRequest(ctx context.Context, f *fetcher, method string, u *url.URL) (*http.Request, error) {
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// every request gets its own copy, the fetcher header is shared by the scrapers
+	for k, vs := range f.header {
+		req.Header[k] = append([]string(nil), vs...)
+	}
+
+	return req.WithContext(ctx), nil
+}
+
+// robotsAgent returns the product token of the fetcher user agent, e.g. webcrawler for webcrawler/1.0
+func robotsAgent(f *fetcher) string {
+	ua := f.header.Get("User-Agent")
+	if i := strings.IndexAny(ua, "/ "); i != -1 {
+		ua = ua[:i]
+	}
+
+	if ua == "" {
+		return defaultRobotsAgent
+	}
+
+	return ua
+}
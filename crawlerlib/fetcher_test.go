@@ -0,0 +1,164 @@
+package crawlerlib
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_newFetcher(t *testing.T) {
+	tests := []struct {
+		cfg   FetcherConfig
+		error bool
+	}{
+		{cfg: FetcherConfig{}},
+		{cfg: FetcherConfig{ProxyURL: "http://proxy.test.com:3128", InsecureSkipVerify: true}},
+		{cfg: FetcherConfig{Client: http.DefaultClient, ProxyURL: "http://proxy.test.com:3128"}, error: true},
+		{cfg: FetcherConfig{ProxyURL: "proxy"}, error: true},
+		{cfg: FetcherConfig{CABundle: "does-not-exist.pem"}, error: true},
+	}
+
+	for _, c := range tests {
+		_, err := newFetcher(c.cfg)
+		if (err != nil) != c.error {
+			t.Fatalf("expected error %t for %+v but got %v", c.error, c.cfg, err)
+		}
+	}
+}
+
+func Test_fetcherHeaders(t *testing.T) {
+	got := make(chan http.Header, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header
+	}))
+	defer ts.Close()
+
+	f, err := newFetcher(FetcherConfig{
+		UserAgent: "linkbot/2.0",
+		Headers:   http.Header{"X-Team": []string{"seo"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse(ts.URL)
+	fetchURL(context.Background(), f, 0, u)
+	h := <-got
+	if h.Get("User-Agent") != "linkbot/2.0" || h.Get("X-Team") != "seo" {
+		t.Fatalf("expected configured headers but got %v", h)
+	}
+
+	if a := robotsAgent(f); a != "linkbot" {
+		t.Fatalf("expected robots agent linkbot but got %s", a)
+	}
+}
+
+func Test_fetcherTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "crawler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bundle := filepath.Join(dir, "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := ioutil.WriteFile(bundle, caPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		cfg   FetcherConfig
+		error bool
+	}{
+		{cfg: FetcherConfig{}, error: true},
+		{cfg: FetcherConfig{CABundle: bundle}},
+		{cfg: FetcherConfig{InsecureSkipVerify: true}},
+	}
+
+	u, _ := url.Parse(ts.URL)
+	for _, c := range tests {
+		f, err := newFetcher(c.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		md := fetchURL(context.Background(), f, 0, u)
+		if (md.err != nil) != c.error {
+			t.Fatalf("expected error %t for %+v but got %v", c.error, c.cfg, md.err)
+		}
+	}
+}
+
+func Test_fetcherProxy(t *testing.T) {
+	got := make(chan string, 1)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.URL.String()
+		w.Header().Set("Content-Type", "text/html")
+	}))
+	defer proxy.Close()
+
+	f, err := newFetcher(FetcherConfig{ProxyURL: proxy.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse("http://site.test/page")
+	md := fetchURL(context.Background(), f, 0, u)
+	if md.err != nil {
+		t.Fatalf("expected request through the proxy to succeed: %v", md.err)
+	}
+
+	if pu := <-got; pu != u.String() {
+		t.Fatalf("expected proxy to get %s but got %s", u, pu)
+	}
+}
+
+func Test_fetcherTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer ts.Close()
+
+	f, err := newFetcher(FetcherConfig{RequestTimeout: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse(ts.URL)
+	md := fetchURL(context.Background(), f, 0, u)
+	if md.err == nil || !isTimeout(md.err) {
+		t.Fatalf("expected request to time out but got %v", md.err)
+	}
+}
+
+func Test_newRequestHeaders(t *testing.T) {
+	f, err := newFetcher(FetcherConfig{Headers: http.Header{"X-Team": []string{"seo"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse("http://test.com/")
+	req, _ := newRequest(context.Background(), f, http.MethodGet, u)
+	req.Header["X-Team"][0] = "changed"
+	req.Header.Add("X-Team", "more")
+
+	other, _ := newRequest(context.Background(), f, http.MethodGet, u)
+	if vs := other.Header["X-Team"]; len(vs) != 1 || vs[0] != "seo" {
+		t.Fatalf("expected requests not to share headers but got %v", vs)
+	}
+}
@@ -0,0 +1,156 @@
+package crawlerlib
+
+import (
+	"net/url"
+	"sort"
+	"time"
+)
+
+// frontierItem is a url handed to the scrapers through the work queue
+type frontierItem struct {
+	depth int      // depth the url is crawled at
+	url   *url.URL // url to be crawled or checked
+	asset bool     // asset says if the url is an asset to be checked rather than crawled
+}
+
+// pendingDepths returns the depths with urls left to crawl, lowest first
+func pendingDepths(g *delegator) []int {
+	depths := make([]int, 0, len(g.unScrapped))
+	for d := range g.unScrapped {
+		depths = append(depths, d)
+	}
+	sort.Ints(depths)
+
+	return depths
+}
+
+// hasPending says if urls are left to crawl or check, the ones waiting for robots.txt included
+func hasPending(g *delegator) bool {
+	return len(g.unScrapped) > 0 || len(g.unchecked) > 0 || len(g.robotsWaits) > 0
+}
+
+// acquireHost takes a request slot of the url host when the host is eligible under the host limits.
+// Hosts refused are remembered in refused for the rest of the scan and g.hostWait keeps the shortest
+// time till one of them earns a token. Every host is eligible without a scheduler
+func acquireHost(g *delegator, u *url.URL, refused map[string]bool) bool {
+	if g.scheduler == nil {
+		return true
+	}
+
+	if refused[u.Host] {
+		return false
+	}
+
+	if g.robots != nil {
+		setCrawlDelay(g.scheduler, u.Host, robotsOrigin(u), robotsCrawlDelay(g.robots, u))
+	}
+
+	g.scheduler.mu.Lock()
+	ok, wait := tryAcquire(g.scheduler, u.Host, time.Now())
+	g.scheduler.mu.Unlock()
+	if ok {
+		return true
+	}
+
+	refused[u.Host] = true
+	if wait > 0 && (g.hostWait == 0 || wait < g.hostWait) {
+		g.hostWait = wait
+	}
+
+	return false
+}
+
+// nextItem returns the url to hand out to the scrapers next with the request slot of its host taken,
+// nil when there is none. Pages go first, lowest depth first, and assets take the room left. Urls of
+// hosts that aren't eligible yet stay queued, so a busy or throttled host doesn't hold back the others.
+// Pages past the budget are moved to the frontier on the way, once the budget is spent the assets are
+// left unchecked
+func nextItem(g *delegator) *frontierItem {
+	g.hostWait = 0
+	refused := make(map[string]bool)
+	for _, d := range pendingDepths(g) {
+		var item *frontierItem
+		urls := g.unScrapped[d]
+		kept := urls[:0]
+		for i, u := range urls {
+			if !budgetAllows(g, u) {
+				g.frontier[d] = append(g.frontier[d], u)
+				continue
+			}
+
+			if !acquireHost(g, u, refused) {
+				kept = append(kept, u)
+				continue
+			}
+
+			item = &frontierItem{depth: d, url: u}
+			kept = append(kept, urls[i+1:]...)
+			break
+		}
+
+		g.unScrapped[d] = kept
+		if len(kept) == 0 {
+			delete(g.unScrapped, d)
+		}
+
+		if item != nil {
+			return item
+		}
+	}
+
+	if len(g.unchecked) == 0 {
+		return nil
+	}
+
+	if b := budgetSpent(g); b != "" {
+		hitBudget(g, b)
+		stopScheduling(g)
+		return nil
+	}
+
+	for i, u := range g.unchecked {
+		if acquireHost(g, u, refused) {
+			g.unchecked = append(g.unchecked[:i], g.unchecked[i+1:]...)
+			return &frontierItem{url: u, asset: true}
+		}
+	}
+
+	return nil
+}
+
+// handedOut records that the url was taken by the scrapers
+func handedOut(g *delegator, item *frontierItem) {
+	if !item.asset {
+		countFetch(g, item.url)
+	}
+
+	g.inFlight++
+}
+
+// requeueThrottled puts the url of a 429 or 503 response back to be crawled once its host is eligible
+// again, carrying the attempts made so far. It returns false when the url ran out of retries or wasn't
+// throttled, the dump is then processed with every attempt recorded
+func requeueThrottled(g *delegator, item *frontierItem, md *scraperDump) bool {
+	key := item.url.String()
+	if prev, ok := g.throttled[key]; ok && md.page != nil {
+		md.page.Attempts += prev.Attempts
+		md.page.Errors = append(prev.Errors, md.page.Errors...)
+	}
+	delete(g.throttled, key)
+
+	if !isThrottled(md.statusCode) || md.page.Attempts > maxThrottleRetries {
+		return false
+	}
+
+	logf(g.logger, "requeueing throttled %s\n", key)
+	g.throttled[key] = md.page
+	if item.asset {
+		g.unchecked = append(g.unchecked, item.url)
+		return true
+	}
+
+	// the page is counted against the budget again when it is handed out
+	uncountFetch(g, item.url)
+	g.unScrapped[item.depth] = append(g.unScrapped[item.depth], item.url)
+	return true
+}
@@ -0,0 +1,56 @@
+package crawlerlib
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// syntheticSite serves a tree of pages where page i links to pages 10i+1 to 10i+10 below pages.
+// One page in ten is slow, like the heavy pages of a real site
+func syntheticSite(pages int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/p/"))
+		if i%10 == 5 {
+			time.Sleep(20 * time.Millisecond)
+		} else {
+			time.Sleep(time.Millisecond)
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		for c := 10*i + 1; c <= 10*i+10 && c < pages; c++ {
+			fmt.Fprintf(w, `<a href="/p/%d">%d</a>`, c, c)
+		}
+	}))
+}
+
+func BenchmarkCrawler_Run(b *testing.B) {
+	const pages = 500
+	ts := syntheticSite(pages)
+	defer ts.Close()
+
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		cr, err := New(WithIgnoreRobots(true), WithOutput(nil), WithConcurrency(8), WithMaxDepth(-1),
+			WithPoliteness(Politeness{Default: HostLimit{MaxInFlight: 8}}))
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		resp, err := cr.Run(context.Background(), ts.URL+"/p/0")
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		if len(resp.Pages) != pages {
+			b.Fatalf("expected %d pages but got %d", pages, len(resp.Pages))
+		}
+	}
+
+	b.ReportMetric(float64(pages*b.N)/time.Since(start).Seconds(), "pages/s")
+}
@@ -0,0 +1,82 @@
+package crawlerlib
+
+import "sort"
+
+// LinkEdge is a link from a crawled page to a url
+type LinkEdge struct {
+	Source  string   // Source is the page the link was found on
+	Target  string   // Target is the url the link points to
+	Anchor  string   // Anchor is the text of the link, the alt of its images when it has no text or of the image itself
+	Rel     []string // Rel holds the lower cased rel attribute values of the link
+	InScope bool     // InScope says if the target passes the domain regex of the crawl
+	Kind    LinkKind // Kind is the element and attribute the link was found in
+	Follow  bool     // Follow says if the link was crawled for more links, assets are only recorded
+}
+
+// LinkGraph is the directed graph of the links found while crawling
+type LinkGraph struct {
+	Edges    []*LinkEdge            // Edges in the order they were found
+	inbound  map[string][]*LinkEdge // inbound edges keyed by target
+	outbound map[string][]*LinkEdge // outbound edges keyed by source
+}
+
+// newLinkGraph returns an empty link graph
+func newLinkGraph() *LinkGraph {
+	return &LinkGraph{
+		inbound:  make(map[string][]*LinkEdge),
+		outbound: make(map[string][]*LinkEdge),
+	}
+}
+
+// addEdge adds the edge to the graph
+func addEdge(lg *LinkGraph, e *LinkEdge) {
+	lg.Edges = append(lg.Edges, e)
+	lg.inbound[e.Target] = append(lg.inbound[e.Target], e)
+	lg.outbound[e.Source] = append(lg.outbound[e.Source], e)
+}
+
+// Inbound returns the links pointing to the url
+func (lg *LinkGraph) Inbound(u string) []*LinkEdge {
+	if lg == nil {
+		return nil
+	}
+
+	return lg.inbound[u]
+}
+
+// Outbound returns the links found on the page of the url
+func (lg *LinkGraph) Outbound(u string) []*LinkEdge {
+	if lg == nil {
+		return nil
+	}
+
+	return lg.outbound[u]
+}
+
+// Referrers returns the pages linking to the url, sorted and without repeats
+func (lg *LinkGraph) Referrers(u string) []string {
+	seen := make(map[string]bool)
+	var sources []string
+	for _, e := range lg.Inbound(u) {
+		if seen[e.Source] {
+			continue
+		}
+
+		seen[e.Source] = true
+		sources = append(sources, e.Source)
+	}
+
+	sort.Strings(sources)
+	return sources
+}
+
+// FailedReferrers returns the links pointing to every url that failed to crawl, keyed by the failed url.
+// Failed seeds have no links pointing to them and map to nil
+func (r *Response) FailedReferrers() map[string][]*LinkEdge {
+	refs := make(map[string][]*LinkEdge)
+	for u := range r.ErrorURLs {
+		refs[u] = r.Links.Inbound(u)
+	}
+
+	return refs
+}
@@ -0,0 +1,75 @@
+package crawlerlib
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestResponse_Links(t *testing.T) {
+	ts := testSite(map[string][]string{
+		"/":  {"/a", "/missing", "https://external.com/"},
+		"/a": {"/", "/missing"},
+	})
+	defer ts.Close()
+
+	c, err := New(WithIgnoreRobots(true), WithOutput(nil), WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := c.Run(context.Background(), ts.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trim := func(edges []*LinkEdge) (s []string) {
+		for _, e := range edges {
+			s = append(s, strings.TrimPrefix(e.Source, ts.URL)+" -> "+strings.TrimPrefix(e.Target, ts.URL))
+		}
+		return s
+	}
+
+	tests := []struct {
+		got      []*LinkEdge
+		expected []string
+	}{
+		{
+			got:      resp.Links.Outbound(ts.URL + "/"),
+			expected: []string{"/ -> /a", "/ -> /missing", "/ -> https://external.com/"},
+		},
+		{
+			got:      resp.Links.Inbound(ts.URL + "/"),
+			expected: []string{"/a -> /"},
+		},
+		{
+			got:      resp.FailedReferrers()[ts.URL+"/missing"],
+			expected: []string{"/ -> /missing", "/a -> /missing"},
+		},
+	}
+
+	for i, c := range tests {
+		got := trim(c.got)
+		if i == 2 {
+			// both pages are crawled at the same depth in any order
+			sort.Strings(got)
+		}
+
+		if !reflect.DeepEqual(got, c.expected) {
+			t.Fatalf("case %d: expected %v but got %v", i, c.expected, got)
+		}
+	}
+
+	for _, e := range resp.Links.Outbound(ts.URL + "/") {
+		inScope := !strings.HasPrefix(e.Target, "https://external.com")
+		if e.InScope != inScope || e.Anchor == "" {
+			t.Fatalf("unexpected edge %+v", e)
+		}
+	}
+
+	if refs := resp.Links.Referrers(ts.URL + "/missing"); len(refs) != 2 {
+		t.Fatalf("expected 2 referrers but got %v", refs)
+	}
+}
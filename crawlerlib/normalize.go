@@ -0,0 +1,395 @@
+package crawlerlib
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// The steps of the url normalization pipeline, in the order they run
+const (
+	NormalizeLowercase     = "lowercase"        // NormalizeLowercase lowercases the scheme and host
+	NormalizePunycode      = "punycode"         // NormalizePunycode converts internationalized host names to punycode
+	NormalizeDefaultPort   = "default-port"     // NormalizeDefaultPort removes :80 from http and :443 from https urls
+	NormalizeEncoding      = "percent-encoding" // NormalizeEncoding decodes escaped unreserved characters and uppercases escapes
+	NormalizeDotSegments   = "dot-segments"     // NormalizeDotSegments resolves . and .. path segments
+	NormalizeStripParams   = "strip-params"     // NormalizeStripParams removes the tracking query params
+	NormalizeSortQuery     = "sort-query"       // NormalizeSortQuery sorts the query params by name
+	NormalizeTrailingSlash = "trailing-slash"   // NormalizeTrailingSlash applies the trailing slash policy
+)
+
+// NormalizeSteps are the names of the normalization steps in the order they run
+var NormalizeSteps = []string{
+	NormalizeLowercase, NormalizePunycode, NormalizeDefaultPort, NormalizeEncoding,
+	NormalizeDotSegments, NormalizeStripParams, NormalizeSortQuery, NormalizeTrailingSlash,
+}
+
+// DefaultTrackingParams are the query params stripped when none are configured
+var DefaultTrackingParams = []string{"utm_*", "gclid", "fbclid"}
+
+// TrailingSlash says what happens to the trailing slash of url paths
+type TrailingSlash int
+
+const (
+	// KeepTrailingSlash leaves paths as they are
+	KeepTrailingSlash TrailingSlash = iota
+	// AddTrailingSlash adds a trailing slash to paths whose last segment has no file extension
+	AddTrailingSlash
+	// RemoveTrailingSlash removes the trailing slash of every path but the root
+	RemoveTrailingSlash
+)
+
+// ParseTrailingSlash parses a trailing slash policy written as keep, add or remove
+func ParseTrailingSlash(s string) (TrailingSlash, error) {
+	switch strings.ToLower(s) {
+	case "keep":
+		return KeepTrailingSlash, nil
+	case "add":
+		return AddTrailingSlash, nil
+	case "remove":
+		return RemoveTrailingSlash, nil
+	default:
+		return KeepTrailingSlash, fmt.Errorf("unknown trailing slash policy %q, expected keep, add or remove", s)
+	}
+}
+
+// NormalizeConfig configures the url normalization pipeline. Every step runs unless skipped
+type NormalizeConfig struct {
+	Skip           []string      // Skip are the names of the steps left out, see NormalizeSteps
+	TrackingParams []string      // TrackingParams are the query params stripped, a trailing * matches a prefix. Empty means DefaultTrackingParams
+	TrailingSlash  TrailingSlash // TrailingSlash is the policy of the trailing-slash step
+}
+
+// Normalizer rewrites urls into a normal form, so a page linked under different spellings of its url
+// is crawled once. Deduplication and scope checks see the normalized urls
+type Normalizer struct {
+	steps          []string                  // steps to run, in order
+	trackingParams []string                  // trackingParams are the query params stripped
+	trailingSlash  TrailingSlash             // trailingSlash is the policy of the trailing-slash step
+	apply          map[string]func(*url.URL) // apply holds the func of every step
+}
+
+// NewNormalizer returns the normalizer of the config, failing on unknown steps
+func NewNormalizer(cfg NormalizeConfig) (*Normalizer, error) {
+	skip := make(map[string]bool)
+	for _, s := range cfg.Skip {
+		if !isNormalizeStep(s) {
+			return nil, fmt.Errorf("unknown normalize step %q, expected one of %s", s, strings.Join(NormalizeSteps, ", "))
+		}
+		skip[s] = true
+	}
+
+	if cfg.TrailingSlash < KeepTrailingSlash || cfg.TrailingSlash > RemoveTrailingSlash {
+		return nil, fmt.Errorf("unknown trailing slash policy %d", cfg.TrailingSlash)
+	}
+
+	n := &Normalizer{
+		trackingParams: cfg.TrackingParams,
+		trailingSlash:  cfg.TrailingSlash,
+	}
+	if len(n.trackingParams) == 0 {
+		n.trackingParams = DefaultTrackingParams
+	}
+
+	n.apply = map[string]func(*url.URL){
+		NormalizeLowercase:     lowercaseURL,
+		NormalizePunycode:      punycodeHost,
+		NormalizeDefaultPort:   removeDefaultPort,
+		NormalizeEncoding:      normalizeEncoding,
+		NormalizeDotSegments:   func(u *url.URL) { setEscapedPath(u, removeDotSegments(u.EscapedPath())) },
+		NormalizeStripParams:   func(u *url.URL) { stripParams(u, n.trackingParams) },
+		NormalizeSortQuery:     sortQuery,
+		NormalizeTrailingSlash: func(u *url.URL) { applyTrailingSlash(u, n.trailingSlash) },
+	}
+
+	for _, s := range NormalizeSteps {
+		if !skip[s] {
+			n.steps = append(n.steps, s)
+		}
+	}
+
+	return n, nil
+}
+
+// isNormalizeStep says if s is the name of a normalization step
+func isNormalizeStep(s string) bool {
+	for _, step := range NormalizeSteps {
+		if step == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Steps returns the names of the steps the normalizer runs, in order
+func (n *Normalizer) Steps() []string {
+	if n == nil {
+		return nil
+	}
+
+	return append([]string(nil), n.steps...)
+}
+
+// Normalize returns a normalized copy of the url. A nil normalizer returns the url as is
+func (n *Normalizer) Normalize(u *url.URL) *url.URL {
+	if n == nil || u == nil {
+		return u
+	}
+
+	c := *u
+	if u.User != nil {
+		user := *u.User
+		c.User = &user
+	}
+
+	for _, s := range n.steps {
+		n.apply[s](&c)
+	}
+
+	return &c
+}
+
+// NormalizeString normalizes a raw url, returning it as is when it doesn't parse
+func (n *Normalizer) NormalizeString(s string) string {
+	if n == nil || s == "" {
+		return s
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+
+	return n.Normalize(u).String()
+}
+
+// lowercaseURL lowercases the scheme and host of the url
+func lowercaseURL(u *url.URL) {
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+}
+
+// removeDefaultPort removes the port of the url when it is the default one of its scheme
+func removeDefaultPort(u *url.URL) {
+	port := u.Port()
+	if port == "" && !strings.HasSuffix(u.Host, ":") {
+		return
+	}
+
+	if port == "" || (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		u.Host = strings.TrimSuffix(strings.TrimSuffix(u.Host, port), ":")
+	}
+}
+
+// setEscapedPath sets the path of the url from its escaped form
+func setEscapedPath(u *url.URL, p string) {
+	path, err := url.PathUnescape(p)
+	if err != nil {
+		return
+	}
+
+	u.Path, u.RawPath = path, p
+}
+
+// isUnreserved says if c is a character that never needs escaping in a url
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// unhex returns the value of the hex digit, -1 if c isn't one
+func unhex(c byte) int {
+	switch {
+	case '0' <= c && c <= '9':
+		return int(c - '0')
+	case 'a' <= c && c <= 'f':
+		return int(c - 'a' + 10)
+	case 'A' <= c && c <= 'F':
+		return int(c - 'A' + 10)
+	}
+
+	return -1
+}
+
+// normalizeEscapes decodes the escaped unreserved characters of s and uppercases the other escapes
+func normalizeEscapes(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+2 >= len(s) || unhex(s[i+1]) == -1 || unhex(s[i+2]) == -1 {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		c := byte(unhex(s[i+1])<<4 | unhex(s[i+2]))
+		if isUnreserved(c) {
+			b.WriteByte(c)
+		} else {
+			b.WriteString(strings.ToUpper(s[i : i+3]))
+		}
+		i += 2
+	}
+
+	return b.String()
+}
+
+// normalizeEncoding normalizes the percent-encoding of the path and query of the url
+func normalizeEncoding(u *url.URL) {
+	setEscapedPath(u, normalizeEscapes(u.EscapedPath()))
+	u.RawQuery = normalizeEscapes(u.RawQuery)
+}
+
+// removeDotSegments resolves the . and .. segments of an escaped path as RFC 3986 does
+func removeDotSegments(p string) string {
+	if !strings.Contains(p, ".") {
+		return p
+	}
+
+	segs := strings.Split(p, "/")
+	var out []string
+	for i, s := range segs {
+		last := i == len(segs)-1
+		switch s {
+		case ".":
+		case "..":
+			// the root can't be removed
+			if len(out) > 1 || (len(out) == 1 && out[0] != "") {
+				out = out[:len(out)-1]
+			}
+		default:
+			out = append(out, s)
+			continue
+		}
+
+		// a path ending in a dot segment is a directory
+		if last {
+			out = append(out, "")
+		}
+	}
+
+	if strings.HasPrefix(p, "/") && (len(out) == 0 || out[0] != "") {
+		out = append([]string{""}, out...)
+	}
+
+	return strings.Join(out, "/")
+}
+
+// queryParamName returns the unescaped lower cased name of a raw query param
+func queryParamName(param string) string {
+	name := param
+	if i := strings.IndexByte(param, '='); i != -1 {
+		name = param[:i]
+	}
+
+	if n, err := url.QueryUnescape(name); err == nil {
+		name = n
+	}
+
+	return strings.ToLower(name)
+}
+
+// stripParams removes the query params matching the tracking params from the url
+func stripParams(u *url.URL, trackingParams []string) {
+	if u.RawQuery == "" {
+		return
+	}
+
+	var kept []string
+	for _, param := range strings.Split(u.RawQuery, "&") {
+		name := queryParamName(param)
+		strip := false
+		for _, t := range trackingParams {
+			t = strings.ToLower(t)
+			if name == t || (strings.HasSuffix(t, "*") && strings.HasPrefix(name, strings.TrimSuffix(t, "*"))) {
+				strip = true
+				break
+			}
+		}
+
+		if !strip {
+			kept = append(kept, param)
+		}
+	}
+
+	u.RawQuery = strings.Join(kept, "&")
+	if u.RawQuery == "" {
+		u.ForceQuery = false
+	}
+}
+
+// sortQuery sorts the query params of the url by name, params of the same name keep their order
+func sortQuery(u *url.URL) {
+	if !strings.Contains(u.RawQuery, "&") {
+		return
+	}
+
+	params := strings.Split(u.RawQuery, "&")
+	sort.SliceStable(params, func(i, j int) bool { return queryParamName(params[i]) < queryParamName(params[j]) })
+	u.RawQuery = strings.Join(params, "&")
+}
+
+// applyTrailingSlash adds or removes the trailing slash of the url path as the policy says.
+// An empty path is the root whatever the policy
+func applyTrailingSlash(u *url.URL, policy TrailingSlash) {
+	if u.Opaque != "" || u.Host == "" {
+		return
+	}
+
+	p := u.EscapedPath()
+	switch {
+	case p == "" || p == "/":
+		p = "/"
+	case policy == KeepTrailingSlash:
+		return
+	case policy == RemoveTrailingSlash:
+		p = strings.TrimRight(p, "/")
+		if p == "" {
+			p = "/"
+		}
+	case policy == AddTrailingSlash && !strings.HasSuffix(p, "/"):
+		if !strings.Contains(p[strings.LastIndex(p, "/")+1:], ".") {
+			p += "/"
+		}
+	}
+
+	setEscapedPath(u, p)
+}
+
+// punycodeHost converts the internationalized labels of the url host to punycode, mapping them as
+// UTS #46 does on the way. Hosts that aren't valid domain names are left as they are
+func punycodeHost(u *url.URL) {
+	host := u.Hostname()
+	if !hasNonASCII(host) {
+		return
+	}
+
+	host, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return
+	}
+
+	if port := u.Port(); port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+	u.Host = host
+}
+
+// hasNonASCII says if s holds a non ascii character
+func hasNonASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,117 @@
+package crawlerlib
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestNormalizer_NormalizeString(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      NormalizeConfig
+		url      string
+		expected string
+	}{
+		{name: "defaults", url: "https://Example.com:443/a/?utm_source=x", expected: "https://example.com/a/"},
+		{name: "remove slash", cfg: NormalizeConfig{TrailingSlash: RemoveTrailingSlash}, url: "https://Example.com:443/a/?utm_source=x", expected: "https://example.com/a"},
+		{name: "add slash", cfg: NormalizeConfig{TrailingSlash: AddTrailingSlash}, url: "http://example.com/a", expected: "http://example.com/a/"},
+		{name: "add slash skips files", cfg: NormalizeConfig{TrailingSlash: AddTrailingSlash}, url: "http://example.com/a.html", expected: "http://example.com/a.html"},
+		{name: "empty path", url: "http://example.com", expected: "http://example.com/"},
+		{name: "empty path removing slashes", cfg: NormalizeConfig{TrailingSlash: RemoveTrailingSlash}, url: "http://example.com?q=1", expected: "http://example.com/?q=1"},
+		{name: "http port", url: "HTTP://example.com:80/", expected: "http://example.com/"},
+		{name: "other port", url: "http://example.com:8080/", expected: "http://example.com:8080/"},
+		{name: "https port on http", url: "http://example.com:443/", expected: "http://example.com:443/"},
+		{name: "encoding", url: "http://example.com/%7efoo/%2fbar%2f?q=%7e%2f", expected: "http://example.com/~foo/%2Fbar%2F?q=~%2F"},
+		{name: "dot segments", url: "http://example.com/a/./b/../c/..", expected: "http://example.com/a/"},
+		{name: "dot segments above root", url: "http://example.com/../a", expected: "http://example.com/a"},
+		{name: "sort query", url: "http://example.com/?b=2&a=1&b=1", expected: "http://example.com/?a=1&b=2&b=1"},
+		{name: "tracking params", url: "http://example.com/?gclid=1&q=go&UTM_medium=x&fbclid=2", expected: "http://example.com/?q=go"},
+		{name: "custom tracking params", cfg: NormalizeConfig{TrackingParams: []string{"ref", "s*"}}, url: "http://example.com/?ref=1&sid=2&utm_source=x", expected: "http://example.com/?utm_source=x"},
+		{name: "punycode", url: "http://Bücher.example:8080/", expected: "http://xn--bcher-kva.example:8080/"},
+		{name: "punycode mapping", url: "http://ＢÜCHER.example/", expected: "http://xn--bcher-kva.example/"},
+		{name: "skipped steps", cfg: NormalizeConfig{Skip: []string{NormalizeLowercase, NormalizeSortQuery, NormalizeStripParams}}, url: "http://Example.com/?b=1&a=2&utm_source=x", expected: "http://Example.com/?b=1&a=2&utm_source=x"},
+		{name: "fragment kept", url: "http://example.com/a#top", expected: "http://example.com/a#top"},
+	}
+
+	for _, c := range tests {
+		n, err := NewNormalizer(c.cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+
+		if got := n.NormalizeString(c.url); got != c.expected {
+			t.Fatalf("%s: expected %s but got %s", c.name, c.expected, got)
+		}
+	}
+}
+
+func TestNewNormalizer(t *testing.T) {
+	tests := []struct {
+		cfg      NormalizeConfig
+		expected []string
+		err      bool
+	}{
+		{cfg: NormalizeConfig{}, expected: NormalizeSteps},
+		{cfg: NormalizeConfig{Skip: []string{NormalizePunycode, NormalizeTrailingSlash}}, expected: []string{
+			NormalizeLowercase, NormalizeDefaultPort, NormalizeEncoding, NormalizeDotSegments, NormalizeStripParams, NormalizeSortQuery,
+		}},
+		{cfg: NormalizeConfig{Skip: []string{"lowercase-path"}}, err: true},
+		{cfg: NormalizeConfig{TrailingSlash: 7}, err: true},
+	}
+
+	for _, c := range tests {
+		n, err := NewNormalizer(c.cfg)
+		if c.err {
+			if err == nil {
+				t.Fatalf("expected %+v to fail", c.cfg)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if fmt.Sprint(n.Steps()) != fmt.Sprint(c.expected) {
+			t.Fatalf("expected steps %v but got %v", c.expected, n.Steps())
+		}
+	}
+}
+
+func TestCrawler_RunNormalize(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, `<a href="/a?utm_source=x">1</a><a href="/a">2</a><a href="/b/../a?fbclid=1">3</a><a href="/c?y=1&x=2">4</a><a href="/c?x=2&y=1">5</a>`)
+			return
+		}
+		fmt.Fprint(w, "leaf")
+	}))
+	defer ts.Close()
+
+	cr, err := New(WithIgnoreRobots(true), WithOutput(nil), WithConcurrency(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := cr.Run(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var crawled []string
+	for u := range resp.Pages {
+		crawled = append(crawled, strings.TrimPrefix(u, ts.URL))
+	}
+
+	sort.Strings(crawled)
+	expected := []string{"/", "/a", "/c?x=2&y=1"}
+	if fmt.Sprint(crawled) != fmt.Sprint(expected) {
+		t.Fatalf("expected crawled pages %v but got %v", expected, crawled)
+	}
+}
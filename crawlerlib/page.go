@@ -0,0 +1,77 @@
+package crawlerlib
+
+import "time"
+
+// Page holds what was learnt about a url while fetching it
+type Page struct {
+	URL        string  // URL of the page
+	Depth      int     // Depth at which the page was crawled
+	StatusCode int     // StatusCode of the last attempt, 0 if there was no response
+	Attempts   int     // Attempts made to fetch the page
+	Errors     []error // Errors of every failed attempt in order, empty if the first attempt succeeded
+	RedirectTo string  // RedirectTo is the url the page redirected to, empty if it didn't redirect
+	Size       int64   // Size is the number of body bytes read
+	Truncated  bool    // Truncated is set when the body was cut short at the max body bytes budget
+
+	LastModified time.Time // LastModified is the Last-Modified header or else the modified date of the page meta tags
+	Canonical    string    // Canonical is the url the page declares canonical, empty if it declares none
+	Canonicals   []string  // Canonicals holds every distinct canonical url declared in link tags and Link headers
+	Robots       []string  // Robots holds the robots meta tag and X-Robots-Tag directives that apply to the crawler
+	NoIndex      bool      // NoIndex is set when the page asks not to be indexed, it is crawled for links but left out of the sitemap
+	NoFollow     bool      // NoFollow is set when the page asks for its links not to be followed
+
+	Title       string          // Title of the page
+	Description string          // Description is the content of the description meta tag
+	Images      []PageImage     // Images shown on the page, including every srcset candidate
+	Videos      []PageVideo     // Videos embedded in the page with video tags
+	Alternates  []PageAlternate // Alternates are the language versions of the page declared with hreflang
+}
+
+// PageImage is an image shown on a page
+type PageImage struct {
+	URL string // URL of the image
+	Alt string // Alt text of the image
+}
+
+// PageVideo is a video embedded in a page
+type PageVideo struct {
+	URL       string // URL of the video file
+	Thumbnail string // Thumbnail is the url of the video poster, empty if it has none
+	Title     string // Title attribute of the video tag
+}
+
+// PageAlternate is a language version of a page
+type PageAlternate struct {
+	HrefLang string // HrefLang is the language code of the version, or x-default
+	URL      string // URL of the version
+}
+
+// setPageHTML copies what was scraped from the html of the page to it
+func setPageHTML(p *Page, hp *htmlPage) {
+	// the link tag wins over the Link header
+	if hp.canonical != "" {
+		p.Canonical = hp.canonical
+	}
+	for _, c := range hp.canonicals {
+		p.Canonicals = appendUnique(p.Canonicals, c)
+	}
+	if p.LastModified.IsZero() {
+		p.LastModified = hp.modified
+	}
+
+	p.Title = hp.title
+	if ds := hp.metas["description"]; len(ds) > 0 {
+		p.Description = ds[0]
+	}
+
+	p.Images = hp.images
+	p.Videos = hp.videos
+	p.Alternates = hp.alternates
+}
+
+// setPageRobots sets the robots directives that apply to the page
+func setPageRobots(p *Page, directives []string) {
+	p.Robots = directives
+	p.NoIndex = hasRobotsDirective(directives, "noindex")
+	p.NoFollow = hasRobotsDirective(directives, "nofollow")
+}
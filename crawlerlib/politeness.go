@@ -0,0 +1,283 @@
+package crawlerlib
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HostLimit limits the requests made to a single host
+type HostLimit struct {
+	Pattern     string        // Pattern is a regex matched against the url hostname, unused for the default limit
+	MaxInFlight int           // MaxInFlight caps the concurrent requests to a host, 0 means no cap
+	Delay       time.Duration // Delay is the minimum time between requests to a host, 0 means no delay
+	Burst       int           // Burst is the number of requests allowed back to back before Delay kicks in, defaults to 1
+}
+
+// Politeness holds the request limits applied per host
+type Politeness struct {
+	Default HostLimit   // Default applies to hosts not matching any of Hosts, zero value means DefaultHostLimit
+	Hosts   []HostLimit // Hosts are per host pattern limits, first match wins
+}
+
+// DefaultHostLimit is used when no default host limit is configured
+var DefaultHostLimit = HostLimit{MaxInFlight: 2}
+
+// ParseHostLimit parses a host limit written as pattern[,inflight=N][,delay=D][,burst=N]
+// e.g. `example\.com,inflight=1,delay=500ms`
+func ParseHostLimit(s string) (HostLimit, error) {
+	var l HostLimit
+	parts := strings.Split(s, ",")
+	i := len(parts)
+	for ; i > 1; i-- {
+		kv := strings.SplitN(parts[i-1], "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || (key != "inflight" && key != "delay" && key != "burst") {
+			break
+		}
+
+		var err error
+		switch key {
+		case "inflight":
+			l.MaxInFlight, err = strconv.Atoi(kv[1])
+		case "delay":
+			l.Delay, err = time.ParseDuration(kv[1])
+		case "burst":
+			l.Burst, err = strconv.Atoi(kv[1])
+		}
+
+		if err != nil {
+			return l, fmt.Errorf("invalid host limit %q: %v", s, err)
+		}
+	}
+
+	l.Pattern = strings.Join(parts[:i], ",")
+	if l.Pattern == "" {
+		return l, fmt.Errorf("invalid host limit %q: missing host pattern", s)
+	}
+
+	return l, nil
+}
+
+// hostRule is a compiled HostLimit
+type hostRule struct {
+	re    *regexp.Regexp
+	limit HostLimit
+}
+
+// hostState is the token bucket and in flight count of a host
+type hostState struct {
+	limit       HostLimit                // limit that applies to the host
+	crawlDelays map[string]time.Duration // crawlDelays asked for by robots.txt of the host origins, by origin
+	inFlight    int                      // inFlight is the number of requests being made to the host
+	tokens      float64                  // tokens left in the bucket, a request takes one
+	refilled    time.Time                // refilled is when tokens were last topped up
+	requests    int                      // requests made to the host so far
+	throttled   int                      // throttled is the number of 429 and 503 responses from the host
+	backoff     time.Duration            // backoff is the delay between requests added after throttled responses
+	pausedUntil time.Time                // pausedUntil is when the host may be requested again after a throttled response
+}
+
+// interval returns the time it takes to earn a token
+func (h *hostState) interval() time.Duration {
+	iv := h.limit.Delay
+	for _, d := range h.crawlDelays {
+		if d > iv {
+			iv = d
+		}
+	}
+
+	if h.backoff > iv {
+		iv = h.backoff
+	}
+
+	return iv
+}
+
+// hostScheduler decides which hosts can be requested right now
+type hostScheduler struct {
+	mu    *sync.Mutex           // protects the below
+	def   HostLimit             // def is the limit of hosts not matching any rule
+	rules []*hostRule           // rules are per host pattern limits
+	hosts map[string]*hostState // hosts are keyed by url host including the port
+	wake  chan struct{}         // wake is closed and replaced whenever a request finishes
+}
+
+// newHostScheduler returns a scheduler enforcing the given politeness
+func newHostScheduler(p Politeness) (*hostScheduler, error) {
+	s := &hostScheduler{
+		mu:    &sync.Mutex{},
+		def:   p.Default,
+		hosts: make(map[string]*hostState),
+		wake:  make(chan struct{}),
+	}
+
+	if s.def == (HostLimit{}) {
+		s.def = DefaultHostLimit
+	}
+
+	for _, l := range p.Hosts {
+		re, err := regexp.Compile(l.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile host limit pattern: %v", err)
+		}
+
+		s.rules = append(s.rules, &hostRule{re: re, limit: l})
+	}
+
+	return s, nil
+}
+
+// hostStateFor returns the state of the host, creating it on first use. s.mu must be held
+func hostStateFor(s *hostScheduler, host string) *hostState {
+	if h, ok := s.hosts[host]; ok {
+		return h
+	}
+
+	h := &hostState{limit: s.def}
+	name, _, err := net.SplitHostPort(host)
+	if err != nil {
+		name = host
+	}
+
+	for _, r := range s.rules {
+		if r.re.MatchString(name) {
+			h.limit = r.limit
+			break
+		}
+	}
+
+	if h.limit.Burst < 1 {
+		h.limit.Burst = 1
+	}
+
+	h.tokens = float64(h.limit.Burst)
+	s.hosts[host] = h
+	return h
+}
+
+// setCrawlDelay sets the Crawl-delay robots.txt of the origin asks for. Origins sharing a host, e.g.
+// its http and https ones, each keep their own and the longest applies to the host
+func setCrawlDelay(s *hostScheduler, host, origin string, delay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h := hostStateFor(s, host)
+	if h.crawlDelays == nil {
+		h.crawlDelays = make(map[string]time.Duration)
+	}
+	h.crawlDelays[origin] = delay
+}
+
+// tryAcquire takes a request slot of the host if it is eligible. If not, wait is how
+// long till a token is earned or 0 if the host is waiting for in flight requests to finish.
+// s.mu must be held
+func tryAcquire(s *hostScheduler, host string, now time.Time) (ok bool, wait time.Duration) {
+	h := hostStateFor(s, host)
+	if now.Before(h.pausedUntil) {
+		return false, h.pausedUntil.Sub(now)
+	}
+
+	if h.limit.MaxInFlight > 0 && h.inFlight >= h.limit.MaxInFlight {
+		return false, 0
+	}
+
+	iv := h.interval()
+	if iv > 0 {
+		if !h.refilled.IsZero() && now.After(h.refilled) {
+			h.tokens += float64(now.Sub(h.refilled)) / float64(iv)
+			if h.tokens > float64(h.limit.Burst) {
+				h.tokens = float64(h.limit.Burst)
+			}
+		}
+		h.refilled = now
+
+		if h.tokens < 1 {
+			return false, time.Duration((1 - h.tokens) * float64(iv))
+		}
+
+		h.tokens--
+	}
+
+	h.inFlight++
+	h.requests++
+	return true, 0
+}
+
+// acquireAny takes a request slot for the first eligible host in hosts and returns its index.
+// When none are eligible, index is -1, wait is the shortest time till a host gets a token
+// (0 if all are waiting on in flight requests) and wake is closed when a request finishes
+func acquireAny(s *hostScheduler, hosts []string) (index int, wait time.Duration, wake <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for i, host := range hosts {
+		ok, w := tryAcquire(s, host, now)
+		if ok {
+			return i, 0, nil
+		}
+
+		if w > 0 && (wait == 0 || w < wait) {
+			wait = w
+		}
+	}
+
+	return -1, wait, s.wake
+}
+
+// waitForSlot blocks till a request slot of the host is taken, or ctx is done
+func waitForSlot(ctx context.Context, s *hostScheduler, host string) error {
+	for {
+		i, wait, wake := acquireAny(s, []string{host})
+		if i == 0 {
+			return nil
+		}
+
+		if err := waitForHosts(ctx, wait, wake); err != nil {
+			return err
+		}
+	}
+}
+
+// release frees the request slot taken for the host and wakes up the waiting scrapers
+func release(s *hostScheduler, host string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hostStateFor(s, host).inFlight--
+	close(s.wake)
+	s.wake = make(chan struct{})
+}
+
+// released returns a channel closed once a request slot is released
+func released(s *hostScheduler) <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.wake
+}
+
+// waitForHosts blocks till wait has passed or wake is closed. wait of 0 waits only for wake
+func waitForHosts(ctx context.Context, wait time.Duration, wake <-chan struct{}) error {
+	var timeout <-chan time.Time
+	if wait > 0 {
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-wake:
+	case <-timeout:
+	}
+
+	return nil
+}
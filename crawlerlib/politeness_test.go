@@ -0,0 +1,195 @@
+package crawlerlib
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_ParseHostLimit(t *testing.T) {
+	tests := []struct {
+		s     string
+		limit HostLimit
+		error bool
+	}{
+		{
+			s:     `example\.com`,
+			limit: HostLimit{Pattern: `example\.com`},
+		},
+		{
+			s:     `example\.com,inflight=1,delay=500ms`,
+			limit: HostLimit{Pattern: `example\.com`, MaxInFlight: 1, Delay: 500 * time.Millisecond},
+		},
+		{
+			s:     `^a{1,3}\.com$,burst=3`,
+			limit: HostLimit{Pattern: `^a{1,3}\.com$`, Burst: 3},
+		},
+		{
+			s:     `example\.com,inflight=x`,
+			error: true,
+		},
+		{
+			s:     `,inflight=1`,
+			error: true,
+		},
+	}
+
+	for _, c := range tests {
+		l, err := ParseHostLimit(c.s)
+		if err != nil {
+			if c.error {
+				continue
+			}
+
+			t.Fatalf("failed to parse %s: %v", c.s, err)
+		}
+
+		if c.error {
+			t.Fatalf("expected %s to fail but got %v", c.s, l)
+		}
+
+		if !reflect.DeepEqual(c.limit, l) {
+			t.Fatalf("expected %v but got %v", c.limit, l)
+		}
+	}
+}
+
+func Test_tryAcquire(t *testing.T) {
+	s, err := newHostScheduler(Politeness{
+		Default: HostLimit{MaxInFlight: 2},
+		Hosts: []HostLimit{
+			{Pattern: `^slow\.com$`, MaxInFlight: 1, Delay: time.Second},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	tests := []struct {
+		host string
+		at   time.Duration
+		ok   bool
+		wait time.Duration
+	}{
+		{host: "fast.com", ok: true},
+		{host: "fast.com", ok: true},
+		{host: "fast.com", ok: false},
+		{host: "slow.com:8080", ok: true},
+		{host: "slow.com:8080", ok: false},
+	}
+
+	for _, c := range tests {
+		ok, wait := tryAcquire(s, c.host, now.Add(c.at))
+		if ok != c.ok || wait != c.wait {
+			t.Fatalf("expected %s to be %t/%v but got %t/%v", c.host, c.ok, c.wait, ok, wait)
+		}
+	}
+
+	release(s, "slow.com:8080")
+	ok, wait := tryAcquire(s, "slow.com:8080", now.Add(400*time.Millisecond))
+	if ok || wait != 600*time.Millisecond {
+		t.Fatalf("expected slow.com to wait 600ms for a token but got %t/%v", ok, wait)
+	}
+
+	ok, _ = tryAcquire(s, "slow.com:8080", now.Add(time.Second))
+	if !ok {
+		t.Fatal("expected slow.com to be eligible after a second")
+	}
+
+	setCrawlDelay(s, "fast.com", "https://fast.com", 2*time.Second)
+	setCrawlDelay(s, "fast.com", "http://fast.com", 0)
+	release(s, "fast.com")
+	ok, _ = tryAcquire(s, "fast.com", now)
+	if !ok {
+		t.Fatal("expected fast.com to have a token for the first request")
+	}
+
+	release(s, "fast.com")
+	ok, wait = tryAcquire(s, "fast.com", now.Add(time.Second))
+	if ok || wait != time.Second {
+		t.Fatalf("expected fast.com to honor the crawl delay but got %t/%v", ok, wait)
+	}
+}
+
+func Test_acquireAny(t *testing.T) {
+	s, _ := newHostScheduler(Politeness{Default: HostLimit{MaxInFlight: 1}})
+	hosts := []string{"a.com", "a.com", "b.com"}
+
+	i, _, _ := acquireAny(s, hosts)
+	if i != 0 {
+		t.Fatalf("expected first host to be picked but got %d", i)
+	}
+
+	i, _, _ = acquireAny(s, hosts)
+	if i != 2 {
+		t.Fatalf("expected busy a.com to be passed over but got %d", i)
+	}
+
+	i, wait, wake := acquireAny(s, hosts)
+	if i != -1 || wait != 0 {
+		t.Fatalf("expected no eligible hosts but got %d/%v", i, wait)
+	}
+
+	release(s, "b.com")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := waitForHosts(ctx, wait, wake); err != nil {
+		t.Fatalf("expected release to wake up waiters: %v", err)
+	}
+}
+
+func TestCrawler_RunSlowHost(t *testing.T) {
+	var mu sync.Mutex
+	var seeded, fastHit time.Time
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if fastHit.IsZero() {
+			fastHit = time.Now()
+		}
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+	}))
+	defer fast.Close()
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path != "/" {
+			return
+		}
+
+		mu.Lock()
+		seeded = time.Now()
+		mu.Unlock()
+		for i := 1; i <= 6; i++ {
+			fmt.Fprintf(w, `<a href="/%d">%d</a>`, i, i)
+		}
+		fmt.Fprintf(w, `<a href="%s/">fast</a>`, fast.URL)
+	}))
+	defer slow.Close()
+
+	// both servers listen on 127.0.0.1 but their ports make them different hosts
+	cr, err := New(WithIgnoreRobots(true), WithOutput(nil), WithConcurrency(2), WithMaxDepth(-1),
+		WithPoliteness(Politeness{Default: HostLimit{MaxInFlight: 1, Delay: 300 * time.Millisecond}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := cr.Run(context.Background(), slow.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp.Pages) != 8 {
+		t.Fatalf("expected 8 pages to be crawled but got %d", len(resp.Pages))
+	}
+
+	if d := fastHit.Sub(seeded); d > 200*time.Millisecond {
+		t.Fatalf("expected the fast host to be crawled right after the seed but it waited %v", d)
+	}
+}
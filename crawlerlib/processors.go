@@ -15,6 +15,48 @@ func (pf processorFunc) process(g *delegator, md *scraperDump) (proceed bool) {
 	return pf(g, md)
 }
 
+// pageProcessor records the fetch details of the source url
+func pageProcessor() processor {
+	return processorFunc(func(g *delegator, md *scraperDump) (proceed bool) {
+		if md.page != nil {
+			g.pages[md.sourceURL.String()] = md.page
+		}
+
+		return true
+	})
+}
+
+// linkGraphProcessor records an edge for every link found on the source url page
+func linkGraphProcessor() processor {
+	return processorFunc(func(g *delegator, md *scraperDump) (proceed bool) {
+		source := md.sourceURL.String()
+		// a page crawled twice would add its edges again
+		if _, ok := g.links.outbound[source]; ok {
+			return true
+		}
+
+		for _, l := range md.links {
+			addEdge(g.links, &LinkEdge{
+				Source:  source,
+				Target:  l.url.String(),
+				Anchor:  l.anchor,
+				Rel:     l.rel,
+				InScope: inScope(g, l.url),
+				Kind:    l.kind,
+				Follow:  l.follow,
+			})
+		}
+
+		return true
+	})
+}
+
+// inScope says if the url host passes the domain regex and the url the scope rules of the delegator
+func inScope(g *delegator, u *url.URL) bool {
+	ok, _ := scopeCheck(g, u)
+	return ok
+}
+
 // uniqueURLProcessor adds source url to unique crawled and remove any urls from the
 // scraper dump that are already crawled
 func uniqueURLProcessor() processor {
@@ -22,7 +64,8 @@ func uniqueURLProcessor() processor {
 		g.scrappedUnique[md.sourceURL.String()]++
 		var unique []*url.URL
 		for _, u := range md.urls {
-			if _, ok := g.scrappedUnique[u.String()]; !ok {
+			// urls queued but not crawled yet count as seen too
+			if _, ok := g.scrappedUnique[u.String()]; !ok && !g.queued[u.String()] {
 				unique = append(unique, u)
 				continue
 			}
@@ -55,45 +98,57 @@ func skippedURLProcessor() processor {
 	})
 }
 
-// maxDepthCheckProcessor will add the unscrapped urls to scrapped if the max depth has been reached
+// maxDepthCheckProcessor will add the unscrapped urls to scrapped if the max depth of their site has been reached.
+// It runs after domainFilterProcessor and robotsProcessor so only in scope urls robots.txt allows are recorded
 func maxDepthCheckProcessor() processor {
 	return processorFunc(func(g *delegator, md *scraperDump) (proceed bool) {
-		if g.maxDepth == -1 || md.depth < g.maxDepth {
-			return true
-		}
-
-		// add all urls to scraped depth
 		if len(md.urls) < 1 {
-			return false
+			d := maxDepthOf(g, md.sourceURL)
+			return d == -1 || md.depth < d
 		}
 
-		g.scrapped[md.depth] = append(g.scrapped[md.depth], md.urls...)
+		var urls, deeper []*url.URL
 		for _, u := range md.urls {
-			if g.domainRegex.MatchString(u.Hostname()) {
-				g.scrappedUnique[u.String()]++
+			if d := maxDepthOf(g, u); d == -1 || md.depth < d {
+				urls = append(urls, u)
 				continue
 			}
+
+			deeper = append(deeper, u)
 		}
-		return false
+
+		// add the urls past the max depth to scraped depth, they passed the scope and robots checks before
+		g.scrapped[md.depth] = append(g.scrapped[md.depth], deeper...)
+		for _, u := range deeper {
+			g.scrappedUnique[u.String()]++
+		}
+
+		md.urls = urls
+		return len(urls) > 0
 	})
 }
 
-// domainFilterProcessor will filter the md.urls and update skipped urls with unmatched urls
+// domainFilterProcessor will filter the md.urls and update skipped urls with unmatched urls,
+// along with the scope rule that rejected them
 func domainFilterProcessor() processor {
 	return processorFunc(func(g *delegator, md *scraperDump) (proceed bool) {
-		if g.domainRegex == nil {
+		if g.domainRegex == nil && g.scope == nil {
 			return true
 		}
 
 		m := []*url.URL{}
 		um := []string{}
 		for _, u := range md.urls {
-			if g.domainRegex.MatchString(u.Hostname()) {
+			ok, reason := scopeCheck(g, u)
+			if ok {
 				m = append(m, u)
 				continue
 			}
 
 			um = append(um, u.String())
+			if reason != "" {
+				g.skipReasons[u.String()] = reason
+			}
 		}
 
 		md.urls = m
@@ -101,3 +156,89 @@ func domainFilterProcessor() processor {
 		return true
 	})
 }
+
+// robotsProcessor will filter the md.urls disallowed by robots.txt and update skipped urls with them.
+// urls whose robots.txt is being fetched are crawled once it is
+func robotsProcessor() processor {
+	return processorFunc(func(g *delegator, md *scraperDump) (proceed bool) {
+		depth, source := md.depth, md.sourceURL
+		md.urls = filterRobots(g, source, md.urls, func(urls []*url.URL) {
+			crawlLinks(g, &scraperDump{depth: depth, sourceURL: source, urls: urls})
+		})
+		return true
+	})
+}
+
+// robotsWait holds urls put off till robots.txt of their origin is fetched
+type robotsWait struct {
+	url   *url.URL         // url of the origin whose robots.txt is fetched
+	urls  []*url.URL       // urls put off
+	later func([]*url.URL) // later is given the urls once robots.txt is fetched
+}
+
+// filterRobots returns the urls robots.txt allows. disallowed urls are added to the
+// skipped urls of sourceURL along with the reason. urls whose robots.txt is being fetched
+// are left out and given to later once it is, so the delegator never waits on robots.txt
+func filterRobots(g *delegator, sourceURL *url.URL, urls []*url.URL, later func([]*url.URL)) []*url.URL {
+	if g.robots == nil {
+		return urls
+	}
+
+	var allowed []*url.URL
+	waits := make(map[string]*robotsWait)
+	for _, u := range urls {
+		if !robotsReady(g.robots, u) {
+			origin := robotsOrigin(u)
+			w, ok := waits[origin]
+			if !ok {
+				w = &robotsWait{url: u, later: later}
+				waits[origin] = w
+				g.robotsWaits = append(g.robotsWaits, w)
+			}
+			w.urls = append(w.urls, u)
+			continue
+		}
+
+		ok, reason := robotsCheck(g.robots, u)
+		if ok {
+			allowed = append(allowed, u)
+			continue
+		}
+
+		g.skippedURLs[sourceURL.String()] = append(g.skippedURLs[sourceURL.String()], u.String())
+		g.skipReasons[u.String()] = reason
+	}
+
+	return allowed
+}
+
+// runRobotsWaits gives the urls put off to their later func once robots.txt of their origin is fetched
+func runRobotsWaits(g *delegator) {
+	var ready []*robotsWait
+	waits := g.robotsWaits[:0]
+	for _, w := range g.robotsWaits {
+		if robotsReady(g.robots, w.url) {
+			ready = append(ready, w)
+			continue
+		}
+		waits = append(waits, w)
+	}
+	g.robotsWaits = waits
+
+	for _, w := range ready {
+		w.later(w.urls)
+	}
+}
+
+// customProcessor runs a Processor given to the crawler on the crawled page and its links
+func customProcessor(p Processor) processor {
+	return processorFunc(func(g *delegator, md *scraperDump) (proceed bool) {
+		page := md.page
+		if page == nil {
+			page = &Page{URL: md.sourceURL.String(), Depth: md.depth - 1}
+		}
+
+		md.urls = p.Process(page, md.urls)
+		return true
+	})
+}
@@ -0,0 +1,112 @@
+package crawlerlib
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BrokenLink is a url that failed to crawl along with the links pointing to it
+type BrokenLink struct {
+	URL        string     `json:"url"`       // URL that failed
+	StatusCode int        `json:"status"`    // StatusCode of the last attempt, 0 if there was no response
+	Error      string     `json:"error"`     // Error the url failed with
+	Referrers  []Referrer `json:"referrers"` // Referrers are the links pointing to the url, empty for a failed seed
+}
+
+// Referrer is a link to a broken url
+type Referrer struct {
+	Page   string `json:"page"`   // Page the link was found on
+	Anchor string `json:"anchor"` // Anchor is the text of the link
+}
+
+// BrokenLinks returns every url that failed to crawl, and every asset that failed its check,
+// with the pages linking to it, sorted by url.
+// Urls that responded with 2xx but couldn't be scraped, e.g. images, are not broken
+func (r *Response) BrokenLinks() []BrokenLink {
+	broken := make(map[string]BrokenLink)
+	for u, err := range r.ErrorURLs {
+		if p, ok := r.Pages[u]; ok && p.StatusCode >= 200 && p.StatusCode < 300 {
+			continue
+		}
+
+		bl := BrokenLink{URL: u, Error: err.Error()}
+		if p, ok := r.Pages[u]; ok {
+			bl.StatusCode = p.StatusCode
+		}
+		broken[u] = bl
+	}
+
+	for _, u := range failedAssets(r) {
+		if _, ok := broken[u]; !ok {
+			a := r.Assets[u]
+			broken[u] = BrokenLink{URL: u, StatusCode: a.StatusCode, Error: a.Error}
+		}
+	}
+
+	var urls []string
+	for u := range broken {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+
+	links := []BrokenLink{}
+	for _, u := range urls {
+		bl := broken[u]
+		bl.Referrers = []Referrer{}
+		for _, e := range r.Links.Inbound(u) {
+			bl.Referrers = append(bl.Referrers, Referrer{Page: e.Source, Anchor: e.Anchor})
+		}
+
+		sort.SliceStable(bl.Referrers, func(i, j int) bool { return bl.Referrers[i].Page < bl.Referrers[j].Page })
+		links = append(links, bl)
+	}
+
+	return links
+}
+
+// WriteBrokenLinks writes the broken link report in the given format, one of text, csv or json.
+// csv has a row per referrer of every broken url
+func (r *Response) WriteBrokenLinks(w io.Writer, format string) error {
+	links := r.BrokenLinks()
+	switch strings.ToLower(format) {
+	case "text":
+		bw := bufio.NewWriter(w)
+		fmt.Fprintf(bw, "Broken URLs: %d\n", len(links))
+		for _, bl := range links {
+			fmt.Fprintf(bw, "\n%s (%s)\n", bl.URL, bl.Error)
+			if len(bl.Referrers) == 0 {
+				fmt.Fprintln(bw, "  not linked from any crawled page")
+			}
+			for _, ref := range bl.Referrers {
+				fmt.Fprintf(bw, "  linked from %s as %q\n", ref.Page, ref.Anchor)
+			}
+		}
+		return bw.Flush()
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"url", "status", "error", "referrer", "anchor"})
+		for _, bl := range links {
+			status := strconv.Itoa(bl.StatusCode)
+			if len(bl.Referrers) == 0 {
+				cw.Write([]string{bl.URL, status, bl.Error, "", ""})
+			}
+			for _, ref := range bl.Referrers {
+				cw.Write([]string{bl.URL, status, bl.Error, ref.Page, ref.Anchor})
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(links)
+	default:
+		return fmt.Errorf("unknown report format %q, expected text, csv or json", format)
+	}
+}
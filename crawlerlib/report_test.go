@@ -0,0 +1,72 @@
+package crawlerlib
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestResponse_BrokenLinks(t *testing.T) {
+	resp := testGraphResponse()
+	expected := []BrokenLink{
+		{
+			URL:        "http://t.com/blog/b",
+			StatusCode: 404,
+			Error:      "url responsed with code 404",
+			Referrers: []Referrer{
+				{Page: "http://t.com/", Anchor: "B"},
+				{Page: "http://t.com/blog/a", Anchor: "B"},
+			},
+		},
+	}
+
+	if got := resp.BrokenLinks(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %+v but got %+v", expected, got)
+	}
+}
+
+func TestResponse_WriteBrokenLinks(t *testing.T) {
+	tests := []struct {
+		format   string
+		expected string
+		error    bool
+	}{
+		{
+			format: "text",
+			expected: "Broken URLs: 1\n\n" +
+				"http://t.com/blog/b (url responsed with code 404)\n" +
+				"  linked from http://t.com/ as \"B\"\n" +
+				"  linked from http://t.com/blog/a as \"B\"\n",
+		},
+		{
+			format: "csv",
+			expected: "url,status,error,referrer,anchor\n" +
+				"http://t.com/blog/b,404,url responsed with code 404,http://t.com/,B\n" +
+				"http://t.com/blog/b,404,url responsed with code 404,http://t.com/blog/a,B\n",
+		},
+		{format: "xml", error: true},
+	}
+
+	for _, c := range tests {
+		var buf bytes.Buffer
+		err := testGraphResponse().WriteBrokenLinks(&buf, c.format)
+		if (err != nil) != c.error {
+			t.Fatalf("%s: expected error %t but got %v", c.format, c.error, err)
+		}
+
+		if buf.String() != c.expected {
+			t.Fatalf("%s: expected\n%s\nbut got\n%s", c.format, c.expected, buf.String())
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := (&Response{}).WriteBrokenLinks(&buf, "json"); err != nil {
+		t.Fatal(err)
+	}
+
+	var links []BrokenLink
+	if err := json.Unmarshal(buf.Bytes(), &links); err != nil || links == nil || len(links) != 0 {
+		t.Fatalf("expected an empty json list but got %s", buf.String())
+	}
+}
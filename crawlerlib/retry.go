@@ -0,0 +1,104 @@
+package crawlerlib
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy decides which failed fetches are retried and how long to wait in between
+type RetryPolicy struct {
+	MaxAttempts   int           // MaxAttempts is the total number of attempts per url, 1 disables retries
+	BaseDelay     time.Duration // BaseDelay is the wait before the first retry, doubled for every retry after
+	MaxDelay      time.Duration // MaxDelay caps the wait between attempts
+	RetryTimeouts bool          // RetryTimeouts retries requests that timed out
+	RetryResets   bool          // RetryResets retries requests whose connection was reset or closed early
+	RetryStatuses []int         // RetryStatuses are the response codes that are retried
+}
+
+// DefaultRetryPolicy is used when no retry policy is configured.
+// 503 is left out as it is handled by host throttling
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:   3,
+	BaseDelay:     500 * time.Millisecond,
+	MaxDelay:      30 * time.Second,
+	RetryTimeouts: true,
+	RetryResets:   true,
+	RetryStatuses: []int{500, 502, 504},
+}
+
+// isRetryable says if an attempt that failed with err or statusCode should be retried
+func isRetryable(p RetryPolicy, err error, statusCode int) bool {
+	if err == nil {
+		for _, s := range p.RetryStatuses {
+			if s == statusCode {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if p.RetryTimeouts && isTimeout(err) {
+		return true
+	}
+
+	return p.RetryResets && isConnReset(err)
+}
+
+// isTimeout says if the error is a timeout
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// isConnReset says if the connection was reset or closed before the response was complete
+func isConnReset(err error) bool {
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	return strings.Contains(err.Error(), "connection reset by peer")
+}
+
+// retryDelay returns the wait before the given retry(1 for the first retry).
+// The delay grows exponentially and half of it is randomised to spread out retries
+func retryDelay(p RetryPolicy, retry int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < retry && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+
+	if d <= 0 {
+		return 0
+	}
+
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+// sleepCtx sleeps for d or till ctx is done
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,69 @@
+package crawlerlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// timeoutErr is a net.Error that timed out
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+func Test_isRetryable(t *testing.T) {
+	tests := []struct {
+		policy     RetryPolicy
+		err        error
+		statusCode int
+		retryable  bool
+	}{
+		{policy: DefaultRetryPolicy, statusCode: 500, retryable: true},
+		{policy: DefaultRetryPolicy, statusCode: 504, retryable: true},
+		{policy: DefaultRetryPolicy, statusCode: 404},
+		{policy: DefaultRetryPolicy, statusCode: 503},
+		{policy: DefaultRetryPolicy, err: &url.Error{Op: "Get", URL: "http://test.com", Err: timeoutErr{}}, retryable: true},
+		{policy: DefaultRetryPolicy, err: fmt.Errorf("read: %w", syscall.ECONNRESET), retryable: true},
+		{policy: DefaultRetryPolicy, err: &url.Error{Op: "Get", URL: "http://test.com", Err: io.EOF}, retryable: true},
+		{policy: DefaultRetryPolicy, err: context.DeadlineExceeded, retryable: true},
+		{policy: DefaultRetryPolicy, err: errors.New("no such host")},
+		{policy: RetryPolicy{RetryResets: true}, err: timeoutErr{}},
+		{policy: RetryPolicy{RetryTimeouts: true}, err: syscall.ECONNRESET},
+	}
+
+	for _, c := range tests {
+		if r := isRetryable(c.policy, c.err, c.statusCode); r != c.retryable {
+			t.Fatalf("expected %v/%d to be retryable %t but got %t", c.err, c.statusCode, c.retryable, r)
+		}
+	}
+}
+
+func Test_retryDelay(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	tests := []struct {
+		retry int
+		max   time.Duration
+	}{
+		{retry: 1, max: 100 * time.Millisecond},
+		{retry: 2, max: 200 * time.Millisecond},
+		{retry: 3, max: 400 * time.Millisecond},
+		{retry: 5, max: time.Second},
+		{retry: 50, max: time.Second},
+	}
+
+	for _, c := range tests {
+		for i := 0; i < 20; i++ {
+			d := retryDelay(p, c.retry)
+			if d < c.max/2 || d > c.max {
+				t.Fatalf("expected delay of retry %d within [%v, %v] but got %v", c.retry, c.max/2, c.max, d)
+			}
+		}
+	}
+}
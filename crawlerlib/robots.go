@@ -0,0 +1,431 @@
+package crawlerlib
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultRobotsAgent is the product token matched against robots.txt User-agent lines
+const defaultRobotsAgent = "webcrawler"
+
+// maxRobotsSize is the most of a robots.txt file we read, anything after is ignored
+const maxRobotsSize = 500 * 1024
+
+// robotsFetchTimeout bounds the time spent fetching a single robots.txt
+const robotsFetchTimeout = 10 * time.Second
+
+// robotsRule is a single Allow or Disallow line of a robots.txt group
+type robotsRule struct {
+	allow   bool           // allow is true for Allow lines and false for Disallow lines
+	pattern string         // pattern as written in robots.txt
+	re      *regexp.Regexp // re is the compiled form of pattern with * and $ support
+}
+
+// robotsGroup holds the rules that apply to a set of user agents
+type robotsGroup struct {
+	agents     []string      // agents are the lower cased User-agent values of the group
+	rules      []*robotsRule // rules in the order they appear in the group
+	crawlDelay time.Duration // crawlDelay is the Crawl-delay of the group, 0 if not set
+}
+
+// robotsTxt is a parsed robots.txt file
+type robotsTxt struct {
+	groups      []*robotsGroup // groups in the order they appear in the file
+	sitemaps    []string       // sitemaps declared with Sitemap lines
+	group       *robotsGroup   // group is the group that applies to the crawler agent
+	unreachable error          // unreachable is set when robots.txt couldn't be fetched, everything is disallowed
+}
+
+// parseRobots parses a robots.txt file. Unknown lines and rules outside a group are ignored
+func parseRobots(r io.Reader) *robotsTxt {
+	rt := &robotsTxt{}
+	var group *robotsGroup
+	inAgents := false
+	sc := bufio.NewScanner(io.LimitReader(r, maxRobotsSize))
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.Index(line, "#"); i != -1 {
+			line = line[:i]
+		}
+
+		i := strings.Index(line, ":")
+		if i == -1 {
+			continue
+		}
+
+		key := strings.ToLower(strings.TrimSpace(line[:i]))
+		value := strings.TrimSpace(line[i+1:])
+		switch key {
+		case "user-agent":
+			if !inAgents {
+				group = &robotsGroup{}
+				rt.groups = append(rt.groups, group)
+			}
+			group.agents = append(group.agents, strings.ToLower(value))
+			inAgents = true
+			continue
+		case "sitemap":
+			if value != "" {
+				rt.sitemaps = append(rt.sitemaps, value)
+			}
+			continue
+		}
+
+		inAgents = false
+		if group == nil {
+			continue
+		}
+
+		switch key {
+		case "allow", "disallow":
+			// an empty Disallow allows everything, which is the default anyway
+			if value == "" {
+				continue
+			}
+			group.rules = append(group.rules, &robotsRule{
+				allow:   key == "allow",
+				pattern: value,
+				re:      compileRobotsPattern(value),
+			})
+		case "crawl-delay":
+			secs, err := strconv.ParseFloat(value, 64)
+			if err != nil || secs < 0 {
+				continue
+			}
+			group.crawlDelay = time.Duration(secs * float64(time.Second))
+		}
+	}
+
+	return rt
+}
+
+// compileRobotsPattern converts a robots.txt path pattern to a regex.
+// * matches any sequence of characters and a trailing $ anchors the end of the path
+func compileRobotsPattern(pattern string) *regexp.Regexp {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	expr := "^" + strings.Replace(regexp.QuoteMeta(pattern), `\*`, ".*", -1)
+	if anchored {
+		expr += "$"
+	}
+
+	return regexp.MustCompile(expr)
+}
+
+// robotsGroupFor returns the group that applies to the given agent.
+// Groups naming the agent are merged and take precedence over the * group
+func robotsGroupFor(rt *robotsTxt, agent string) *robotsGroup {
+	agent = strings.ToLower(agent)
+	var matched, wildcard *robotsGroup
+	merge := func(dst *robotsGroup, src *robotsGroup) *robotsGroup {
+		if dst == nil {
+			dst = &robotsGroup{}
+		}
+		dst.agents = append(dst.agents, src.agents...)
+		dst.rules = append(dst.rules, src.rules...)
+		if src.crawlDelay > dst.crawlDelay {
+			dst.crawlDelay = src.crawlDelay
+		}
+		return dst
+	}
+
+	for _, g := range rt.groups {
+		named, wild := false, false
+		for _, a := range g.agents {
+			named = named || a == agent
+			wild = wild || a == "*"
+		}
+
+		switch {
+		case named:
+			matched = merge(matched, g)
+		case wild:
+			wildcard = merge(wildcard, g)
+		}
+	}
+
+	if matched != nil {
+		return matched
+	}
+
+	return wildcard
+}
+
+// robotsAllowed checks the path against the group rules. The longest matching
+// pattern wins and Allow wins over Disallow when both are equally long
+func robotsAllowed(g *robotsGroup, path string) (allowed bool, rule *robotsRule) {
+	if g == nil || path == "/robots.txt" {
+		return true, nil
+	}
+
+	for _, r := range g.rules {
+		if !r.re.MatchString(path) {
+			continue
+		}
+
+		if rule == nil || len(r.pattern) > len(rule.pattern) ||
+			(len(r.pattern) == len(rule.pattern) && r.allow) {
+			rule = r
+		}
+	}
+
+	return rule == nil || rule.allow, rule
+}
+
+// robotsPath returns the part of the url robots.txt rules are matched against
+func robotsPath(u *url.URL) string {
+	p := u.EscapedPath()
+	if p == "" {
+		p = "/"
+	}
+
+	if u.RawQuery != "" {
+		p += "?" + u.RawQuery
+	}
+
+	return p
+}
+
+// robotsOrigin returns scheme://host[:port] of the url, robots.txt is kept per origin
+func robotsOrigin(u *url.URL) string {
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
+
+// robotsEntry is a cached robots.txt, ready is closed once rules is set
+type robotsEntry struct {
+	ready chan struct{}
+	rules *robotsTxt
+}
+
+// robotsCache fetches robots.txt once per origin and caches the result
+type robotsCache struct {
+	ctx     context.Context         // ctx of the crawl, the robots.txt fetches stop with it
+	fetcher *fetcher                // fetcher makes the robots.txt requests
+	agent   string                  // agent is the product token used to pick the robots.txt group
+	mu      *sync.Mutex             // protects the below
+	entries map[string]*robotsEntry // entries are keyed by origin
+	arrived chan struct{}           // arrived is closed and replaced whenever a robots.txt is ready
+}
+
+// newRobotsCache returns a robots cache fetching with the given fetcher for its user agent during ctx
+func newRobotsCache(ctx context.Context, f *fetcher) *robotsCache {
+	return &robotsCache{
+		ctx:     ctx,
+		fetcher: f,
+		agent:   robotsAgent(f),
+		mu:      &sync.Mutex{},
+		entries: make(map[string]*robotsEntry),
+		arrived: make(chan struct{}),
+	}
+}
+
+// fetchRobots fetches and parses robots.txt of the origin.
+// 4xx means there are no rules and any other failure disallows everything
+func fetchRobots(ctx context.Context, f *fetcher, origin string) *robotsTxt {
+	u, err := url.Parse(origin + "/robots.txt")
+	if err != nil {
+		return &robotsTxt{unreachable: err}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, robotsFetchTimeout)
+	defer cancel()
+	req, err := newRequest(ctx, f, http.MethodGet, u)
+	if err != nil {
+		return &robotsTxt{unreachable: err}
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return &robotsTxt{unreachable: err}
+	}
+
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return parseRobots(resp.Body)
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxRobotsSize))
+		return &robotsTxt{}
+	default:
+		return &robotsTxt{unreachable: fmt.Errorf("robots.txt responded with code %d", resp.StatusCode)}
+	}
+}
+
+// fetchRobotsSlot fetches robots.txt of the origin with a request slot of its host taken, so the
+// fetch keeps to the host limits like the pages do. Without a scheduler it is fetched right away
+func fetchRobotsSlot(ctx context.Context, f *fetcher, host, origin string) *robotsTxt {
+	if f.scheduler == nil {
+		return fetchRobots(ctx, f, origin)
+	}
+
+	if err := waitForSlot(ctx, f.scheduler, host); err != nil {
+		return &robotsTxt{unreachable: err}
+	}
+	defer release(f.scheduler, host)
+
+	return fetchRobots(ctx, f, origin)
+}
+
+// startRobots returns the entry of the url origin, fetching its robots.txt in the background on first use
+func startRobots(rc *robotsCache, u *url.URL) *robotsEntry {
+	origin := robotsOrigin(u)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if e, ok := rc.entries[origin]; ok {
+		return e
+	}
+
+	e := &robotsEntry{ready: make(chan struct{})}
+	rc.entries[origin] = e
+	go func() {
+		rules := fetchRobotsSlot(rc.ctx, rc.fetcher, u.Host, origin)
+		rules.group = robotsGroupFor(rules, rc.agent)
+
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		e.rules = rules
+		close(e.ready)
+		close(rc.arrived)
+		rc.arrived = make(chan struct{})
+	}()
+
+	return e
+}
+
+// robotsReady says if robots.txt of the url origin is fetched, starting to fetch it when it isn't
+func robotsReady(rc *robotsCache, u *url.URL) bool {
+	e := startRobots(rc, u)
+	select {
+	case <-e.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// robotsArrived returns a channel closed once a robots.txt being fetched is ready
+func robotsArrived(rc *robotsCache) <-chan struct{} {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	return rc.arrived
+}
+
+// robotsFor returns the robots.txt of the url origin, waiting for it to be fetched on first use.
+// Concurrent callers for the same origin wait for the single fetch
+func robotsFor(rc *robotsCache, u *url.URL) *robotsTxt {
+	e := startRobots(rc, u)
+	<-e.ready
+	return e.rules
+}
+
+// robotsCheck says if the url may be crawled and why not when it may not
+func robotsCheck(rc *robotsCache, u *url.URL) (allowed bool, reason string) {
+	rt := robotsFor(rc, u)
+	if rt.unreachable != nil {
+		return false, fmt.Sprintf("robots.txt unreachable: %v", rt.unreachable)
+	}
+
+	allowed, rule := robotsAllowed(rt.group, robotsPath(u))
+	if allowed {
+		return true, ""
+	}
+
+	return false, fmt.Sprintf("disallowed by robots.txt: Disallow: %s", rule.pattern)
+}
+
+// robotsCrawlDelay returns the Crawl-delay robots.txt asks for the url host
+func robotsCrawlDelay(rc *robotsCache, u *url.URL) time.Duration {
+	g := robotsFor(rc, u).group
+	if g == nil {
+		return 0
+	}
+
+	return g.crawlDelay
+}
+
+// robotsHeaderDirectives are X-Robots-Tag directives that take a value after a colon,
+// any other word before a colon names the user agent the directives are for
+var robotsHeaderDirectives = map[string]bool{
+	"unavailable_after": true,
+	"max-snippet":       true,
+	"max-image-preview": true,
+	"max-video-preview": true,
+}
+
+// pageRobotsDirectives returns the lower cased directives of the robots meta tags and X-Robots-Tag
+// headers of a page that apply to the agent, e.g. noindex and nofollow
+func pageRobotsDirectives(agent string, metas map[string][]string, xRobotsTags []string) []string {
+	agent = strings.ToLower(agent)
+	var directives []string
+	add := func(v string) {
+		for _, d := range strings.Split(strings.ToLower(v), ",") {
+			if d = strings.TrimSpace(d); d != "" {
+				directives = append(directives, d)
+			}
+		}
+	}
+
+	for _, name := range []string{"robots", agent} {
+		for _, v := range metas[name] {
+			add(v)
+		}
+	}
+
+	for _, v := range xRobotsTags {
+		if i := strings.Index(v, ":"); i != -1 {
+			prefix := strings.ToLower(strings.TrimSpace(v[:i]))
+			if !strings.ContainsAny(prefix, " ,") && !robotsHeaderDirectives[prefix] {
+				if prefix == agent {
+					add(v[i+1:])
+				}
+				continue
+			}
+		}
+		add(v)
+	}
+
+	return directives
+}
+
+// dropNofollow stops the links marked rel=nofollow from being followed, or every link when the page is nofollow.
+// The canonical url is not a link and is still followed
+func dropNofollow(links []*pageLink, pageNofollow bool) {
+	for _, l := range links {
+		if !l.follow || l.kind == LinkCanonical {
+			continue
+		}
+
+		for _, r := range l.rel {
+			if r == "nofollow" {
+				l.follow = false
+			}
+		}
+
+		if pageNofollow {
+			l.follow = false
+		}
+	}
+}
+
+// hasRobotsDirective says if the directives hold d, none stands for both noindex and nofollow
+func hasRobotsDirective(directives []string, d string) bool {
+	for _, v := range directives {
+		if v == d || (v == "none" && (d == "noindex" || d == "nofollow")) {
+			return true
+		}
+	}
+
+	return false
+}
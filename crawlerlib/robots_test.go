@@ -0,0 +1,334 @@
+package crawlerlib
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testRobots = `# comment line
+User-agent: *
+Disallow: /private
+Allow: /private/public
+Disallow: /*.pdf$
+Crawl-delay: 2
+
+User-agent: WebCrawler
+User-agent: other
+Disallow: /no-crawler
+Disallow: /tmp/*/cache
+Allow: /tmp/
+Crawl-delay: 0.5
+
+Sitemap: https://test.com/sitemap.xml
+Sitemap: https://test.com/sitemap-2.xml
+`
+
+func Test_robotsAllowed(t *testing.T) {
+	tests := []struct {
+		agent   string
+		path    string
+		allowed bool
+	}{
+		{agent: "somebot", path: "/", allowed: true},
+		{agent: "somebot", path: "/private", allowed: false},
+		{agent: "somebot", path: "/private/page", allowed: false},
+		{agent: "somebot", path: "/private/public/page", allowed: true},
+		{agent: "somebot", path: "/files/a.pdf", allowed: false},
+		{agent: "somebot", path: "/files/a.pdf?x=1", allowed: true},
+		{agent: "somebot", path: "/robots.txt", allowed: true},
+		{agent: "webcrawler", path: "/private", allowed: true},
+		{agent: "webcrawler", path: "/no-crawler/1", allowed: false},
+		{agent: "webcrawler", path: "/tmp/a/cache", allowed: false},
+		{agent: "webcrawler", path: "/tmp/a/b", allowed: true},
+	}
+
+	rt := parseRobots(strings.NewReader(testRobots))
+	for _, c := range tests {
+		allowed, _ := robotsAllowed(robotsGroupFor(rt, c.agent), c.path)
+		if allowed != c.allowed {
+			t.Fatalf("expected %s allowed %t for %s but got %t", c.path, c.allowed, c.agent, allowed)
+		}
+	}
+}
+
+func Test_parseRobots(t *testing.T) {
+	rt := parseRobots(strings.NewReader(testRobots))
+	if len(rt.groups) != 2 {
+		t.Fatalf("expected 2 groups but got %d", len(rt.groups))
+	}
+
+	if len(rt.sitemaps) != 2 || rt.sitemaps[1] != "https://test.com/sitemap-2.xml" {
+		t.Fatalf("unexpected sitemaps: %v", rt.sitemaps)
+	}
+
+	tests := []struct {
+		agent string
+		delay time.Duration
+	}{
+		{agent: "somebot", delay: 2 * time.Second},
+		{agent: "WebCrawler", delay: 500 * time.Millisecond},
+		{agent: "other", delay: 500 * time.Millisecond},
+	}
+
+	for _, c := range tests {
+		g := robotsGroupFor(rt, c.agent)
+		if g.crawlDelay != c.delay {
+			t.Fatalf("expected crawl delay %v for %s but got %v", c.delay, c.agent, g.crawlDelay)
+		}
+	}
+}
+
+func Test_robotsCheck(t *testing.T) {
+	var fetches int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/robots.txt" {
+			http.NotFound(w, r)
+			return
+		}
+
+		atomic.AddInt32(&fetches, 1)
+		fmt.Fprint(w, testRobots)
+	}))
+	defer ts.Close()
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+
+	tests := []struct {
+		u       string
+		allowed bool
+	}{
+		{u: ts.URL + "/", allowed: true},
+		{u: ts.URL + "/no-crawler/page", allowed: false},
+		{u: ts.URL + "/private", allowed: true},
+		{u: failing.URL + "/", allowed: false},
+		{u: missing.URL + "/private", allowed: true},
+	}
+
+	f, _ := newFetcher(FetcherConfig{Client: ts.Client()})
+	rc := newRobotsCache(context.Background(), f)
+	for _, c := range tests {
+		u, _ := url.Parse(c.u)
+		allowed, reason := robotsCheck(rc, u)
+		if allowed != c.allowed {
+			t.Fatalf("expected %s allowed %t but got %t", c.u, c.allowed, allowed)
+		}
+
+		if !allowed && reason == "" {
+			t.Fatalf("expected a reason for disallowing %s", c.u)
+		}
+	}
+
+	if fetches != 1 {
+		t.Fatalf("expected robots.txt to be fetched once but fetched %d times", fetches)
+	}
+}
+
+func Test_robotsSlot(t *testing.T) {
+	var fetches int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		fmt.Fprint(w, testRobots)
+	}))
+	defer ts.Close()
+
+	f, _ := newFetcher(FetcherConfig{Client: ts.Client()})
+	f.scheduler, _ = newHostScheduler(Politeness{Default: HostLimit{MaxInFlight: 1}})
+	rc := newRobotsCache(context.Background(), f)
+	u, _ := url.Parse(ts.URL + "/")
+
+	// a page being crawled holds the only slot of the host
+	acquireAny(f.scheduler, []string{u.Host})
+	robotsReady(rc, u)
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt32(&fetches) != 0 || robotsReady(rc, u) {
+		t.Fatal("expected robots.txt to wait for the slot of the host")
+	}
+
+	release(f.scheduler, u.Host)
+	robotsFor(rc, u)
+	if i, _, _ := acquireAny(f.scheduler, []string{u.Host}); atomic.LoadInt32(&fetches) != 1 || i != 0 {
+		t.Fatalf("expected robots.txt fetched once and the slot given back but got %d fetches", fetches)
+	}
+}
+
+func Test_pageRobotsDirectives(t *testing.T) {
+	tests := []struct {
+		metas    map[string][]string
+		headers  []string
+		expected []string
+		noIndex  bool
+	}{
+		{},
+		{
+			metas:    map[string][]string{"robots": {"NoIndex, Follow"}, "othercrawler": {"nofollow"}},
+			expected: []string{"noindex", "follow"},
+			noIndex:  true,
+		},
+		{
+			metas:    map[string][]string{"webcrawler": {"none"}},
+			expected: []string{"none"},
+			noIndex:  true,
+		},
+		{
+			headers:  []string{"otherbot: noindex", "webcrawler: nofollow", "unavailable_after: 25 Jun 2010 15:00:00 PST"},
+			expected: []string{"nofollow", "unavailable_after: 25 jun 2010 15:00:00 pst"},
+		},
+		{
+			headers:  []string{"noindex, noarchive"},
+			expected: []string{"noindex", "noarchive"},
+			noIndex:  true,
+		},
+	}
+
+	for i, c := range tests {
+		got := pageRobotsDirectives("WebCrawler", c.metas, c.headers)
+		if !reflect.DeepEqual(got, c.expected) {
+			t.Fatalf("case %d: expected %v but got %v", i, c.expected, got)
+		}
+
+		if hasRobotsDirective(got, "noindex") != c.noIndex {
+			t.Fatalf("case %d: expected noindex %t for %v", i, c.noIndex, got)
+		}
+	}
+}
+
+func TestCrawler_RunRobotsAtMaxDepth(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			fmt.Fprint(w, "User-agent: *\nDisallow: /secret\n")
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<a href="/secret">secret</a><a href="/ok">ok</a>`)
+	}))
+	defer ts.Close()
+
+	cr, err := New(WithOutput(nil), WithMaxDepth(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := cr.Run(context.Background(), ts.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var unique []string
+	for u := range resp.UniqueURLs {
+		unique = append(unique, strings.TrimPrefix(u, ts.URL))
+	}
+
+	sort.Strings(unique)
+	if fmt.Sprint(unique) != "[/ /ok]" {
+		t.Fatalf("expected / and /ok to be the unique urls but got %v", unique)
+	}
+
+	if got := resp.SkipReasons[ts.URL+"/secret"]; got != "disallowed by robots.txt: Disallow: /secret" {
+		t.Fatalf("expected /secret to be skipped by robots.txt but got %q", got)
+	}
+}
+
+func TestCrawler_RunSlowRobots(t *testing.T) {
+	reached := make(chan struct{})
+	var timedOut int32
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			// robots.txt is only served once the other origin was crawled past its seed
+			select {
+			case <-reached:
+			case <-time.After(2 * time.Second):
+				atomic.StoreInt32(&timedOut, 1)
+			}
+			fmt.Fprint(w, "User-agent: *\nDisallow: /secret\n")
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+	}))
+	defer slow.Close()
+
+	var once sync.Once
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprintf(w, `<a href="/1">1</a><a href="%s/x">x</a><a href="%s/secret">secret</a>`, slow.URL, slow.URL)
+		case "/1":
+			fmt.Fprint(w, `<a href="/2">2</a>`)
+		case "/2":
+			once.Do(func() { close(reached) })
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	cr, err := New(WithOutput(nil), WithMaxDepth(-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := cr.Run(context.Background(), ts.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if atomic.LoadInt32(&timedOut) == 1 {
+		t.Fatal("expected the crawl to go on while robots.txt of the other origin was fetched")
+	}
+
+	if _, ok := resp.Pages[slow.URL+"/x"]; !ok || len(resp.Pages) != 4 {
+		t.Fatalf("expected /x to be crawled once robots.txt was fetched but got %d pages", len(resp.Pages))
+	}
+
+	if !strings.HasPrefix(resp.SkipReasons[slow.URL+"/secret"], "disallowed by robots.txt") {
+		t.Fatalf("expected /secret to be skipped by robots.txt but got %q", resp.SkipReasons[slow.URL+"/secret"])
+	}
+}
+
+func TestCrawler_RunRobotsCancelled(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// robots.txt never comes
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	cr, err := New(WithOutput(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	resp, err := cr.Run(ctx, ts.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d := time.Since(start); !resp.Interrupted || d > 2*time.Second {
+		t.Fatalf("expected the crawl to stop with its context but it took %v, interrupted %t", d, resp.Interrupted)
+	}
+}
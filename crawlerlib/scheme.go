@@ -0,0 +1,102 @@
+package crawlerlib
+
+import (
+	"net/url"
+	"strings"
+)
+
+// DefaultSchemes are the url schemes crawled when none are configured
+var DefaultSchemes = []string{"http", "https"}
+
+// contactSchemes are the schemes whose addresses are collected, the rest are only counted
+var contactSchemes = map[string]bool{"mailto": true, "tel": true, "sms": true}
+
+// SchemeStats counts the links found to urls of a scheme that isn't crawled, e.g. mailto or javascript
+type SchemeStats struct {
+	Links   int                 `json:"links"`             // Links is the number of links found
+	Targets map[string][]string `json:"targets,omitempty"` // Targets maps the addresses of mailto, tel and sms links to the pages linking to them
+}
+
+// schemeLink is a link to a url of a scheme that isn't crawled
+type schemeLink struct {
+	scheme  string   // scheme of the url, lower cased
+	targets []string // targets are the addresses of mailto, tel and sms links, empty for other schemes
+}
+
+// hrefScheme returns the lower cased scheme of an absolute href, empty for relative ones
+func hrefScheme(href string) string {
+	for i := 0; i < len(href); i++ {
+		c := href[i]
+		switch {
+		case 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z':
+		case '0' <= c && c <= '9' || c == '+' || c == '-' || c == '.':
+			if i == 0 {
+				return ""
+			}
+		case c == ':':
+			return strings.ToLower(href[:i])
+		default:
+			return ""
+		}
+	}
+
+	return ""
+}
+
+// contactTargets returns the addresses of a mailto, tel or sms href, nil for other schemes.
+// mailto and sms hrefs may list several addresses, headers like ?subject= are dropped
+func contactTargets(scheme, href string) []string {
+	if !contactSchemes[scheme] {
+		return nil
+	}
+
+	v := href[len(scheme)+1:]
+	if i := strings.IndexByte(v, '?'); i != -1 {
+		v = v[:i]
+	}
+
+	if u, err := url.PathUnescape(v); err == nil {
+		v = u
+	}
+
+	addrs := []string{v}
+	if scheme != "tel" {
+		addrs = strings.Split(v, ",")
+	}
+
+	var targets []string
+	for _, a := range addrs {
+		a = strings.TrimSpace(a)
+		if scheme == "mailto" {
+			a = strings.ToLower(a)
+		}
+
+		if a != "" {
+			targets = appendUnique(targets, a)
+		}
+	}
+
+	return targets
+}
+
+// schemeProcessor counts the links of the source url page to schemes that aren't crawled
+// and collects the addresses of the mailto, tel and sms links
+func schemeProcessor() processor {
+	return processorFunc(func(g *delegator, md *scraperDump) (proceed bool) {
+		source := md.sourceURL.String()
+		for _, l := range md.schemeLinks {
+			s, ok := g.schemes[l.scheme]
+			if !ok {
+				s = &SchemeStats{Targets: make(map[string][]string)}
+				g.schemes[l.scheme] = s
+			}
+
+			s.Links++
+			for _, t := range l.targets {
+				s.Targets[t] = appendUnique(s.Targets[t], source)
+			}
+		}
+
+		return true
+	})
+}
@@ -0,0 +1,104 @@
+package crawlerlib
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_hrefScheme(t *testing.T) {
+	tests := []struct {
+		href     string
+		expected string
+	}{
+		{href: "MailTo:a@b.com", expected: "mailto"},
+		{href: "tel:+44 20 1234", expected: "tel"},
+		{href: "javascript:void(0)", expected: "javascript"},
+		{href: "svn+ssh://host/repo", expected: "svn+ssh"},
+		{href: "/a:b", expected: ""},
+		{href: "a/b:c", expected: ""},
+		{href: "?q=a:b", expected: ""},
+		{href: ":x", expected: ""},
+		{href: "1tel:1", expected: ""},
+		{href: "page", expected: ""},
+	}
+
+	for _, c := range tests {
+		if got := hrefScheme(c.href); got != c.expected {
+			t.Fatalf("expected scheme %q for %q but got %q", c.expected, c.href, got)
+		}
+	}
+}
+
+func Test_contactTargets(t *testing.T) {
+	tests := []struct {
+		scheme   string
+		href     string
+		expected []string
+	}{
+		{scheme: "mailto", href: "mailto:Info@Example.com?subject=Hi", expected: []string{"info@example.com"}},
+		{scheme: "mailto", href: "mailto:a@x.com,%20b@y.com,a@x.com", expected: []string{"a@x.com", "b@y.com"}},
+		{scheme: "mailto", href: "mailto:?subject=Hi"},
+		{scheme: "tel", href: "tel:+1-555-0100", expected: []string{"+1-555-0100"}},
+		{scheme: "sms", href: "sms:+15550100,+15550101?body=hi", expected: []string{"+15550100", "+15550101"}},
+		{scheme: "javascript", href: "javascript:alert(1)"},
+	}
+
+	for _, c := range tests {
+		if got := contactTargets(c.scheme, c.href); !reflect.DeepEqual(got, c.expected) {
+			t.Fatalf("expected targets %v for %q but got %v", c.expected, c.href, got)
+		}
+	}
+}
+
+func TestCrawler_RunSchemes(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<a href="/contact">contact</a><a href="mailto:hi@test.com">mail</a><a href="javascript:void(0)">js</a>`)
+		case "/contact":
+			fmt.Fprint(w, `<a href="mailto:HI@test.com?subject=x">mail</a><a href="tel:+1-555-0100">call</a><img src="data:image/gif;base64,R0l">`)
+		}
+	}))
+	defer ts.Close()
+
+	cr, err := New(WithIgnoreRobots(true), WithOutput(nil), WithConcurrency(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := cr.Run(context.Background(), ts.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for source, urls := range resp.SkippedURLs {
+		if len(urls) > 0 {
+			t.Fatalf("expected no skipped urls but %s has %v", source, urls)
+		}
+	}
+
+	counts := make(map[string]int)
+	for s, stats := range resp.Schemes {
+		counts[s] = stats.Links
+	}
+
+	expected := map[string]int{"mailto": 2, "javascript": 1, "tel": 1, "data": 1}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Fatalf("expected scheme counts %v but got %v", expected, counts)
+	}
+
+	pages := resp.Schemes["mailto"].Targets["hi@test.com"]
+	if len(pages) != 2 {
+		t.Fatalf("expected hi@test.com to be linked from 2 pages but got %v", pages)
+	}
+
+	if tel := resp.Schemes["tel"].Targets["+1-555-0100"]; len(tel) != 1 || !strings.HasSuffix(tel[0], "/contact") {
+		t.Fatalf("expected the tel number to be linked from /contact but got %v", tel)
+	}
+}
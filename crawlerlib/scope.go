@@ -0,0 +1,220 @@
+package crawlerlib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// ScopeTarget is the part of a url a scope rule is matched against
+type ScopeTarget string
+
+const (
+	ScopeURL  ScopeTarget = "url"  // ScopeURL matches the whole normalized url
+	ScopeHost ScopeTarget = "host" // ScopeHost matches the host without the port
+	ScopePath ScopeTarget = "path" // ScopePath matches the escaped path, / when empty
+)
+
+// ScopeRule includes or excludes the urls whose target matches the pattern.
+// Rules are checked in order and the first match decides
+type ScopeRule struct {
+	Include bool        // Include says if matching urls are crawled, otherwise they are excluded
+	Target  ScopeTarget // Target is the part of the url matched, empty means ScopePath
+	Pattern string      // Pattern is a glob where * matches any run of characters and ? any one, or a regex after re:
+}
+
+// String returns the rule as it is written in scope files, e.g. "exclude path /admin/*"
+func (r ScopeRule) String() string {
+	action := "exclude"
+	if r.Include {
+		action = "include"
+	}
+
+	target := r.Target
+	if target == "" {
+		target = ScopePath
+	}
+
+	return fmt.Sprintf("%s %s %s", action, target, r.Pattern)
+}
+
+// ParseScopeRule parses a rule written as "include|exclude [url|host|path] pattern",
+// e.g. "exclude path *.pdf" or "include url re:^https://example\.com/docs/". The target defaults to path
+func ParseScopeRule(s string) (ScopeRule, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 2 {
+		return ScopeRule{}, fmt.Errorf("invalid scope rule %q, expected include|exclude [url|host|path] pattern", s)
+	}
+
+	var r ScopeRule
+	switch strings.ToLower(fields[0]) {
+	case "include":
+		r.Include = true
+	case "exclude":
+	default:
+		return ScopeRule{}, fmt.Errorf("invalid scope rule %q, expected it to start with include or exclude", s)
+	}
+
+	rest := fields[1:]
+	r.Target = ScopePath
+	if t := ScopeTarget(strings.ToLower(rest[0])); len(rest) > 1 && isScopeTarget(t) {
+		r.Target, rest = t, rest[1:]
+	}
+
+	r.Pattern = strings.Join(rest, " ")
+	if _, err := compileScopePattern(r.Pattern); err != nil {
+		return ScopeRule{}, fmt.Errorf("invalid scope rule %q: %v", s, err)
+	}
+
+	return r, nil
+}
+
+// ReadScopeRules reads the rules of a scope file, one per line. Blank lines and lines starting with # are ignored
+func ReadScopeRules(r io.Reader) ([]ScopeRule, error) {
+	var rules []ScopeRule
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule, err := ParseScopeRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, s.Err()
+}
+
+// isScopeTarget says if t is a known scope target
+func isScopeTarget(t ScopeTarget) bool {
+	return t == ScopeURL || t == ScopeHost || t == ScopePath
+}
+
+// compileScopePattern compiles a glob or, after re:, a regex pattern. globs match the whole target
+func compileScopePattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("pattern can't be empty")
+	}
+
+	if strings.HasPrefix(pattern, "re:") {
+		return regexp.Compile(pattern[3:])
+	}
+
+	var b strings.Builder
+	b.WriteString("^")
+	for _, c := range pattern {
+		switch c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+
+	return regexp.Compile(b.String())
+}
+
+// scopeRule is a compiled ScopeRule
+type scopeRule struct {
+	rule ScopeRule      // rule as configured
+	re   *regexp.Regexp // re matches the target of the rule
+}
+
+// scope is a compiled list of scope rules
+type scope struct {
+	rules    []scopeRule // rules in the order they are checked
+	includes bool        // includes says if any rule includes, urls matching no rule are then out of scope
+}
+
+// newScope compiles the rules, nil when there are none
+func newScope(rules []ScopeRule) (*scope, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	s := &scope{}
+	for _, r := range rules {
+		if r.Target == "" {
+			r.Target = ScopePath
+		}
+
+		if !isScopeTarget(r.Target) {
+			return nil, fmt.Errorf("unknown scope target %q in rule %q, expected url, host or path", r.Target, r)
+		}
+
+		re, err := compileScopePattern(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scope rule %q: %v", r, err)
+		}
+
+		s.rules = append(s.rules, scopeRule{rule: r, re: re})
+		s.includes = s.includes || r.Include
+	}
+
+	return s, nil
+}
+
+// scopeAllows checks the url against the rules, reason says which rule rejected it.
+// A nil scope allows every url
+func scopeAllows(s *scope, u *url.URL) (ok bool, reason string) {
+	if s == nil {
+		return true, ""
+	}
+
+	for _, r := range s.rules {
+		var target string
+		switch r.rule.Target {
+		case ScopeURL:
+			target = u.String()
+		case ScopeHost:
+			target = u.Hostname()
+		default:
+			target = u.EscapedPath()
+			if target == "" {
+				target = "/"
+			}
+		}
+
+		if !r.re.MatchString(target) {
+			continue
+		}
+
+		if r.rule.Include {
+			return true, ""
+		}
+
+		return false, fmt.Sprintf("excluded by scope rule %q", r.rule)
+	}
+
+	if s.includes {
+		return false, "matches no include scope rule"
+	}
+
+	return true, ""
+}
+
+// scopeCheck says if the url is in scope: its host must pass the domain regex, the url the scope rules
+// and the url must be in the scope of a site when there are sites.
+// reason says which scope rule rejected it, empty when the host is out of scope
+func scopeCheck(g *delegator, u *url.URL) (ok bool, reason string) {
+	if g.domainRegex != nil && !g.domainRegex.MatchString(u.Hostname()) {
+		return false, ""
+	}
+
+	if ok, reason := scopeAllows(g.scope, u); !ok || len(g.sites) == 0 {
+		return ok, reason
+	}
+
+	s, reason := siteOf(g, u)
+	return s != nil, reason
+}
@@ -0,0 +1,182 @@
+package crawlerlib
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseScopeRule(t *testing.T) {
+	tests := []struct {
+		rule     string
+		expected ScopeRule
+		err      bool
+	}{
+		{rule: "exclude /admin/*", expected: ScopeRule{Target: ScopePath, Pattern: "/admin/*"}},
+		{rule: "Include HOST docs.example.com", expected: ScopeRule{Include: true, Target: ScopeHost, Pattern: "docs.example.com"}},
+		{rule: "exclude url re:\\?sessionid=", expected: ScopeRule{Target: ScopeURL, Pattern: "re:\\?sessionid="}},
+		{rule: "exclude path", expected: ScopeRule{Target: ScopePath, Pattern: "path"}},
+		{rule: "exclude", err: true},
+		{rule: "allow /docs/*", err: true},
+		{rule: "exclude url re:(", err: true},
+	}
+
+	for _, c := range tests {
+		r, err := ParseScopeRule(c.rule)
+		if c.err {
+			if err == nil {
+				t.Fatalf("expected %q to fail", c.rule)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if r != c.expected {
+			t.Fatalf("expected %q to parse as %+v but got %+v", c.rule, c.expected, r)
+		}
+	}
+}
+
+func TestReadScopeRules(t *testing.T) {
+	rules, err := ReadScopeRules(strings.NewReader("# docs only\n\nexclude *.pdf\n  include /docs/*\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprint(rules) != "[exclude path *.pdf include path /docs/*]" {
+		t.Fatalf("unexpected rules %v", rules)
+	}
+
+	if _, err := ReadScopeRules(strings.NewReader("exclude *.pdf\nkeep /docs\n")); err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Fatalf("expected the error of line 2 but got %v", err)
+	}
+}
+
+func Test_scopeAllows(t *testing.T) {
+	rules := []ScopeRule{
+		{Target: ScopePath, Pattern: "*.pdf"},
+		{Target: ScopePath, Pattern: "/docs/internal/*"},
+		{Target: ScopeURL, Pattern: "re:[?&]sessionid="},
+		{Include: true, Pattern: "/docs/*"},
+		{Include: true, Target: ScopeHost, Pattern: "blog.*"},
+	}
+
+	s, err := newScope(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		url    string
+		ok     bool
+		reason string
+	}{
+		{url: "https://example.com/docs/a", ok: true},
+		{url: "https://example.com/docs/a.pdf", reason: `excluded by scope rule "exclude path *.pdf"`},
+		{url: "https://example.com/docs/internal/x", reason: `excluded by scope rule "exclude path /docs/internal/*"`},
+		{url: "https://example.com/docs/?a=1&sessionid=2", reason: `excluded by scope rule "exclude url re:[?&]sessionid="`},
+		{url: "https://blog.example.com/", ok: true},
+		{url: "https://example.com", reason: "matches no include scope rule"},
+		{url: "https://example.com/admin/", reason: "matches no include scope rule"},
+	}
+
+	for _, c := range tests {
+		u, _ := url.Parse(c.url)
+		ok, reason := scopeAllows(s, u)
+		if ok != c.ok || reason != c.reason {
+			t.Fatalf("expected %s to be allowed %t (%q) but got %t (%q)", c.url, c.ok, c.reason, ok, reason)
+		}
+	}
+
+	if _, err := newScope([]ScopeRule{{Target: "query", Pattern: "*"}}); err == nil {
+		t.Fatal("expected an unknown target to fail")
+	}
+}
+
+func TestCrawler_RunScopeRules(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, `<a href="/docs/a">a</a><a href="/docs/b.pdf">b</a><a href="/admin/">admin</a><a href="/about">about</a>`)
+			return
+		}
+		fmt.Fprint(w, `<a href="/docs/admin/x">x</a>`)
+	}))
+	defer ts.Close()
+
+	cr, err := New(WithIgnoreRobots(true), WithOutput(nil), WithConcurrency(2), WithScopeRules(
+		ScopeRule{Pattern: "*.pdf"},
+		ScopeRule{Pattern: "*/admin/*"},
+		ScopeRule{Include: true, Pattern: "/docs/*"},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := cr.Run(context.Background(), ts.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var crawled []string
+	for u := range resp.Pages {
+		crawled = append(crawled, strings.TrimPrefix(u, ts.URL))
+	}
+
+	sort.Strings(crawled)
+	if fmt.Sprint(crawled) != "[/ /docs/a]" {
+		t.Fatalf("expected / and /docs/a to be crawled but got %v", crawled)
+	}
+
+	reasons := map[string]string{
+		"/docs/b.pdf":   `excluded by scope rule "exclude path *.pdf"`,
+		"/admin/":       `excluded by scope rule "exclude path */admin/*"`,
+		"/about":        "matches no include scope rule",
+		"/docs/admin/x": `excluded by scope rule "exclude path */admin/*"`,
+	}
+	for u, reason := range reasons {
+		if got := resp.SkipReasons[ts.URL+u]; got != reason {
+			t.Fatalf("expected %s to be skipped as %q but got %q", u, reason, got)
+		}
+	}
+}
+
+func TestCrawler_RunScopeRulesAtMaxDepth(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<a href="/admin/x">admin</a><a href="/ok">ok</a>`)
+	}))
+	defer ts.Close()
+
+	cr, err := New(WithIgnoreRobots(true), WithOutput(nil), WithMaxDepth(1), WithScopeRules(ScopeRule{Pattern: "/admin/*"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := cr.Run(context.Background(), ts.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var unique []string
+	for u := range resp.UniqueURLs {
+		unique = append(unique, strings.TrimPrefix(u, ts.URL))
+	}
+
+	sort.Strings(unique)
+	if fmt.Sprint(unique) != "[/ /ok]" {
+		t.Fatalf("expected / and /ok to be the unique urls but got %v", unique)
+	}
+
+	if got := resp.SkipReasons[ts.URL+"/admin/x"]; got != `excluded by scope rule "exclude path /admin/*"` {
+		t.Fatalf("expected /admin/x to be skipped by the scope rule but got %q", got)
+	}
+}
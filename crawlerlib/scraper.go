@@ -3,124 +3,235 @@ package crawlerlib
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"strings"
-	"sync"
+	"time"
 )
 
 // scraper crawls the link, scrape urls normalises then and returns the dump to delegator
 type scraper struct {
 	name            string
-	busy            bool                 // busy represents whether scraper is idle/busy
-	mu              *sync.RWMutex        // protects the above
-	payloadCh       chan *scraperPayload // payload listens for urls to be scrapped
+	fetcher         *fetcher             // fetcher is shared by all the scrapers of a delegator
+	logger          *log.Logger          // logger for the crawl progress, nil uses the standard logger
+	workCh          <-chan *frontierItem // workCh is the queue shared by the scrapers to pull urls from
 	delegatorDumpCh chan<- *scraperDumps // delegatorDumpCh to send finished data to delegator
 }
 
-// newScraper returns a new scraper under given delegator
-func newScraper(name string, delegatorDumpCh chan<- *scraperDumps) *scraper {
+// newScraper returns a new scraper pulling urls from the work queue of a delegator
+func newScraper(name string, f *fetcher, workCh <-chan *frontierItem, delegatorDumpCh chan<- *scraperDumps) *scraper {
 	return &scraper{
 		name:            name,
-		mu:              &sync.RWMutex{},
-		payloadCh:       make(chan *scraperPayload),
+		fetcher:         f,
+		workCh:          workCh,
 		delegatorDumpCh: delegatorDumpCh,
 	}
 }
 
-// isBusy says if the scraper is busy or idle
-func isBusy(m *scraper) bool {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	return m.busy
-}
-
-// setBusy sets scraper to busy
-func setBusy(m *scraper)  {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	m.busy = true
-}
-
-// setAvailable sets scraper to available state
-func setAvailable(m *scraper) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+// fetchURL makes a single attempt to fetch the url and extract the urls from the page
+func fetchURL(ctx context.Context, f *fetcher, depth int, u *url.URL) (md *scraperDump) {
+	md = &scraperDump{
+		depth:     depth + 1,
+		sourceURL: u,
+		page:      &Page{URL: u.String(), Depth: depth},
+	}
 
-	m.busy = false
-}
+	ctx, cancel := context.WithTimeout(ctx, f.requestTimeout)
+	defer cancel()
+	req, err := newRequest(ctx, f, http.MethodGet, u)
+	if err != nil {
+		md.err = err
+		return md
+	}
 
-// crawlURL crawls the url and extracts the urls from the page
-func crawlURL(depth int, u *url.URL) (md *scraperDump) {
-	resp, err := http.DefaultClient.Get(u.String())
+	resp, err := f.client.Do(req)
 	if err != nil {
-		return &scraperDump{
-			depth:     depth + 1,
-			sourceURL: u,
-			err:       err,
-		}
+		md.err = err
+		return md
 	}
 
 	defer resp.Body.Close()
-
+	md.statusCode = resp.StatusCode
+	md.page.StatusCode = resp.StatusCode
+	if final := f.extractor.normalizer.Normalize(resp.Request.URL).String(); final != md.page.URL {
+		md.page.RedirectTo = final
+	}
 	if resp.StatusCode != http.StatusOK {
-		return &scraperDump{
-			depth:     depth + 1,
-			sourceURL: u,
-			err:       fmt.Errorf("url responsed with code %d", resp.StatusCode),
+		md.err = fmt.Errorf("url responsed with code %d", resp.StatusCode)
+		if isThrottled(resp.StatusCode) {
+			md.retryAfter, _ = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
 		}
+		return md
+	}
+
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		md.page.LastModified = t
+	}
+	setPageRobots(md.page, pageRobotsDirectives(robotsAgent(f), nil, resp.Header["X-Robots-Tag"]))
+	canonicals := headerCanonicals(u, resp.Header["Link"])
+	for i, c := range canonicals {
+		canonicals[i] = f.extractor.normalizer.NormalizeString(c)
+	}
+	if len(canonicals) > 0 {
+		md.page.Canonical, md.page.Canonicals = canonicals[0], canonicals
 	}
 
 	ct := resp.Header.Get("Content-type")
 	if ct != "" && !strings.Contains(ct, "text/html") {
-		return &scraperDump{
-			depth:     depth + 1,
-			sourceURL: u,
-			err:       fmt.Errorf("unknown content type: %s", ct),
+		if f.checkAssets {
+			md.asset = &Asset{URL: u.String(), StatusCode: resp.StatusCode, ContentType: ct, Size: resp.ContentLength, Method: http.MethodGet}
+			return md
+		}
+		md.err = fmt.Errorf("unknown content type: %s", ct)
+		return md
+	}
+
+	body := &countingReader{r: resp.Body}
+	var r io.Reader = body
+	if f.maxBodyBytes > 0 {
+		r = io.LimitReader(body, f.maxBodyBytes)
+	}
+	hp := scrapeHTML(f.extractor, u, r)
+	md.page.Size = body.n
+	if f.maxBodyBytes > 0 && body.n >= f.maxBodyBytes {
+		// a byte left past the limit means the body was cut short
+		n, _ := body.r.Read(make([]byte, 1))
+		md.page.Truncated = n > 0
+	}
+	for _, c := range canonicals {
+		addLink(hp, f.extractor, u, LinkCanonical, c)
+	}
+	md.links, md.invalidURLs, md.schemeLinks = hp.links, hp.invalidURLs, hp.schemeLinks
+	setPageHTML(md.page, hp)
+	setPageRobots(md.page, pageRobotsDirectives(robotsAgent(f), hp.metas, resp.Header["X-Robots-Tag"]))
+	if !f.followNofollow {
+		dropNofollow(md.links, md.page.NoFollow)
+	}
+	md.urls = followedURLs(md.links)
+	return md
+}
+
+// fetchFunc makes a single attempt to fetch the url
+type fetchFunc func(ctx context.Context, f *fetcher, depth int, u *url.URL) *scraperDump
+
+// crawlURL crawls the url and extracts the urls from the page.
+// Failed attempts are retried as the fetcher retry policy allows
+func crawlURL(ctx context.Context, f *fetcher, depth int, u *url.URL) (md *scraperDump) {
+	return retryFetch(ctx, f, depth, u, fetchURL)
+}
+
+// retryFetch fetches the url with fetch, retrying failed attempts as the fetcher retry policy allows.
+// With a scheduler, it is called with the request slot of the url host taken and releases it once done
+func retryFetch(ctx context.Context, f *fetcher, depth int, u *url.URL, fetch fetchFunc) (md *scraperDump) {
+	if f.totalTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.totalTimeout)
+		defer cancel()
+	}
+
+	held := f.scheduler != nil
+	defer func() {
+		if held {
+			release(f.scheduler, u.Host)
+		}
+	}()
+
+	var errs []error
+	for attempt := 1; ; attempt++ {
+		md = fetch(ctx, f, depth, u)
+		if md.err != nil {
+			errs = append(errs, md.err)
+		}
+
+		// throttled responses are requeued by the caller, not retried
+		retry := md.err != nil && !isThrottled(md.statusCode) && attempt < f.retry.MaxAttempts &&
+			isRetryable(f.retry, retryErr(md), md.statusCode)
+		if retry {
+			retry = waitToRetry(ctx, f, u.Host, retryDelay(f.retry, attempt))
+			held = retry && f.scheduler != nil
+		}
+
+		if !retry {
+			md.page.Attempts = attempt
+			md.page.Errors = errs
+			return md
 		}
 	}
+}
+
+// waitToRetry waits out the delay before the next attempt at a url of the host. The request slot of
+// the host is given back meanwhile and taken again once the host is eligible, so the other urls of
+// the host aren't held up. It returns false with the slot given back when ctx is done first
+func waitToRetry(ctx context.Context, f *fetcher, host string, delay time.Duration) bool {
+	if f.scheduler == nil {
+		return sleepCtx(ctx, delay) == nil
+	}
 
-	s, iu := extractURLsFromHTML(u, resp.Body)
-	return &scraperDump{
-		depth:       depth + 1,
-		sourceURL:   u,
-		urls:        s,
-		invalidURLs: iu,
+	release(f.scheduler, host)
+	if sleepCtx(ctx, delay) != nil {
+		return false
 	}
+
+	return waitForSlot(ctx, f.scheduler, host) == nil
 }
 
-// crawlURLs crawls given urls and return extracted url from the page
-func crawlURLs(depth int, urls []*url.URL) (mds []*scraperDump) {
-	for _, u := range urls {
-		mds = append(mds, crawlURL(depth, u))
+// retryErr returns the transport error of the dump, nil when the server responded
+func retryErr(md *scraperDump) error {
+	if md.statusCode != 0 {
+		return nil
 	}
 
-	return mds
+	return md.err
 }
 
+// crawlItem crawls the url of the item and extracts the urls from the page, or checks it when it is
+// an asset, with the request slot of its host the delegator took. The slot is released once the url
+// is fetched. A url answered with 429 or 503 backs off its host and is requeued by the delegator
+func crawlItem(ctx context.Context, m *scraper, item *frontierItem) *scraperDump {
+	f := m.fetcher
+	crawl := crawlURL
+	if item.asset {
+		crawl = checkAsset
+	}
+
+	u := item.url
+	md := crawl(ctx, f, item.depth, u)
+	if isThrottled(md.statusCode) {
+		throttleHost(f.scheduler, u.Host, md.retryAfter, time.Now())
+		logf(m.logger, "%s throttled us, backing off %s\n", u, u.Host)
+	} else if md.err == nil {
+		recoverHost(f.scheduler, u.Host)
+	}
+
+	return md
+}
 
-// startScraper starts the scraper
+// startScraper starts the scraper, it pulls one url at a time till the work queue is closed
 func startScraper(ctx context.Context, m *scraper) {
-	log.Printf("Starting %s...\n", m.name)
+	logf(m.logger, "Starting %s...\n", m.name)
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case mp := <-m.payloadCh:
-			setBusy(m)
-			log.Printf("Crawling urls(%d) from depth %d\n", len(mp.urls), mp.currentDepth)
-			mds := crawlURLs(mp.currentDepth, mp.urls)
-			got := make(chan bool)
-			m.delegatorDumpCh <- &scraperDumps{
-				scraper: m.name,
-				got:    got,
-				mds:    mds,
+		case item, ok := <-m.workCh:
+			if !ok {
+				return
+			}
+
+			if item.asset {
+				logf(m.logger, "Checking asset %s\n", item.url)
+			} else {
+				logf(m.logger, "Crawling %s from depth %d\n", item.url, item.depth)
+			}
+			md := crawlItem(ctx, m, item)
+			select {
+			case <-ctx.Done():
+				return
+			case m.delegatorDumpCh <- &scraperDumps{scraper: m.name, item: item, mds: []*scraperDump{md}}:
 			}
-			<-got
-			setAvailable(m)
 		}
 	}
 }
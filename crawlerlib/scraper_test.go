@@ -1,8 +1,16 @@
 package crawlerlib
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func Test_crawlURL(t *testing.T) {
@@ -25,7 +33,8 @@ func Test_crawlURL(t *testing.T) {
 
 	for _, c := range tests {
 		u, _ := url.Parse(c.u)
-		md := crawlURL(c.depth, u)
+		f, _ := newFetcher(FetcherConfig{})
+		md := crawlURL(context.Background(), f, c.depth, u)
 		if md.err != nil && !c.error {
 			t.Fatalf("failed to crawl %s\n", u.String())
 		}
@@ -39,3 +48,114 @@ func Test_crawlURL(t *testing.T) {
 		}
 	}
 }
+
+func Test_crawlURLRetries(t *testing.T) {
+	var flaky int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/flaky":
+			if atomic.AddInt32(&flaky, 1) < 3 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+		case "/dead":
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		case "/missing":
+			http.NotFound(w, r)
+			return
+		case "/moved":
+			http.Redirect(w, r, "/", http.StatusMovedPermanently)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<a href="/page">page</a>`)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		path     string
+		attempts int
+		errors   int
+		redirect string
+		error    bool
+	}{
+		{path: "/", attempts: 1},
+		{path: "/flaky", attempts: 3, errors: 2},
+		{path: "/dead", attempts: 3, errors: 3, error: true},
+		{path: "/missing", attempts: 1, errors: 1, error: true},
+		{path: "/moved", attempts: 1, redirect: "/"},
+	}
+
+	f, _ := newFetcher(FetcherConfig{Client: ts.Client()})
+	f.retry = RetryPolicy{
+		MaxAttempts:   3,
+		BaseDelay:     time.Millisecond,
+		RetryStatuses: []int{http.StatusInternalServerError, http.StatusBadGateway},
+	}
+	for _, c := range tests {
+		u, _ := url.Parse(ts.URL + c.path)
+		md := crawlURL(context.Background(), f, 0, u)
+		if (md.err != nil) != c.error {
+			t.Fatalf("expected error %t for %s but got %v", c.error, c.path, md.err)
+		}
+
+		if md.page.Attempts != c.attempts || len(md.page.Errors) != c.errors {
+			t.Fatalf("expected %d attempts and %d errors for %s but got %d and %v",
+				c.attempts, c.errors, c.path, md.page.Attempts, md.page.Errors)
+		}
+
+		redirect := ""
+		if c.redirect != "" {
+			redirect = ts.URL + c.redirect
+		}
+
+		if md.page.RedirectTo != redirect {
+			t.Fatalf("expected %s to redirect to %q but got %q", c.path, redirect, md.page.RedirectTo)
+		}
+	}
+}
+
+func TestCrawler_RunRetriesFreeHost(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	var flaky int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.URL.Path)
+		mu.Unlock()
+
+		if r.URL.Path == "/flaky" && atomic.AddInt32(&flaky, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, `<a href="/flaky">flaky</a><a href="/b">b</a>`)
+		}
+	}))
+	defer ts.Close()
+
+	cr, err := New(WithIgnoreRobots(true), WithOutput(nil), WithConcurrency(2),
+		WithPoliteness(Politeness{Default: HostLimit{MaxInFlight: 1}}),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 2, BaseDelay: 300 * time.Millisecond, RetryStatuses: []int{http.StatusBadGateway}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := cr.Run(context.Background(), ts.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// /b takes the host slot while /flaky waits to be retried
+	if got := strings.Join(requests, " "); got != "/ /flaky /b /flaky" {
+		t.Fatalf("expected /b to be crawled before /flaky is retried but got %s", got)
+	}
+
+	if p := resp.Pages[ts.URL+"/flaky"]; p == nil || p.Attempts != 2 || p.StatusCode != http.StatusOK {
+		t.Fatalf("expected /flaky to be crawled on its second attempt but got %+v", p)
+	}
+}
@@ -0,0 +1,279 @@
+package crawlerlib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Site is a seed crawled along with the others of the crawl, with its own scope and limits.
+// Urls are deduplicated across sites and belong to the first site, in order, whose scope they are in
+type Site struct {
+	URL         string      // URL the site is crawled from
+	DomainRegex string      // DomainRegex restricts the site to matching hosts, defaults to the crawl domain regex or the seed host
+	Scope       []ScopeRule // Scope rules the urls of the site must pass after the crawl wide ones
+	MaxDepth    *int        // MaxDepth of the site, nil uses the crawl max depth, 0 crawls the seed only and -1 means no limit
+	MaxPages    int         // MaxPages is the budget of pages crawled for the site, 0 means no limit
+}
+
+// SiteResponse is the part of the crawl response belonging to one site
+type SiteResponse struct {
+	Site          Site                // Site as it was crawled, defaults filled in
+	Seed          string              // Seed is the normalized url the site was crawled from
+	UniqueURLs    map[string]int      // UniqueURLs of the site, noindex pages and duplicates left out
+	Pages         map[string]*Page    // Pages crawled for the site
+	ErrorURLs     map[string]error    // ErrorURLs of the site along with why they failed
+	SkippedURLs   map[string][]string // SkippedURLs found on the pages of the site
+	BudgetReached bool                // BudgetReached says if urls were skipped as the site ran out of pages
+}
+
+// ParseSite parses a site written as url[,domain=REGEX][,max-depth=N][,max-pages=N][,scope=RULE]...
+// e.g. `https://docs.example.com,max-pages=500,scope=exclude path /v1/*`. scope can be repeated
+func ParseSite(s string) (Site, error) {
+	var site Site
+	var rules []ScopeRule
+	parts := strings.Split(s, ",")
+	i := len(parts)
+	for ; i > 1; i-- {
+		kv := strings.SplitN(parts[i-1], "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || (key != "domain" && key != "max-depth" && key != "max-pages" && key != "scope") {
+			break
+		}
+
+		var err error
+		switch key {
+		case "domain":
+			site.DomainRegex = kv[1]
+		case "max-depth":
+			var d int
+			d, err = strconv.Atoi(kv[1])
+			site.MaxDepth = &d
+		case "max-pages":
+			site.MaxPages, err = strconv.Atoi(kv[1])
+		case "scope":
+			var r ScopeRule
+			r, err = ParseScopeRule(kv[1])
+			rules = append([]ScopeRule{r}, rules...)
+		}
+
+		if err != nil {
+			return site, fmt.Errorf("invalid site %q: %v", s, err)
+		}
+	}
+
+	site.URL = strings.TrimSpace(strings.Join(parts[:i], ","))
+	site.Scope = rules
+	if site.URL == "" {
+		return site, fmt.Errorf("invalid site %q: missing url", s)
+	}
+
+	return site, nil
+}
+
+// ReadSites reads the sites of a seed file, one per line as ParseSite takes them.
+// Blank lines and lines starting with # are ignored
+func ReadSites(r io.Reader) ([]Site, error) {
+	var sites []Site
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		site, err := ParseSite(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		sites = append(sites, site)
+	}
+
+	return sites, s.Err()
+}
+
+// site is a Site compiled for a run
+type site struct {
+	cfg         Site           // cfg is the site as crawled
+	seed        *url.URL       // seed the site is crawled from
+	domainRegex *regexp.Regexp // domainRegex restricts the site to matching hosts
+	scope       *scope         // scope holds the rules of the site, nil when there are none
+	queued      int            // queued is the number of urls of the site queued to be crawled
+	overBudget  bool           // overBudget says if urls were skipped as the budget ran out
+}
+
+// newSite compiles the site with the seed url. crawlRegex is used when the site has no domain regex
+// and the seed host when there is no crawl regex either
+func newSite(cfg Site, seed *url.URL, crawlRegex *regexp.Regexp) (*site, error) {
+	if cfg.MaxDepth != nil && *cfg.MaxDepth < -1 {
+		return nil, fmt.Errorf("max depth of site %s must be -1 for no limit or more, got %d", cfg.URL, *cfg.MaxDepth)
+	}
+
+	if cfg.MaxPages < 0 {
+		return nil, fmt.Errorf("max pages of site %s can't be negative, got %d", cfg.URL, cfg.MaxPages)
+	}
+
+	s := &site{cfg: cfg, seed: seed, domainRegex: crawlRegex}
+	switch {
+	case cfg.DomainRegex != "":
+		r, err := regexp.Compile(cfg.DomainRegex)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile domain regex of site %s: %v", cfg.URL, err)
+		}
+		s.domainRegex = r
+	case crawlRegex == nil:
+		s.domainRegex = seedHostsRegex([]*url.URL{seed})
+	}
+	s.cfg.DomainRegex = s.domainRegex.String()
+
+	sc, err := newScope(cfg.Scope)
+	if err != nil {
+		return nil, fmt.Errorf("site %s: %v", cfg.URL, err)
+	}
+	s.scope = sc
+
+	return s, nil
+}
+
+// sitesRegex returns a regex matching the hosts any of the sites matches
+func sitesRegex(sites []*site) *regexp.Regexp {
+	var res []string
+	for _, s := range sites {
+		res = appendUnique(res, s.domainRegex.String())
+	}
+
+	if len(res) == 1 {
+		return sites[0].domainRegex
+	}
+
+	return regexp.MustCompile("(?:" + strings.Join(res, ")|(?:") + ")")
+}
+
+// siteOf returns the first site whose scope the url is in, nil when there is none.
+// reason says which scope rule rejected the url when a site host matched
+func siteOf(g *delegator, u *url.URL) (*site, string) {
+	var reason string
+	for _, s := range g.sites {
+		if !s.domainRegex.MatchString(u.Hostname()) {
+			continue
+		}
+
+		ok, r := scopeAllows(s.scope, u)
+		if ok {
+			return s, ""
+		}
+
+		if reason == "" {
+			reason = r
+		}
+	}
+
+	return nil, reason
+}
+
+// seedSite returns the site crawled from the seed, the site whose scope it is in for sitemap seeds
+func seedSite(g *delegator, u *url.URL) *site {
+	for _, s := range g.sites {
+		if s.seed.String() == u.String() {
+			return s
+		}
+	}
+
+	s, _ := siteOf(g, u)
+	return s
+}
+
+// maxDepthOf returns the max depth of the url, the one of its site when set
+func maxDepthOf(g *delegator, u *url.URL) int {
+	s, ok := g.urlSites[u.String()]
+	if !ok {
+		s, _ = siteOf(g, u)
+	}
+
+	if s != nil && s.cfg.MaxDepth != nil {
+		return *s.cfg.MaxDepth
+	}
+
+	return g.maxDepth
+}
+
+// queueURL marks the url queued for its site, false when it was queued before or its site
+// ran out of budget. Urls skipped for the budget are added to the skipped urls of sourceURL
+func queueURL(g *delegator, sourceURL *url.URL, u *url.URL, s *site) bool {
+	key := u.String()
+	if g.queued[key] {
+		return false
+	}
+
+	if s != nil && s.cfg.MaxPages > 0 && s.queued >= s.cfg.MaxPages {
+		s.overBudget = true
+		g.skippedURLs[sourceURL.String()] = append(g.skippedURLs[sourceURL.String()], key)
+		g.skipReasons[key] = fmt.Sprintf("site %s reached its budget of %d pages", s.cfg.URL, s.cfg.MaxPages)
+		return false
+	}
+
+	g.queued[key] = true
+	if s != nil {
+		s.queued++
+		g.urlSites[key] = s
+	}
+
+	return true
+}
+
+// siteResponses splits the crawl response between the sites, in order
+func siteResponses(g *delegator, unique map[string]int) []*SiteResponse {
+	var srs []*SiteResponse
+	bySite := make(map[*site]*SiteResponse)
+	for _, s := range g.sites {
+		sr := &SiteResponse{
+			Site:          s.cfg,
+			Seed:          s.seed.String(),
+			UniqueURLs:    make(map[string]int),
+			Pages:         make(map[string]*Page),
+			ErrorURLs:     make(map[string]error),
+			SkippedURLs:   make(map[string][]string),
+			BudgetReached: s.overBudget,
+		}
+		bySite[s] = sr
+		srs = append(srs, sr)
+	}
+
+	for u, n := range unique {
+		if _, ok := g.urlSites[u]; ok {
+			continue
+		}
+
+		// urls past the max depth are unique but were never queued
+		if pu, err := url.Parse(u); err == nil {
+			if s, _ := siteOf(g, pu); s != nil {
+				bySite[s].UniqueURLs[u] = n
+			}
+		}
+	}
+
+	for u, s := range g.urlSites {
+		sr := bySite[s]
+		if p, ok := g.pages[u]; ok {
+			sr.Pages[u] = p
+		}
+
+		if err, ok := g.errorURLs[u]; ok {
+			sr.ErrorURLs[u] = err
+		}
+
+		if n, ok := unique[u]; ok {
+			sr.UniqueURLs[u] = n
+		}
+
+		if skipped, ok := g.skippedURLs[u]; ok {
+			sr.SkippedURLs[u] = skipped
+		}
+	}
+
+	return srs
+}
@@ -0,0 +1,216 @@
+package crawlerlib
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// depthOf returns a pointer to the max depth d of a site
+func depthOf(d int) *int {
+	return &d
+}
+
+func TestParseSite(t *testing.T) {
+	tests := []struct {
+		site     string
+		expected Site
+		err      bool
+	}{
+		{site: "https://a.com", expected: Site{URL: "https://a.com"}},
+		{
+			site: `https://a.com/?x=1,2,domain=(www\.)?a\.com,max-depth=2,max-pages=10,scope=exclude *.pdf,scope=include /docs/*`,
+			expected: Site{
+				URL:         "https://a.com/?x=1,2",
+				DomainRegex: `(www\.)?a\.com`,
+				MaxDepth:    depthOf(2),
+				MaxPages:    10,
+				Scope:       []ScopeRule{{Target: ScopePath, Pattern: "*.pdf"}, {Include: true, Target: ScopePath, Pattern: "/docs/*"}},
+			},
+		},
+		{site: "https://a.com,max-depth=0", expected: Site{URL: "https://a.com", MaxDepth: depthOf(0)}},
+		{site: "https://a.com,max-pages=x", err: true},
+		{site: "https://a.com,scope=keep /docs", err: true},
+		{site: ",max-depth=1", err: true},
+	}
+
+	for _, c := range tests {
+		s, err := ParseSite(c.site)
+		if c.err {
+			if err == nil {
+				t.Fatalf("expected %q to fail", c.site)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(s, c.expected) {
+			t.Fatalf("expected %q to parse as %+v but got %+v", c.site, c.expected, s)
+		}
+	}
+}
+
+func TestCrawler_RunSites(t *testing.T) {
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/a/":
+			fmt.Fprint(w, `<a href="/a/1">1</a><a href="/a/2">2</a><a href="/b/1">b1</a>`)
+		case "/a/1":
+			fmt.Fprint(w, `<a href="/a/1/deep">deep</a><a href="/b/1">b1</a><a href="/b/2">b2</a>`)
+		case "/b/":
+			fmt.Fprint(w, `<a href="/b/1">1</a><a href="/b/2">2</a><a href="/b/3">3</a><a href="/b/4">4</a>`)
+		default:
+			fmt.Fprint(w, `<a href="/a/">a</a>`)
+		}
+	}))
+	defer ts.Close()
+
+	cr, err := New(WithIgnoreRobots(true), WithOutput(nil), WithConcurrency(3), WithSites(
+		Site{URL: ts.URL + "/a/", MaxDepth: depthOf(2), Scope: []ScopeRule{{Include: true, Pattern: "/a/*"}}},
+		Site{URL: ts.URL + "/b/", MaxPages: 3, Scope: []ScopeRule{{Include: true, Pattern: "/b/*"}}},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := cr.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, n := range requests {
+		if n > 1 {
+			t.Fatalf("expected %s to be crawled once but it was crawled %d times", path, n)
+		}
+	}
+
+	if len(resp.Sites) != 2 {
+		t.Fatalf("expected 2 sites but got %d", len(resp.Sites))
+	}
+
+	crawled := func(sr *SiteResponse) (paths []string) {
+		for u := range sr.Pages {
+			paths = append(paths, strings.TrimPrefix(u, ts.URL))
+		}
+		sort.Strings(paths)
+		return paths
+	}
+
+	a, b := resp.Sites[0], resp.Sites[1]
+	if got := crawled(a); fmt.Sprint(got) != "[/a/ /a/1 /a/2]" {
+		t.Fatalf("expected site a to crawl /a/, /a/1 and /a/2 but got %v", got)
+	}
+
+	if got := crawled(b); len(got) != 3 || got[0] != "/b/" || !b.BudgetReached {
+		t.Fatalf("expected site b to crawl /b/ and 2 more pages within its budget but got %v", got)
+	}
+
+	if *a.Site.MaxDepth != 2 || *b.Site.MaxDepth != -1 || b.Site.DomainRegex != "^(?:127\\.0\\.0\\.1)$" {
+		t.Fatalf("expected the site defaults to be filled in but got %+v and %+v", a.Site, b.Site)
+	}
+
+	var budget int
+	for _, reason := range resp.SkipReasons {
+		if strings.Contains(reason, "reached its budget of 3 pages") {
+			budget++
+		}
+	}
+
+	if budget != 2 {
+		t.Fatalf("expected 2 urls to be skipped for the budget of site b but got %d", budget)
+	}
+}
+
+func TestCrawler_RunSingleSeedScope(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<a href="http://sub.127.0.0.1/">sub</a><a href="http://127.0.0.1.evil.org/">evil</a>`)
+	}))
+	defer ts.Close()
+
+	cr, err := New(WithIgnoreRobots(true), WithOutput(nil), WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := cr.Run(context.Background(), ts.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	skipped := resp.SkippedURLs[ts.URL+"/"]
+	sort.Strings(skipped)
+	if !reflect.DeepEqual(skipped, []string{"http://127.0.0.1.evil.org/", "http://sub.127.0.0.1/"}) || len(resp.Pages) != 1 {
+		t.Fatalf("expected the seed to keep to its host but got pages %v skipped %v", resp.Pages, skipped)
+	}
+
+	if resp.Sites[0].Site.DomainRegex != "^(?:127\\.0\\.0\\.1)$" {
+		t.Fatalf("expected the seed host regex but got %s", resp.Sites[0].Site.DomainRegex)
+	}
+}
+
+func TestCrawler_RunSeedOnlySite(t *testing.T) {
+	ts := testSite(map[string][]string{
+		"/a/":  {"/a/1"},
+		"/a/1": {"/a/2"},
+		"/b/":  {"/b/1"},
+		"/b/1": {"/b/2"},
+		"/b/2": {},
+		"/a/2": {},
+	})
+	defer ts.Close()
+
+	cr, err := New(WithIgnoreRobots(true), WithOutput(nil), WithMaxDepth(3), WithSites(
+		Site{URL: ts.URL + "/a/", MaxDepth: depthOf(0), Scope: []ScopeRule{{Include: true, Pattern: "/a/*"}}},
+		Site{URL: ts.URL + "/b/", Scope: []ScopeRule{{Include: true, Pattern: "/b/*"}}},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := cr.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crawled := func(sr *SiteResponse) (paths []string) {
+		for u := range sr.Pages {
+			paths = append(paths, strings.TrimPrefix(u, ts.URL))
+		}
+		sort.Strings(paths)
+		return paths
+	}
+
+	a, b := resp.Sites[0], resp.Sites[1]
+	if got := crawled(a); fmt.Sprint(got) != "[/a/]" {
+		t.Fatalf("expected site a to crawl its seed only but got %v", got)
+	}
+
+	if _, ok := a.UniqueURLs[ts.URL+"/a/1"]; !ok {
+		t.Fatalf("expected /a/1 to be found on the seed of site a but got %v", a.UniqueURLs)
+	}
+
+	if got := crawled(b); fmt.Sprint(got) != "[/b/ /b/1 /b/2]" {
+		t.Fatalf("expected site b to take the crawl max depth but got %v", got)
+	}
+
+	if *a.Site.MaxDepth != 0 || *b.Site.MaxDepth != 3 {
+		t.Fatalf("expected max depths 0 and 3 but got %d and %d", *a.Site.MaxDepth, *b.Site.MaxDepth)
+	}
+}
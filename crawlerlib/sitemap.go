@@ -1,55 +1,570 @@
 package crawlerlib
 
 import (
-	"os"
 	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxSitemapURLs is the most urls a sitemap file may hold
+	MaxSitemapURLs = 50000
+	// MaxSitemapBytes is the most bytes a sitemap file may hold before compression
+	MaxSitemapBytes = 50 * 1024 * 1024
 )
 
-func escapeIncompatibleCharacters(inpString string)string{
-	bytesString := []byte(inpString)
-	formattedBytes := bytes.Replace(bytesString, []byte("&"), []byte("amp;"), -1)
-	return string(formattedBytes)
+const (
+	sitemapHeader      = "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<urlset xmlns=\"http://www.sitemaps.org/schemas/sitemap/0.9\">\n"
+	sitemapFooter      = "</urlset>\n"
+	sitemapIndexHeader = "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<sitemapindex xmlns=\"http://www.sitemaps.org/schemas/sitemap/0.9\">\n"
+	sitemapIndexFooter = "</sitemapindex>\n"
+)
+
+// sitemap extension namespaces, declared on the urlset when its entries use them
+const (
+	sitemapImageNS = "http://www.google.com/schemas/sitemap-image/1.1"
+	sitemapVideoNS = "http://www.google.com/schemas/sitemap-video/1.1"
+	sitemapXHTMLNS = "http://www.w3.org/1999/xhtml"
+)
 
+// maxSitemapImages is the most images listed for a single url
+const maxSitemapImages = 1000
+
+// maxVideoDescription is the most characters a video description may have
+const maxVideoDescription = 2048
+
+// SitemapEntry is a url listed in a sitemap
+type SitemapEntry struct {
+	Loc        string          // Loc is the absolute url of the page
+	LastMod    time.Time       // LastMod is when the page was last modified, left out when zero
+	ChangeFreq string          // ChangeFreq is how often the page is likely to change, left out when empty
+	Priority   float64         // Priority of the page relative to the others on the site from 0.0 to 1.0, left out when 0
+	Images     []string        // Images are the urls of the images on the page, written with the image extension
+	Videos     []SitemapVideo  // Videos on the page, written with the video extension
+	Alternates []PageAlternate // Alternates are the language versions of the page, written as xhtml:link
+}
+
+// SitemapVideo is a video listed with the video sitemap extension
+type SitemapVideo struct {
+	ThumbnailLoc string // ThumbnailLoc is the url of the video thumbnail
+	Title        string // Title of the video
+	Description  string // Description of the video, cut to 2048 characters
+	ContentLoc   string // ContentLoc is the url of the video file
 }
 
-// generateSiteMap will write the crawled url to given file
-func generateSiteMap(fileName string, urls map[string]int) error {
-	err := deleteFileIfExists(fileName)
+// PriorityFunc returns the sitemap priority of a url from the depth it was found at
+// and the number of pages linking to it. maxInbound is the most any url of the crawl got
+type PriorityFunc func(depth, inbound, maxInbound int) float64
+
+// DepthPriority gives the seeds 1.0 and takes 0.2 off for every level deeper, down to 0.1
+func DepthPriority(depth, inbound, maxInbound int) float64 {
+	return math.Max(0.1, 1-0.2*float64(depth))
+}
+
+// InboundPriority scales the priority from 0.1 to 1.0 by the pages linking to the url
+func InboundPriority(depth, inbound, maxInbound int) float64 {
+	if maxInbound == 0 {
+		return 0.5
+	}
+
+	return 0.1 + 0.9*float64(inbound)/float64(maxInbound)
+}
+
+// flatPriority gives every url 0.5, the priority Sitemap has always written
+func flatPriority(depth, inbound, maxInbound int) float64 {
+	return 0.5
+}
+
+// ChangeFreqRule sets the changefreq of the urls matching Pattern
+type ChangeFreqRule struct {
+	Pattern    string // Pattern is a regex matched against the url
+	ChangeFreq string // ChangeFreq is one of always, hourly, daily, weekly, monthly, yearly or never
+}
+
+// changeFreqs are the changefreq values the sitemap protocol allows
+var changeFreqs = map[string]bool{
+	"always": true, "hourly": true, "daily": true, "weekly": true, "monthly": true, "yearly": true, "never": true,
+}
+
+// ParseChangeFreqRule parses a changefreq rule written as pattern=changefreq, e.g. `/blog/=daily`
+func ParseChangeFreqRule(s string) (ChangeFreqRule, error) {
+	i := strings.LastIndex(s, "=")
+	if i < 1 {
+		return ChangeFreqRule{}, fmt.Errorf("invalid changefreq rule %q, expected pattern=changefreq", s)
+	}
+
+	return ChangeFreqRule{Pattern: s[:i], ChangeFreq: strings.TrimSpace(s[i+1:])}, nil
+}
+
+// SitemapOptions controls how sitemaps are generated, split and written
+type SitemapOptions struct {
+	Gzip     bool   // Gzip compresses every file written and adds .gz to their names
+	BaseURL  string // BaseURL is where the sitemap files are served from, the index lists the parts under it
+	MaxURLs  int    // MaxURLs per file, defaults to and may not exceed MaxSitemapURLs
+	MaxBytes int    // MaxBytes per file before compression, defaults to and may not exceed MaxSitemapBytes
+
+	// the below apply when generating the sitemap of a crawl
+	Priority    PriorityFunc     // Priority of the crawled urls, nil uses DepthPriority
+	ChangeFreq  string           // ChangeFreq of the urls matching none of ChangeFreqs, empty leaves it out
+	ChangeFreqs []ChangeFreqRule // ChangeFreqs set the changefreq by url pattern, first match wins
+	Images      bool             // Images lists the images of every page with the image extension
+	Videos      bool             // Videos lists the videos of every page with the video extension, videos need a poster
+	Alternates  bool             // Alternates lists the hreflang alternates of every page as xhtml:link
+}
+
+// sitemapEscaper escapes the characters the sitemap protocol asks to be entity escaped
+var sitemapEscaper = strings.NewReplacer(
+	"&", "&amp;",
+	"'", "&apos;",
+	`"`, "&quot;",
+	">", "&gt;",
+	"<", "&lt;",
+)
+
+// escapeIncompatibleCharacters escapes the string for use in a sitemap
+func escapeIncompatibleCharacters(inpString string) string {
+	return sitemapEscaper.Replace(inpString)
+}
+
+// sitemapURL returns the <url> element of the entry
+func sitemapURL(e SitemapEntry) []byte {
+	var b bytes.Buffer
+	b.WriteString("  <url>\n")
+	b.WriteString("    <loc>" + escapeIncompatibleCharacters(e.Loc) + "</loc>\n")
+	if !e.LastMod.IsZero() {
+		b.WriteString("    <lastmod>" + e.LastMod.UTC().Format(time.RFC3339) + "</lastmod>\n")
+	}
+	if e.ChangeFreq != "" {
+		b.WriteString("    <changefreq>" + e.ChangeFreq + "</changefreq>\n")
+	}
+	if e.Priority > 0 {
+		b.WriteString("    <priority>" + strconv.FormatFloat(e.Priority, 'f', 1, 64) + "</priority>\n")
+	}
+	for i, img := range e.Images {
+		if i == maxSitemapImages {
+			break
+		}
+		b.WriteString("    <image:image>\n")
+		b.WriteString("      <image:loc>" + escapeIncompatibleCharacters(img) + "</image:loc>\n")
+		b.WriteString("    </image:image>\n")
+	}
+	for _, v := range e.Videos {
+		desc := v.Description
+		if r := []rune(desc); len(r) > maxVideoDescription {
+			desc = string(r[:maxVideoDescription])
+		}
+		b.WriteString("    <video:video>\n")
+		b.WriteString("      <video:thumbnail_loc>" + escapeIncompatibleCharacters(v.ThumbnailLoc) + "</video:thumbnail_loc>\n")
+		b.WriteString("      <video:title>" + escapeIncompatibleCharacters(v.Title) + "</video:title>\n")
+		b.WriteString("      <video:description>" + escapeIncompatibleCharacters(desc) + "</video:description>\n")
+		b.WriteString("      <video:content_loc>" + escapeIncompatibleCharacters(v.ContentLoc) + "</video:content_loc>\n")
+		b.WriteString("    </video:video>\n")
+	}
+	for _, a := range e.Alternates {
+		b.WriteString("    <xhtml:link rel=\"alternate\" hreflang=\"" + escapeIncompatibleCharacters(a.HrefLang) +
+			"\" href=\"" + escapeIncompatibleCharacters(a.URL) + "\"/>\n")
+	}
+	b.WriteString("  </url>\n")
+	return b.Bytes()
+}
+
+// urlsetHeader returns the sitemap header declaring the extension namespaces the entries use
+func urlsetHeader(entries []SitemapEntry) string {
+	var images, videos, alternates bool
+	for _, e := range entries {
+		images = images || len(e.Images) > 0
+		videos = videos || len(e.Videos) > 0
+		alternates = alternates || len(e.Alternates) > 0
+	}
+
+	if !images && !videos && !alternates {
+		return sitemapHeader
+	}
+
+	ns := ""
+	if images {
+		ns += "\n        xmlns:image=\"" + sitemapImageNS + "\""
+	}
+	if videos {
+		ns += "\n        xmlns:video=\"" + sitemapVideoNS + "\""
+	}
+	if alternates {
+		ns += "\n        xmlns:xhtml=\"" + sitemapXHTMLNS + "\""
+	}
+
+	return strings.Replace(sitemapHeader, `0.9">`, `0.9"`+ns+">", 1)
+}
+
+// sitemapLimits returns the url and byte limits of a sitemap file for the options
+func sitemapLimits(opts SitemapOptions) (maxURLs, maxBytes int, err error) {
+	maxURLs, maxBytes = opts.MaxURLs, opts.MaxBytes
+	if maxURLs == 0 {
+		maxURLs = MaxSitemapURLs
+	}
+	if maxBytes == 0 {
+		maxBytes = MaxSitemapBytes
+	}
+
+	if maxURLs < 1 || maxURLs > MaxSitemapURLs {
+		return 0, 0, fmt.Errorf("max urls per sitemap must be between 1 and %d, got %d", MaxSitemapURLs, maxURLs)
+	}
+
+	if maxBytes < 1 || maxBytes > MaxSitemapBytes {
+		return 0, 0, fmt.Errorf("max bytes per sitemap must be between 1 and %d, got %d", MaxSitemapBytes, maxBytes)
+	}
+
+	return maxURLs, maxBytes, nil
+}
+
+// splitSitemap sorts the entries by loc and renders them into as few parts as the limits allow.
+// header is the urlset header every part starts with
+func splitSitemap(entries []SitemapEntry, maxURLs, maxBytes int) (header string, parts [][][]byte, err error) {
+	sorted := make([]SitemapEntry, len(entries))
+	copy(sorted, entries)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Loc < sorted[j].Loc })
+
+	header = urlsetHeader(sorted)
+	parts = [][][]byte{nil}
+	size := len(header) + len(sitemapFooter)
+	for _, e := range sorted {
+		u := sitemapURL(e)
+		if len(u)+len(header)+len(sitemapFooter) > maxBytes {
+			return "", nil, fmt.Errorf("sitemap entry for %s is larger than %d bytes", e.Loc, maxBytes)
+		}
+
+		last := len(parts) - 1
+		if len(parts[last]) == maxURLs || size+len(u) > maxBytes {
+			parts = append(parts, nil)
+			last++
+			size = len(header) + len(sitemapFooter)
+		}
+
+		parts[last] = append(parts[last], u)
+		size += len(u)
+	}
+
+	return header, parts, nil
+}
+
+// writeSitemapPart writes a urlset of the rendered urls to w
+func writeSitemapPart(w io.Writer, header string, urls [][]byte) error {
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
+	}
+
+	for _, u := range urls {
+		if _, err := w.Write(u); err != nil {
+			return err
+		}
+	}
+
+	_, err := io.WriteString(w, sitemapFooter)
+	return err
+}
+
+// WriteSitemap writes the entries to w as a single sitemap sorted by loc.
+// It fails when the entries don't fit the limits of one sitemap file, see WriteSitemaps
+func WriteSitemap(w io.Writer, entries []SitemapEntry) error {
+	header, parts, err := splitSitemap(entries, MaxSitemapURLs, MaxSitemapBytes)
 	if err != nil {
 		return err
 	}
 
-	fh, err := os.OpenFile(fileName, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if len(parts) > 1 {
+		return fmt.Errorf("%d urls don't fit in a single sitemap", len(entries))
+	}
+
+	return writeSitemapPart(w, header, parts[0])
+}
+
+// WriteSitemapIndex writes a sitemap index listing the sitemap urls to w
+func WriteSitemapIndex(w io.Writer, locs []string) error {
+	if len(locs) > MaxSitemapURLs {
+		return fmt.Errorf("a sitemap index can list at most %d sitemaps, got %d", MaxSitemapURLs, len(locs))
+	}
+
+	if _, err := io.WriteString(w, sitemapIndexHeader); err != nil {
+		return err
+	}
+
+	for _, loc := range locs {
+		s := "  <sitemap>\n    <loc>" + escapeIncompatibleCharacters(loc) + "</loc>\n  </sitemap>\n"
+		if _, err := io.WriteString(w, s); err != nil {
+			return err
+		}
+	}
+
+	_, err := io.WriteString(w, sitemapIndexFooter)
+	return err
+}
+
+// sitemapPartName returns the name of the nth part of a split sitemap, e.g. sitemap-1.xml for sitemap.xml
+func sitemapPartName(name string, n int) string {
+	base := strings.TrimSuffix(name, ".gz")
+	ext := filepath.Ext(base)
+	part := fmt.Sprintf("%s-%d%s", strings.TrimSuffix(base, ext), n, ext)
+	if base != name {
+		part += ".gz"
+	}
+
+	return part
+}
+
+// writeSitemapFile creates the named file with create and writes to it, compressing when asked
+func writeSitemapFile(name string, gz bool, create func(name string) (io.WriteCloser, error), write func(w io.Writer) error) error {
+	wc, err := create(name)
 	if err != nil {
 		return err
 	}
-	defer fh.Close()
 
-	fh.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
-	fh.WriteString("<urlset xmlns=\"http://www.sitemaps.org/schemas/sitemap/0.9\">\n")
-	for loc := range urls {
-		locValue := escapeIncompatibleCharacters(loc)
-		fh.WriteString("    " + "<url>\n")
-		fh.WriteString("      " + "<loc>" + locValue + "</loc>\n")
-		fh.WriteString("      " + "<changefreq>weekly</changefreq>\n")
-		fh.WriteString("      " + "<priority>0.5</priority>\n")
-		fh.WriteString("    " + "</url>\n")
+	var w io.Writer = wc
+	var zw *gzip.Writer
+	if gz {
+		zw = gzip.NewWriter(wc)
+		w = zw
+	}
+
+	err = write(w)
+	if err == nil && zw != nil {
+		err = zw.Close()
 	}
-	fh.WriteString("</urlset> ")
 
-	return nil
+	if cerr := wc.Close(); err == nil {
+		err = cerr
+	}
 
+	return err
 }
 
-//deleteFileIfExists deletes a file if exists
-func deleteFileIfExists(fileName string) error {
-	//delete old file first
-	if _, err := os.Stat(fileName); err == nil {
-		err := os.Remove(fileName)
+// WriteSitemaps writes the entries as the named sitemap, create opens a file for writing.
+// When the entries exceed the limits of a file they are split into name-1, name-2... parts
+// and name becomes the sitemap index of the parts. It returns the names of the files written
+func WriteSitemaps(name string, entries []SitemapEntry, opts SitemapOptions, create func(name string) (io.WriteCloser, error)) ([]string, error) {
+	maxURLs, maxBytes, err := sitemapLimits(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Gzip && !strings.HasSuffix(name, ".gz") {
+		name += ".gz"
+	}
+
+	header, parts, err := splitSitemap(entries, maxURLs, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(parts) == 1 {
+		err := writeSitemapFile(name, opts.Gzip, create, func(w io.Writer) error {
+			return writeSitemapPart(w, header, parts[0])
+		})
 		if err != nil {
-			return err
+			return nil, err
+		}
+
+		return []string{name}, nil
+	}
+
+	if opts.BaseURL == "" {
+		return nil, fmt.Errorf("%d urls need a sitemap index, which needs the base url the parts are served from", len(entries))
+	}
+
+	var files, locs []string
+	for i, p := range parts {
+		partName := sitemapPartName(name, i+1)
+		err := writeSitemapFile(partName, opts.Gzip, create, func(w io.Writer) error {
+			return writeSitemapPart(w, header, p)
+		})
+		if err != nil {
+			return files, err
+		}
+
+		files = append(files, partName)
+		locs = append(locs, strings.TrimSuffix(opts.BaseURL, "/")+"/"+filepath.Base(partName))
+	}
+
+	err = writeSitemapFile(name, opts.Gzip, create, func(w io.Writer) error {
+		return WriteSitemapIndex(w, locs)
+	})
+	if err != nil {
+		return files, err
+	}
+
+	return append([]string{name}, files...), nil
+}
+
+// changeFreqRule is a compiled ChangeFreqRule
+type changeFreqRule struct {
+	re         *regexp.Regexp
+	changeFreq string
+}
+
+// compileChangeFreqs compiles the changefreq rules of the options
+func compileChangeFreqs(opts SitemapOptions) ([]*changeFreqRule, error) {
+	if opts.ChangeFreq != "" && !changeFreqs[opts.ChangeFreq] {
+		return nil, fmt.Errorf("invalid changefreq %q", opts.ChangeFreq)
+	}
+
+	var rules []*changeFreqRule
+	for _, r := range opts.ChangeFreqs {
+		if !changeFreqs[r.ChangeFreq] {
+			return nil, fmt.Errorf("invalid changefreq %q for %s", r.ChangeFreq, r.Pattern)
+		}
+
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile changefreq pattern: %v", err)
+		}
+
+		rules = append(rules, &changeFreqRule{re: re, changeFreq: r.ChangeFreq})
+	}
+
+	return rules, nil
+}
+
+// inboundCounts returns the number of other pages linking to each url
+func inboundCounts(resp *Response) (counts map[string]int, max int) {
+	counts = make(map[string]int)
+	if resp.Links == nil {
+		return counts, 0
+	}
+
+	for u := range resp.Links.inbound {
+		for _, ref := range resp.Links.Referrers(u) {
+			if ref != u {
+				counts[u]++
+			}
+		}
+
+		if counts[u] > max {
+			max = counts[u]
+		}
+	}
+
+	return counts, max
+}
+
+// sitemapEntries returns the sitemap entries of the crawled urls. urls that failed,
+// ask not to be indexed or declare another url canonical are left out
+func sitemapEntries(resp *Response, opts SitemapOptions) ([]SitemapEntry, error) {
+	rules, err := compileChangeFreqs(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	priority := opts.Priority
+	if priority == nil {
+		priority = DepthPriority
+	}
+
+	depths := urlDepths(resp)
+	inbound, maxInbound := inboundCounts(resp)
+	var entries []SitemapEntry
+	for loc := range resp.UniqueURLs {
+		if !sitemapEligible(resp, loc) {
+			continue
+		}
+
+		e := SitemapEntry{Loc: loc, ChangeFreq: opts.ChangeFreq}
+		if p, ok := resp.Pages[loc]; ok {
+			e.LastMod = p.LastModified
+			addSitemapMedia(&e, p, opts)
+		}
+
+		for _, r := range rules {
+			if r.re.MatchString(loc) {
+				e.ChangeFreq = r.changeFreq
+				break
+			}
+		}
+
+		e.Priority = math.Min(1, math.Max(0, priority(depths[loc], inbound[loc], maxInbound)))
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// sitemapEligible says if the crawled url belongs in the sitemap, i.e. it didn't fail or redirect
+// and the page doesn't ask not to be indexed or declare another url canonical
+func sitemapEligible(resp *Response, loc string) bool {
+	if _, failed := resp.ErrorURLs[loc]; failed {
+		return false
+	}
+
+	p, ok := resp.Pages[loc]
+	if !ok {
+		return true
+	}
+
+	return p.RedirectTo == "" && !p.NoIndex && (p.Canonical == "" || p.Canonical == loc)
+}
+
+// addSitemapMedia adds the media and alternates of the page the options ask for to the entry
+func addSitemapMedia(e *SitemapEntry, p *Page, opts SitemapOptions) {
+	if opts.Images {
+		for _, img := range p.Images {
+			e.Images = append(e.Images, img.URL)
+		}
+	}
+
+	if opts.Alternates {
+		e.Alternates = p.Alternates
+	}
+
+	if !opts.Videos {
+		return
+	}
+
+	for _, v := range p.Videos {
+		sv := SitemapVideo{ThumbnailLoc: v.Thumbnail, Title: v.Title, Description: p.Description, ContentLoc: v.URL}
+		if sv.Title == "" {
+			sv.Title = p.Title
+		}
+		if sv.Description == "" {
+			sv.Description = sv.Title
+		}
+
+		// thumbnail, title and description are required by the video extension
+		if sv.ThumbnailLoc == "" || sv.Title == "" {
+			continue
 		}
-		return nil
+
+		e.Videos = append(e.Videos, sv)
+	}
+}
+
+// generateSiteMap will write the sitemap of the crawled urls to given file, split into parts
+// next to it when too large. The index lists the parts under the base url of the crawl by default
+func generateSiteMap(fileName string, resp *Response, opts SitemapOptions) ([]string, error) {
+	if opts.BaseURL == "" && resp.BaseURL != nil {
+		opts.BaseURL = (&url.URL{Scheme: resp.BaseURL.Scheme, Host: resp.BaseURL.Host}).String()
+	}
+
+	entries, err := sitemapEntries(resp, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(fileName)
+	names, err := WriteSitemaps(filepath.Base(fileName), entries, opts, func(name string) (io.WriteCloser, error) {
+		return os.Create(filepath.Join(dir, name))
+	})
+
+	var files []string
+	for _, name := range names {
+		files = append(files, filepath.Join(dir, name))
 	}
-	return nil
+
+	return files, err
 }
@@ -0,0 +1,355 @@
+package crawlerlib
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// nopCloser adds a no op Close to a buffer
+type nopCloser struct {
+	*bytes.Buffer
+}
+
+// Close does nothing
+func (nopCloser) Close() error { return nil }
+
+// failWriter fails every write
+type failWriter struct{}
+
+// Write fails
+func (failWriter) Write(p []byte) (int, error) { return 0, errors.New("disk full") }
+
+func Test_escapeIncompatibleCharacters(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected string
+	}{
+		{in: "http://t.com/a?b=1&c=2", expected: "http://t.com/a?b=1&amp;c=2"},
+		{in: `http://t.com/'q'"<>`, expected: "http://t.com/&apos;q&apos;&quot;&lt;&gt;"},
+		{in: "http://t.com/&amp;", expected: "http://t.com/&amp;amp;"},
+	}
+
+	for _, c := range tests {
+		if got := escapeIncompatibleCharacters(c.in); got != c.expected {
+			t.Fatalf("expected %s but got %s", c.expected, got)
+		}
+	}
+}
+
+func Test_WriteSitemap(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteSitemap(&buf, []SitemapEntry{
+		{Loc: "http://t.com/b?x=1&y=2", ChangeFreq: "weekly", Priority: 0.5},
+		{Loc: "http://t.com/a"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url>
+    <loc>http://t.com/a</loc>
+  </url>
+  <url>
+    <loc>http://t.com/b?x=1&amp;y=2</loc>
+    <changefreq>weekly</changefreq>
+    <priority>0.5</priority>
+  </url>
+</urlset>
+`
+	if buf.String() != expected {
+		t.Fatalf("expected\n%s\nbut got\n%s", expected, buf.String())
+	}
+
+	if err := WriteSitemap(failWriter{}, nil); err == nil {
+		t.Fatal("expected the write error to be returned")
+	}
+}
+
+func Test_WriteSitemaps(t *testing.T) {
+	var entries []SitemapEntry
+	for _, p := range []string{"e", "d", "c", "b", "a"} {
+		entries = append(entries, SitemapEntry{Loc: "http://t.com/" + p})
+	}
+
+	tests := []struct {
+		name     string
+		opts     SitemapOptions
+		files    []string
+		contains map[string][]string
+		error    bool
+	}{
+		{
+			name:     "sitemap.xml",
+			files:    []string{"sitemap.xml"},
+			contains: map[string][]string{"sitemap.xml": {"<loc>http://t.com/a</loc>", "<loc>http://t.com/e</loc>"}},
+		},
+		{
+			name:  "sitemap.xml",
+			opts:  SitemapOptions{MaxURLs: 2, BaseURL: "http://t.com/"},
+			files: []string{"sitemap.xml", "sitemap-1.xml", "sitemap-2.xml", "sitemap-3.xml"},
+			contains: map[string][]string{
+				"sitemap.xml":   {"<sitemapindex", "<loc>http://t.com/sitemap-1.xml</loc>", "<loc>http://t.com/sitemap-3.xml</loc>"},
+				"sitemap-1.xml": {"<loc>http://t.com/a</loc>", "<loc>http://t.com/b</loc>"},
+				"sitemap-3.xml": {"<loc>http://t.com/e</loc>"},
+			},
+		},
+		{
+			name:  "sitemap.xml",
+			opts:  SitemapOptions{MaxBytes: len(sitemapHeader) + len(sitemapFooter) + 100, BaseURL: "http://t.com"},
+			files: []string{"sitemap.xml", "sitemap-1.xml", "sitemap-2.xml", "sitemap-3.xml"},
+		},
+		{
+			name:     "sitemap.xml",
+			opts:     SitemapOptions{MaxURLs: 3, Gzip: true, BaseURL: "http://t.com"},
+			files:    []string{"sitemap.xml.gz", "sitemap-1.xml.gz", "sitemap-2.xml.gz"},
+			contains: map[string][]string{"sitemap.xml.gz": {"<loc>http://t.com/sitemap-2.xml.gz</loc>"}},
+		},
+		{name: "sitemap.xml", opts: SitemapOptions{MaxURLs: 2}, error: true},
+		{name: "sitemap.xml", opts: SitemapOptions{MaxURLs: MaxSitemapURLs + 1}, error: true},
+		{name: "sitemap.xml", opts: SitemapOptions{MaxBytes: 10}, error: true},
+	}
+
+	for i, c := range tests {
+		written := make(map[string]*bytes.Buffer)
+		files, err := WriteSitemaps(c.name, entries, c.opts, func(name string) (io.WriteCloser, error) {
+			written[name] = &bytes.Buffer{}
+			return nopCloser{written[name]}, nil
+		})
+		if (err != nil) != c.error {
+			t.Fatalf("case %d: expected error %t but got %v", i, c.error, err)
+		}
+
+		if !reflect.DeepEqual(files, c.files) {
+			t.Fatalf("case %d: expected files %v but got %v", i, c.files, files)
+		}
+
+		for name, subs := range c.contains {
+			var r io.Reader = written[name]
+			if c.opts.Gzip {
+				zr, err := gzip.NewReader(r)
+				if err != nil {
+					t.Fatalf("case %d: %s is not gzipped: %v", i, name, err)
+				}
+				r = zr
+			}
+
+			b, _ := ioutil.ReadAll(r)
+			for _, s := range subs {
+				if !strings.Contains(string(b), s) {
+					t.Fatalf("case %d: expected %s to contain %s but got\n%s", i, name, s, b)
+				}
+			}
+		}
+	}
+}
+
+func Test_generateSiteMap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	base, _ := url.Parse("http://t.com/")
+	resp := &Response{
+		BaseURL:    base,
+		UniqueURLs: map[string]int{"http://t.com/": 1, "http://t.com/a": 2, "http://t.com/missing": 1},
+		ErrorURLs:  map[string]error{"http://t.com/missing": errors.New("url responsed with code 404")},
+	}
+
+	file := filepath.Join(dir, "sitemap.xml")
+	for i := 0; i < 2; i++ {
+		files, err := generateSiteMap(file, resp, SitemapOptions{})
+		if err != nil || !reflect.DeepEqual(files, []string{file}) {
+			t.Fatalf("expected %s to be written but got %v %v", file, files, err)
+		}
+	}
+
+	b, _ := ioutil.ReadFile(file)
+	if strings.Count(string(b), "<url>") != 2 || strings.Contains(string(b), "missing") {
+		t.Fatalf("expected the 2 crawled urls in the sitemap but got\n%s", b)
+	}
+}
+
+func Test_Sitemap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	resp := &Response{
+		UniqueURLs: map[string]int{"http://t.com/": 1, "http://t.com/a": 1},
+		Pages:      map[string]*Page{"http://t.com/": {Depth: 0}, "http://t.com/a": {Depth: 2}},
+	}
+
+	file := filepath.Join(dir, "sitemap.xml")
+	if err := Sitemap(resp, file); err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := ioutil.ReadFile(file)
+	if strings.Count(string(b), "<changefreq>weekly</changefreq>") != 2 || strings.Count(string(b), "<priority>0.5</priority>") != 2 {
+		t.Fatalf("expected every url weekly with priority 0.5 but got\n%s", b)
+	}
+}
+
+func Test_sitemapEntries(t *testing.T) {
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	lg := newLinkGraph()
+	for _, e := range []*LinkEdge{
+		{Source: "http://t.com/", Target: "http://t.com/blog/a"},
+		{Source: "http://t.com/", Target: "http://t.com/about"},
+		{Source: "http://t.com/about", Target: "http://t.com/blog/a"},
+		{Source: "http://t.com/blog/a", Target: "http://t.com/blog/a"},
+	} {
+		addEdge(lg, e)
+	}
+
+	resp := &Response{
+		UniqueURLs: map[string]int{
+			"http://t.com/": 1, "http://t.com/about": 1, "http://t.com/blog/a": 1,
+			"http://t.com/private": 1, "http://t.com/dup": 1,
+		},
+		Pages: map[string]*Page{
+			"http://t.com/":        {Depth: 0, LastModified: modified},
+			"http://t.com/about":   {Depth: 1},
+			"http://t.com/blog/a":  {Depth: 1, Canonical: "http://t.com/blog/a"},
+			"http://t.com/private": {Depth: 1, NoIndex: true},
+			"http://t.com/dup":     {Depth: 1, Canonical: "http://t.com/about"},
+		},
+		Links: lg,
+	}
+
+	tests := []struct {
+		opts     SitemapOptions
+		expected []SitemapEntry
+		error    bool
+	}{
+		{
+			expected: []SitemapEntry{
+				{Loc: "http://t.com/", LastMod: modified, Priority: 1},
+				{Loc: "http://t.com/about", Priority: 0.8},
+				{Loc: "http://t.com/blog/a", Priority: 0.8},
+			},
+		},
+		{
+			opts: SitemapOptions{
+				Priority:    InboundPriority,
+				ChangeFreq:  "monthly",
+				ChangeFreqs: []ChangeFreqRule{{Pattern: `/blog/`, ChangeFreq: "daily"}},
+			},
+			expected: []SitemapEntry{
+				{Loc: "http://t.com/", LastMod: modified, ChangeFreq: "monthly", Priority: 0.1},
+				{Loc: "http://t.com/about", ChangeFreq: "monthly", Priority: 0.55},
+				{Loc: "http://t.com/blog/a", ChangeFreq: "daily", Priority: 1},
+			},
+		},
+		{opts: SitemapOptions{ChangeFreq: "sometimes"}, error: true},
+		{opts: SitemapOptions{ChangeFreqs: []ChangeFreqRule{{Pattern: `(`, ChangeFreq: "daily"}}}, error: true},
+	}
+
+	for i, c := range tests {
+		entries, err := sitemapEntries(resp, c.opts)
+		if (err != nil) != c.error {
+			t.Fatalf("case %d: expected error %t but got %v", i, c.error, err)
+		}
+
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Loc < entries[j].Loc })
+		if !reflect.DeepEqual(entries, c.expected) {
+			t.Fatalf("case %d: expected %+v but got %+v", i, c.expected, entries)
+		}
+	}
+
+	var buf bytes.Buffer
+	WriteSitemap(&buf, []SitemapEntry{{Loc: "http://t.com/", LastMod: modified}})
+	if !strings.Contains(buf.String(), "<lastmod>2020-01-02T03:04:05Z</lastmod>") {
+		t.Fatalf("expected lastmod in the sitemap but got\n%s", buf.String())
+	}
+}
+
+func Test_sitemapExtensions(t *testing.T) {
+	page := &Page{
+		Title:       "Page & more",
+		Description: "About the page",
+		Images:      []PageImage{{URL: "http://t.com/a.png"}},
+		Videos: []PageVideo{
+			{URL: "http://t.com/intro.mp4", Thumbnail: "http://t.com/thumb.jpg"},
+			{URL: "http://t.com/nothumb.mp4"},
+		},
+		Alternates: []PageAlternate{{HrefLang: "de", URL: "http://t.com/de/"}},
+	}
+	resp := &Response{UniqueURLs: map[string]int{"http://t.com/": 1}, Pages: map[string]*Page{"http://t.com/": page}}
+
+	tests := []struct {
+		opts        SitemapOptions
+		contains    []string
+		notContains []string
+	}{
+		{
+			notContains: []string{"xmlns:image", "<image:image>", "<video:video>", "<xhtml:link"},
+		},
+		{
+			opts: SitemapOptions{Images: true, Videos: true, Alternates: true},
+			contains: []string{
+				`xmlns:image="http://www.google.com/schemas/sitemap-image/1.1"`,
+				`xmlns:video="http://www.google.com/schemas/sitemap-video/1.1"`,
+				`xmlns:xhtml="http://www.w3.org/1999/xhtml"`,
+				"<image:loc>http://t.com/a.png</image:loc>",
+				"<video:thumbnail_loc>http://t.com/thumb.jpg</video:thumbnail_loc>",
+				"<video:title>Page &amp; more</video:title>",
+				"<video:description>About the page</video:description>",
+				"<video:content_loc>http://t.com/intro.mp4</video:content_loc>",
+				`<xhtml:link rel="alternate" hreflang="de" href="http://t.com/de/"/>`,
+			},
+			notContains: []string{"nothumb"},
+		},
+		{
+			opts:        SitemapOptions{Images: true},
+			contains:    []string{"xmlns:image", "<image:image>"},
+			notContains: []string{"xmlns:video", "xmlns:xhtml"},
+		},
+	}
+
+	for i, c := range tests {
+		entries, err := sitemapEntries(resp, c.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if err := WriteSitemap(&buf, entries); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, s := range c.contains {
+			if !strings.Contains(buf.String(), s) {
+				t.Fatalf("case %d: expected sitemap to contain %s but got\n%s", i, s, buf.String())
+			}
+		}
+
+		for _, s := range c.notContains {
+			if strings.Contains(buf.String(), s) {
+				t.Fatalf("case %d: expected sitemap not to contain %s but got\n%s", i, s, buf.String())
+			}
+		}
+
+		if err := xml.Unmarshal(buf.Bytes(), new(struct{})); err != nil {
+			t.Fatalf("case %d: sitemap is not valid xml: %v", i, err)
+		}
+	}
+}
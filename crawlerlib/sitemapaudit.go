@@ -0,0 +1,188 @@
+package crawlerlib
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SitemapAudit compares the urls listed in a sitemap to what a crawl found
+type SitemapAudit struct {
+	Listed     int            `json:"listed"`     // Listed is the number of distinct urls in the sitemap
+	Orphans    []string       `json:"orphans"`    // Orphans are listed in the sitemap but can't be reached by links from the seeds
+	Missing    []string       `json:"missing"`    // Missing are crawled pages that belong in the sitemap but aren't listed
+	Errored    []SitemapIssue `json:"errored"`    // Errored are listed in the sitemap but failed to crawl
+	Redirected []SitemapIssue `json:"redirected"` // Redirected are listed in the sitemap but redirect to another url
+	Unchecked  []string       `json:"unchecked"`  // Unchecked are listed in the sitemap but weren't crawled, e.g. out of scope
+}
+
+// SitemapIssue is a url listed in a sitemap that didn't respond with a page of its own
+type SitemapIssue struct {
+	URL        string `json:"url"`                   // URL listed in the sitemap
+	StatusCode int    `json:"status"`                // StatusCode of the last attempt, 0 if there was no response
+	Error      string `json:"error,omitempty"`       // Error the url failed with
+	RedirectTo string `json:"redirect_to,omitempty"` // RedirectTo is the url it redirected to
+}
+
+// AuditSitemap compares the urls of a sitemap, e.g. read with ParseSitemap, to the crawl response.
+// Urls are compared as they are, results are sorted by url
+func AuditSitemap(sitemapURLs []string, resp *Response) *SitemapAudit {
+	listed := make(map[string]bool)
+	for _, u := range sitemapURLs {
+		listed[u] = true
+	}
+
+	a := &SitemapAudit{
+		Listed:     len(listed),
+		Orphans:    []string{},
+		Missing:    []string{},
+		Errored:    []SitemapIssue{},
+		Redirected: []SitemapIssue{},
+		Unchecked:  []string{},
+	}
+
+	reachable := reachableURLs(resp)
+	for u := range listed {
+		if !reachable[u] {
+			a.Orphans = append(a.Orphans, u)
+		}
+
+		p, crawled := resp.Pages[u]
+		err, failed := resp.ErrorURLs[u]
+		switch {
+		case failed && (!crawled || p.StatusCode < 200 || p.StatusCode >= 300):
+			issue := SitemapIssue{URL: u, Error: err.Error()}
+			if crawled {
+				issue.StatusCode, issue.RedirectTo = p.StatusCode, p.RedirectTo
+			}
+			a.Errored = append(a.Errored, issue)
+		case crawled && p.RedirectTo != "":
+			a.Redirected = append(a.Redirected, SitemapIssue{URL: u, StatusCode: p.StatusCode, RedirectTo: p.RedirectTo})
+		case !crawled && !failed:
+			a.Unchecked = append(a.Unchecked, u)
+		}
+	}
+
+	for u := range resp.UniqueURLs {
+		if !listed[u] && sitemapEligible(resp, u) {
+			a.Missing = append(a.Missing, u)
+		}
+	}
+
+	sort.Strings(a.Orphans)
+	sort.Strings(a.Missing)
+	sort.Strings(a.Unchecked)
+	sort.Slice(a.Errored, func(i, j int) bool { return a.Errored[i].URL < a.Errored[j].URL })
+	sort.Slice(a.Redirected, func(i, j int) bool { return a.Redirected[i].URL < a.Redirected[j].URL })
+	return a
+}
+
+// reachableURLs returns the urls reachable by following the crawled links from the seeds of the sites,
+// or the base url when there are no sites, redirects included
+func reachableURLs(resp *Response) map[string]bool {
+	reachable := make(map[string]bool)
+	var queue []string
+	for _, s := range resp.Sites {
+		queue = append(queue, s.Seed)
+	}
+
+	if len(queue) == 0 && resp.BaseURL != nil {
+		queue = append(queue, resp.BaseURL.String())
+	}
+
+	for len(queue) > 0 {
+		u := queue[0]
+		queue = queue[1:]
+		if reachable[u] {
+			continue
+		}
+		reachable[u] = true
+
+		if p, ok := resp.Pages[u]; ok && p.RedirectTo != "" {
+			queue = append(queue, p.RedirectTo)
+		}
+
+		for _, e := range resp.Links.Outbound(u) {
+			if e.Follow {
+				queue = append(queue, e.Target)
+			}
+		}
+	}
+
+	return reachable
+}
+
+// Problems returns the number of orphan, missing, errored and redirected urls
+func (a *SitemapAudit) Problems() int {
+	return len(a.Orphans) + len(a.Missing) + len(a.Errored) + len(a.Redirected)
+}
+
+// Write writes the audit in the given format, one of text, csv or json.
+// csv has a row per url found with its problem
+func (a *SitemapAudit) Write(w io.Writer, format string) error {
+	switch strings.ToLower(format) {
+	case "text":
+		bw := bufio.NewWriter(w)
+		fmt.Fprintf(bw, "Sitemap URLs: %d  Orphans: %d  Missing: %d  Errored: %d  Redirected: %d  Unchecked: %d\n",
+			a.Listed, len(a.Orphans), len(a.Missing), len(a.Errored), len(a.Redirected), len(a.Unchecked))
+		writeAuditURLs(bw, "Orphans, in the sitemap but not linked from the site:", a.Orphans)
+		writeAuditURLs(bw, "Missing from the sitemap:", a.Missing)
+		if len(a.Errored) > 0 {
+			fmt.Fprintln(bw, "\nErrored:")
+			for _, e := range a.Errored {
+				fmt.Fprintf(bw, "  %s (%s)\n", e.URL, e.Error)
+			}
+		}
+		if len(a.Redirected) > 0 {
+			fmt.Fprintln(bw, "\nRedirected:")
+			for _, e := range a.Redirected {
+				fmt.Fprintf(bw, "  %s -> %s\n", e.URL, e.RedirectTo)
+			}
+		}
+		writeAuditURLs(bw, "Unchecked, in the sitemap but not crawled:", a.Unchecked)
+		return bw.Flush()
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"problem", "url", "status", "detail"})
+		for _, u := range a.Orphans {
+			cw.Write([]string{"orphan", u, "", ""})
+		}
+		for _, u := range a.Missing {
+			cw.Write([]string{"missing", u, "", ""})
+		}
+		for _, e := range a.Errored {
+			cw.Write([]string{"errored", e.URL, strconv.Itoa(e.StatusCode), e.Error})
+		}
+		for _, e := range a.Redirected {
+			cw.Write([]string{"redirected", e.URL, strconv.Itoa(e.StatusCode), e.RedirectTo})
+		}
+		for _, u := range a.Unchecked {
+			cw.Write([]string{"unchecked", u, "", ""})
+		}
+		cw.Flush()
+		return cw.Error()
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(a)
+	default:
+		return fmt.Errorf("unknown report format %q, expected text, csv or json", format)
+	}
+}
+
+// writeAuditURLs writes the urls under the title, nothing when there are none
+func writeAuditURLs(w io.Writer, title string, urls []string) {
+	if len(urls) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "\n%s\n", title)
+	for _, u := range urls {
+		fmt.Fprintf(w, "  %s\n", u)
+	}
+}
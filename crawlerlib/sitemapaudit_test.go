@@ -0,0 +1,119 @@
+package crawlerlib
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+// testAuditResponse returns the graph test response with a page redirecting to /blog/a
+func testAuditResponse() *Response {
+	resp := testGraphResponse()
+	resp.BaseURL, _ = url.Parse("http://t.com/")
+	resp.Pages["http://t.com/old"] = &Page{URL: "http://t.com/old", StatusCode: 200, RedirectTo: "http://t.com/blog/a"}
+	resp.UniqueURLs = make(map[string]int)
+	for u := range resp.Pages {
+		resp.UniqueURLs[u] = 1
+	}
+
+	return resp
+}
+
+func Test_AuditSitemap(t *testing.T) {
+	sitemap := []string{"http://t.com/", "http://t.com/blog/b", "http://t.com/old", "http://t.com/hidden", "http://t.com/"}
+	expected := &SitemapAudit{
+		Listed:  4,
+		Orphans: []string{"http://t.com/hidden", "http://t.com/old"},
+		Missing: []string{"http://t.com/blog/a"},
+		Errored: []SitemapIssue{
+			{URL: "http://t.com/blog/b", StatusCode: 404, Error: "url responsed with code 404"},
+		},
+		Redirected: []SitemapIssue{
+			{URL: "http://t.com/old", StatusCode: 200, RedirectTo: "http://t.com/blog/a"},
+		},
+		Unchecked: []string{"http://t.com/hidden"},
+	}
+
+	got := AuditSitemap(sitemap, testAuditResponse())
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %+v but got %+v", expected, got)
+	}
+
+	if got.Problems() != 5 {
+		t.Fatalf("expected 5 problems but got %d", got.Problems())
+	}
+}
+
+func Test_AuditSitemapSites(t *testing.T) {
+	ts := testSite(map[string][]string{
+		"/a/":  {"/a/1"},
+		"/a/1": {},
+		"/b/":  {"/b/1"},
+		"/b/1": {},
+	})
+	defer ts.Close()
+
+	cr, err := New(WithIgnoreRobots(true), WithOutput(nil), WithSites(
+		Site{URL: ts.URL + "/a/", Scope: []ScopeRule{{Include: true, Pattern: "/a/*"}}},
+		Site{URL: ts.URL + "/b/", Scope: []ScopeRule{{Include: true, Pattern: "/b/*"}}},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := cr.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the pages of the second site are reached from its own seed
+	sitemap := []string{ts.URL + "/a/", ts.URL + "/a/1", ts.URL + "/b/", ts.URL + "/b/1"}
+	if a := AuditSitemap(sitemap, resp); a.Problems() != 0 {
+		t.Fatalf("expected no problems but got %+v", a)
+	}
+}
+
+func TestSitemapAudit_Write(t *testing.T) {
+	tests := []struct {
+		format   string
+		expected string
+		error    bool
+	}{
+		{
+			format: "text",
+			expected: "Sitemap URLs: 3  Orphans: 1  Missing: 1  Errored: 1  Redirected: 1  Unchecked: 0\n\n" +
+				"Orphans, in the sitemap but not linked from the site:\n" +
+				"  http://t.com/old\n\n" +
+				"Missing from the sitemap:\n" +
+				"  http://t.com/blog/a\n\n" +
+				"Errored:\n" +
+				"  http://t.com/blog/b (url responsed with code 404)\n\n" +
+				"Redirected:\n" +
+				"  http://t.com/old -> http://t.com/blog/a\n",
+		},
+		{
+			format: "csv",
+			expected: "problem,url,status,detail\n" +
+				"orphan,http://t.com/old,,\n" +
+				"missing,http://t.com/blog/a,,\n" +
+				"errored,http://t.com/blog/b,404,url responsed with code 404\n" +
+				"redirected,http://t.com/old,200,http://t.com/blog/a\n",
+		},
+		{format: "xml", error: true},
+	}
+
+	a := AuditSitemap([]string{"http://t.com/", "http://t.com/blog/b", "http://t.com/old"}, testAuditResponse())
+	for _, c := range tests {
+		var buf bytes.Buffer
+		err := a.Write(&buf, c.format)
+		if (err != nil) != c.error {
+			t.Fatalf("%s: expected error %t but got %v", c.format, c.error, err)
+		}
+
+		if buf.String() != c.expected {
+			t.Fatalf("%s: expected\n%s\nbut got\n%s", c.format, c.expected, buf.String())
+		}
+	}
+}
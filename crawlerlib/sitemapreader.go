@@ -0,0 +1,216 @@
+package crawlerlib
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// SeedMode says where the crawl gets its depth 0 urls from
+type SeedMode int
+
+const (
+	// SeedLinks starts from the seed urls and discovers pages by following links
+	SeedLinks SeedMode = iota
+	// SeedSitemaps starts from the urls listed in the sitemaps of the seeds instead
+	SeedSitemaps
+	// SeedBoth starts from the seed urls and the urls listed in their sitemaps
+	SeedBoth
+)
+
+// maxSitemapFetches caps the sitemaps read for a crawl, nested indexes included
+const maxSitemapFetches = 1000
+
+// ParseSeedMode parses a seed mode written as links, sitemaps or both
+func ParseSeedMode(s string) (SeedMode, error) {
+	switch strings.ToLower(s) {
+	case "links":
+		return SeedLinks, nil
+	case "sitemaps":
+		return SeedSitemaps, nil
+	case "both":
+		return SeedBoth, nil
+	default:
+		return SeedLinks, fmt.Errorf("unknown seed mode %q, expected links, sitemaps or both", s)
+	}
+}
+
+// sitemapLoc is the loc of a url or sitemap entry
+type sitemapLoc struct {
+	Loc string `xml:"loc"`
+}
+
+// sitemapDoc is a urlset or sitemapindex document
+type sitemapDoc struct {
+	XMLName  xml.Name
+	URLs     []sitemapLoc `xml:"url"`
+	Sitemaps []sitemapLoc `xml:"sitemap"`
+}
+
+// ParseSitemap parses a urlset or sitemapindex document, gzipped or not.
+// urls are the locs of a urlset and sitemaps the locs of an index
+func ParseSitemap(r io.Reader) (urls, sitemaps []string, err error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid gzipped sitemap: %v", err)
+		}
+		defer zr.Close()
+		r = zr
+	} else {
+		r = br
+	}
+
+	var doc sitemapDoc
+	if err := xml.NewDecoder(io.LimitReader(r, MaxSitemapBytes)).Decode(&doc); err != nil {
+		return nil, nil, fmt.Errorf("invalid sitemap: %v", err)
+	}
+
+	switch doc.XMLName.Local {
+	case "urlset":
+		for _, l := range doc.URLs {
+			if loc := strings.TrimSpace(l.Loc); loc != "" {
+				urls = append(urls, loc)
+			}
+		}
+	case "sitemapindex":
+		for _, l := range doc.Sitemaps {
+			if loc := strings.TrimSpace(l.Loc); loc != "" {
+				sitemaps = append(sitemaps, loc)
+			}
+		}
+	default:
+		return nil, nil, fmt.Errorf("invalid sitemap: unexpected root element %s", doc.XMLName.Local)
+	}
+
+	return urls, sitemaps, nil
+}
+
+// fetchSitemap fetches and parses the sitemap at u
+func fetchSitemap(ctx context.Context, f *fetcher, u *url.URL) (urls, sitemaps []string, err error) {
+	ctx, cancel := context.WithTimeout(ctx, f.requestTimeout)
+	defer cancel()
+	req, err := newRequest(ctx, f, http.MethodGet, u)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("sitemap responded with code %d", resp.StatusCode)
+	}
+
+	return ParseSitemap(resp.Body)
+}
+
+// discoverSitemaps returns the sitemaps robots.txt declares for the origins of the seeds,
+// or /sitemap.xml of an origin declaring none
+func discoverSitemaps(ctx context.Context, f *fetcher, rc *robotsCache, seeds []*url.URL) []string {
+	var sitemaps []string
+	seen := make(map[string]bool)
+	for _, u := range seeds {
+		origin := robotsOrigin(u)
+		if seen[origin] {
+			continue
+		}
+		seen[origin] = true
+
+		var rt *robotsTxt
+		if rc != nil {
+			rt = robotsFor(rc, u)
+		} else {
+			rt = fetchRobots(ctx, f, origin)
+		}
+
+		if len(rt.sitemaps) == 0 {
+			sitemaps = append(sitemaps, origin+"/sitemap.xml")
+			continue
+		}
+
+		sitemaps = append(sitemaps, rt.sitemaps...)
+	}
+
+	return sitemaps
+}
+
+// readSitemaps reads the sitemaps and the nested indexes they list. found maps every url
+// listed to the sitemap listing it first, errs holds why a sitemap couldn't be read
+func readSitemaps(ctx context.Context, f *fetcher, sitemaps []string) (found map[string]string, order []string, errs map[string]error) {
+	found = make(map[string]string)
+	errs = make(map[string]error)
+	seen := make(map[string]bool)
+	queue := sitemaps
+	for fetches := 0; len(queue) > 0 && ctx.Err() == nil; {
+		s := queue[0]
+		queue = queue[1:]
+		if seen[s] {
+			continue
+		}
+		seen[s] = true
+
+		if fetches == maxSitemapFetches {
+			errs[s] = fmt.Errorf("not read, more than %d sitemaps", maxSitemapFetches)
+			continue
+		}
+		fetches++
+
+		u, err := url.Parse(s)
+		if err != nil || u.Host == "" {
+			errs[s] = fmt.Errorf("invalid sitemap url: %s", s)
+			continue
+		}
+
+		urls, nested, err := fetchSitemap(ctx, f, u)
+		if err != nil {
+			errs[s] = err
+			continue
+		}
+
+		for _, loc := range urls {
+			if _, ok := found[loc]; !ok {
+				found[loc] = s
+				order = append(order, loc)
+			}
+		}
+
+		queue = append(queue, nested...)
+	}
+
+	return found, order, errs
+}
+
+// sitemapSeeds returns the urls listed in the sitemaps as seeds. Invalid and out of scope
+// urls are added to the skipped urls of the sitemap listing them
+func sitemapSeeds(g *delegator, locs []string) []*url.URL {
+	var seeds []*url.URL
+	for _, loc := range locs {
+		sitemap := g.sitemapURLs[loc]
+		u, err := url.Parse(loc)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			g.skippedURLs[sitemap] = append(g.skippedURLs[sitemap], loc)
+			continue
+		}
+
+		if !inScope(g, u) {
+			g.skippedURLs[sitemap] = append(g.skippedURLs[sitemap], loc)
+			g.skipReasons[loc] = "listed in " + sitemap + " but out of scope"
+			continue
+		}
+
+		seeds = append(seeds, u)
+	}
+
+	return seeds
+}
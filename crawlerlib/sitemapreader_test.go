@@ -0,0 +1,126 @@
+package crawlerlib
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// gzipString returns s gzipped
+func gzipString(s string) string {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(s))
+	zw.Close()
+	return buf.String()
+}
+
+func Test_ParseSitemap(t *testing.T) {
+	urlset := `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc> http://t.com/a </loc><lastmod>2020-01-01</lastmod></url>
+  <url><loc>http://t.com/b?x=1&amp;y=2</loc></url>
+  <url><loc></loc></url>
+</urlset>`
+	index := `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>http://t.com/sitemap-1.xml.gz</loc></sitemap>
+</sitemapindex>`
+
+	tests := []struct {
+		doc      string
+		urls     []string
+		sitemaps []string
+		error    bool
+	}{
+		{doc: urlset, urls: []string{"http://t.com/a", "http://t.com/b?x=1&y=2"}},
+		{doc: gzipString(urlset), urls: []string{"http://t.com/a", "http://t.com/b?x=1&y=2"}},
+		{doc: index, sitemaps: []string{"http://t.com/sitemap-1.xml.gz"}},
+		{doc: `<html><body>not found</body></html>`, error: true},
+		{doc: `<urlset><url>`, error: true},
+	}
+
+	for i, c := range tests {
+		urls, sitemaps, err := ParseSitemap(strings.NewReader(c.doc))
+		if (err != nil) != c.error {
+			t.Fatalf("case %d: expected error %t but got %v", i, c.error, err)
+		}
+
+		if !reflect.DeepEqual(urls, c.urls) || !reflect.DeepEqual(sitemaps, c.sitemaps) {
+			t.Fatalf("case %d: expected %v %v but got %v %v", i, c.urls, c.sitemaps, urls, sitemaps)
+		}
+	}
+}
+
+func TestCrawler_RunSitemapSeeds(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			fmt.Fprintf(w, "User-agent: *\nDisallow:\nSitemap: %s/sitemap_index.xml\n", ts.URL)
+		case "/sitemap_index.xml":
+			fmt.Fprintf(w, `<sitemapindex><sitemap><loc>%[1]s/pages.xml.gz</loc></sitemap>
+				<sitemap><loc>%[1]s/missing.xml</loc></sitemap><sitemap><loc>%[1]s/sitemap_index.xml</loc></sitemap></sitemapindex>`, ts.URL)
+		case "/pages.xml.gz":
+			fmt.Fprint(w, gzipString(fmt.Sprintf(`<urlset><url><loc>%[1]s/</loc></url><url><loc>%[1]s/orphan</loc></url>
+				<url><loc>https://other.com/</loc></url></urlset>`, ts.URL)))
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<a href="/linked">linked</a>`)
+		case "/linked", "/orphan":
+			w.Header().Set("Content-Type", "text/html")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		mode     SeedMode
+		expected []string
+	}{
+		{mode: SeedLinks, expected: []string{"/", "/linked"}},
+		{mode: SeedSitemaps, expected: []string{"/", "/linked", "/orphan"}},
+		{mode: SeedBoth, expected: []string{"/", "/linked", "/orphan"}},
+	}
+
+	for _, c := range tests {
+		crawler, err := New(WithSeedMode(c.mode), WithOutput(nil), WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := crawler.Run(context.Background(), ts.URL+"/")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var crawled []string
+		for u := range resp.Pages {
+			crawled = append(crawled, strings.TrimPrefix(u, ts.URL))
+		}
+		sort.Strings(crawled)
+
+		if !reflect.DeepEqual(crawled, c.expected) {
+			t.Fatalf("mode %d: expected %v to be crawled but got %v", c.mode, c.expected, crawled)
+		}
+
+		if c.mode == SeedLinks {
+			continue
+		}
+
+		if _, ok := resp.SitemapErrors[ts.URL+"/missing.xml"]; !ok || len(resp.SitemapErrors) != 1 {
+			t.Fatalf("mode %d: expected missing.xml to fail but got %v", c.mode, resp.SitemapErrors)
+		}
+
+		if resp.SitemapURLs[ts.URL+"/orphan"] != ts.URL+"/pages.xml.gz" || resp.SkipReasons["https://other.com/"] == "" {
+			t.Fatalf("mode %d: unexpected sitemap urls %v skip reasons %v", c.mode, resp.SitemapURLs, resp.SkipReasons)
+		}
+	}
+}
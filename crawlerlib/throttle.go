@@ -0,0 +1,121 @@
+package crawlerlib
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	minThrottleBackoff = time.Second     // minThrottleBackoff is the delay after the first 429/503 of a host
+	maxThrottleBackoff = 5 * time.Minute // maxThrottleBackoff caps the delay between requests to a throttled host
+	maxThrottleRetries = 5               // maxThrottleRetries is how often a throttled url is requeued before giving up
+)
+
+// HostStats holds the request stats and throttling state of a host
+type HostStats struct {
+	Requests    int           // Requests made to the host
+	Throttled   int           // Throttled is the number of 429 and 503 responses from the host
+	Backoff     time.Duration // Backoff is the current delay between requests added by throttling
+	PausedUntil time.Time     // PausedUntil is when requests to the host resume, zero if not paused
+}
+
+// isThrottled says if the response status asks us to slow down
+func isThrottled(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable
+}
+
+// parseRetryAfter parses the Retry-After header given as seconds or an HTTP-date
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+
+	return 0, true
+}
+
+// throttleHost backs off the host after a 429 or 503. The backoff doubles with every
+// throttled response and the host is paused for the backoff or retryAfter, whichever is longer
+func throttleHost(s *hostScheduler, host string, retryAfter time.Duration, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h := hostStateFor(s, host)
+	h.throttled++
+	h.backoff *= 2
+	if h.backoff < minThrottleBackoff {
+		h.backoff = minThrottleBackoff
+	}
+	if h.backoff > maxThrottleBackoff {
+		h.backoff = maxThrottleBackoff
+	}
+
+	pause := h.backoff
+	if retryAfter > pause {
+		pause = retryAfter
+	}
+
+	if until := now.Add(pause); until.After(h.pausedUntil) {
+		h.pausedUntil = until
+	}
+
+	// a single request once the pause is over, then one per backoff
+	h.tokens = 1
+	h.refilled = h.pausedUntil
+}
+
+// recoverHost ramps the host back up after a successful response by shrinking its backoff
+func recoverHost(s *hostScheduler, host string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h := hostStateFor(s, host)
+	if h.backoff == 0 {
+		return
+	}
+
+	h.backoff = h.backoff * 3 / 4
+	if h.backoff < minThrottleBackoff/10 {
+		h.backoff = 0
+	}
+}
+
+// hostStats returns a snapshot of the stats of every host the scheduler has seen
+func hostStats(s *hostScheduler) map[string]HostStats {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := make(map[string]HostStats, len(s.hosts))
+	for host, h := range s.hosts {
+		stats[host] = HostStats{
+			Requests:    h.requests,
+			Throttled:   h.throttled,
+			Backoff:     h.backoff,
+			PausedUntil: h.pausedUntil,
+		}
+	}
+
+	return stats
+}
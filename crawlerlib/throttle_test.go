@@ -0,0 +1,134 @@
+package crawlerlib
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_parseRetryAfter(t *testing.T) {
+	now := time.Date(2019, 11, 20, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		v     string
+		wait  time.Duration
+		valid bool
+	}{
+		{v: "", valid: false},
+		{v: "120", wait: 2 * time.Minute, valid: true},
+		{v: "-1", valid: false},
+		{v: "Wed, 20 Nov 2019 10:00:30 GMT", wait: 30 * time.Second, valid: true},
+		{v: "Wed, 20 Nov 2019 09:00:00 GMT", wait: 0, valid: true},
+		{v: "tomorrow", valid: false},
+	}
+
+	for _, c := range tests {
+		wait, ok := parseRetryAfter(c.v, now)
+		if ok != c.valid || wait != c.wait {
+			t.Fatalf("expected %q to be %v/%t but got %v/%t", c.v, c.wait, c.valid, wait, ok)
+		}
+	}
+}
+
+func Test_throttleHost(t *testing.T) {
+	s, _ := newHostScheduler(Politeness{})
+	now := time.Now()
+	host := "test.com"
+
+	ok, _ := tryAcquire(s, host, now)
+	if !ok {
+		t.Fatal("expected host to be eligible before throttling")
+	}
+	release(s, host)
+
+	throttleHost(s, host, 10*time.Second, now)
+	ok, wait := tryAcquire(s, host, now.Add(time.Second))
+	if ok || wait != 9*time.Second {
+		t.Fatalf("expected host to be paused for Retry-After but got %t/%v", ok, wait)
+	}
+
+	throttleHost(s, host, 0, now)
+	throttleHost(s, host, 0, now)
+	stats := hostStats(s)[host]
+	if stats.Throttled != 3 || stats.Backoff != 4*time.Second || stats.Requests != 1 {
+		t.Fatalf("unexpected stats after throttling: %+v", stats)
+	}
+
+	ok, _ = tryAcquire(s, host, now.Add(10*time.Second))
+	if !ok {
+		t.Fatal("expected host to be eligible once the pause is over")
+	}
+	release(s, host)
+
+	ok, wait = tryAcquire(s, host, now.Add(12*time.Second))
+	if ok || wait != 2*time.Second {
+		t.Fatalf("expected host to earn tokens at the backoff rate but got %t/%v", ok, wait)
+	}
+
+	for i := 0; i < 20; i++ {
+		recoverHost(s, host)
+	}
+
+	if b := hostStats(s)[host].Backoff; b != 0 {
+		t.Fatalf("expected backoff to ramp down to 0 but got %v", b)
+	}
+}
+
+func TestCrawler_RunThrottledHost(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	counts := make(map[string]int)
+	record := func(r *http.Request) int {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, r.Host+r.URL.Path)
+		counts[r.Host+r.URL.Path]++
+		return counts[r.Host+r.URL.Path]
+	}
+
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		w.Header().Set("Content-Type", "text/html")
+	}))
+	defer other.Close()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if record(r) == 1 && r.URL.Path == "/x" {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/" {
+			fmt.Fprintf(w, `<a href="/x">x</a><a href="%s/1">1</a><a href="%s/2">2</a>`, other.URL, other.URL)
+		}
+	}))
+	defer ts.Close()
+
+	cr, err := New(WithIgnoreRobots(true), WithOutput(nil), WithConcurrency(1), WithMaxDepth(-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := cr.Run(context.Background(), ts.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the scraper moves on to the other host while /x waits out its Retry-After
+	host := strings.TrimPrefix(ts.URL, "http://")
+	expected := fmt.Sprint([]string{host + "/", host + "/x", strings.TrimPrefix(other.URL, "http://") + "/1", strings.TrimPrefix(other.URL, "http://") + "/2", host + "/x"})
+	if got := fmt.Sprint(requests); got != expected {
+		t.Fatalf("expected requests %s but got %s", expected, got)
+	}
+
+	p := resp.Pages[ts.URL+"/x"]
+	if p == nil || p.StatusCode != http.StatusOK || p.Attempts != 2 || len(p.Errors) != 1 {
+		t.Fatalf("expected /x to be crawled on its second attempt but got %+v", p)
+	}
+}
@@ -0,0 +1,271 @@
+package crawlerlib
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The heuristics of the trap detection, as reported in Trap.Kind
+const (
+	TrapRepeatedSegments = "repeated-segments" // TrapRepeatedSegments flags paths repeating segments back to back, e.g. /a/a/a or /a/b/a/b/a/b
+	TrapPathLength       = "path-length"       // TrapPathLength flags paths longer than the limit
+	TrapQueryVariants    = "query-variants"    // TrapQueryVariants flags a path linked with too many different queries
+	TrapTemplate         = "template"          // TrapTemplate flags too many urls sharing a template, e.g. /calendar/{n}/{n}
+)
+
+// maxTrapExamples is the number of quarantined urls kept as examples of a trap
+const maxTrapExamples = 5
+
+// TrapConfig holds the thresholds of the crawler trap detection. Zero values use the
+// DefaultTrapConfig ones and negative values turn a heuristic off
+type TrapConfig struct {
+	MaxSegmentRepeats int // MaxSegmentRepeats is the times a segment, or a run of segments, may repeat back to back in a path
+	MaxPathLength     int // MaxPathLength is the length of the escaped path in bytes
+	MaxQueryVariants  int // MaxQueryVariants is the number of different queries crawled for a path
+	MaxTemplateURLs   int // MaxTemplateURLs is the number of urls crawled for a template, numbers and ids in the path make up a template. Off by default
+}
+
+// DefaultTrapConfig holds the thresholds used when none are configured
+var DefaultTrapConfig = TrapConfig{
+	MaxSegmentRepeats: 2,
+	MaxPathLength:     1024,
+	MaxQueryVariants:  100,
+	MaxTemplateURLs:   -1,
+}
+
+// Trap is a suspected crawler trap, its urls are quarantined rather than crawled
+type Trap struct {
+	Kind     string   // Kind is the heuristic that flagged the trap, e.g. TrapRepeatedSegments
+	Pattern  string   // Pattern the urls of the trap share: the host and repeated segment, the host, the path or the template
+	URLs     int      // URLs is the number of urls quarantined
+	Examples []string // Examples are the first urls quarantined
+}
+
+// digitsRegex matches the numbers replaced in url templates
+var digitsRegex = regexp.MustCompile(`[0-9]+`)
+
+// trapDetector holds the state of the trap detection of a crawl
+type trapDetector struct {
+	cfg         TrapConfig       // cfg holds the thresholds, defaults filled in
+	variants    map[string]int   // variants counts the queries seen per host and path
+	templates   map[string]int   // templates counts the urls seen per template
+	quarantined map[string]bool  // quarantined are the urls held back as traps
+	traps       map[string]*Trap // traps by kind and pattern
+	order       []*Trap          // order holds the traps in the order they were found
+}
+
+// newTrapDetector returns a detector for the config, zero thresholds are set to the default ones
+func newTrapDetector(cfg TrapConfig) *trapDetector {
+	if cfg.MaxSegmentRepeats == 0 {
+		cfg.MaxSegmentRepeats = DefaultTrapConfig.MaxSegmentRepeats
+	}
+
+	if cfg.MaxPathLength == 0 {
+		cfg.MaxPathLength = DefaultTrapConfig.MaxPathLength
+	}
+
+	if cfg.MaxQueryVariants == 0 {
+		cfg.MaxQueryVariants = DefaultTrapConfig.MaxQueryVariants
+	}
+
+	if cfg.MaxTemplateURLs == 0 {
+		cfg.MaxTemplateURLs = DefaultTrapConfig.MaxTemplateURLs
+	}
+
+	return &trapDetector{
+		cfg:         cfg,
+		variants:    make(map[string]int),
+		templates:   make(map[string]int),
+		quarantined: make(map[string]bool),
+		traps:       make(map[string]*Trap),
+	}
+}
+
+// urlTemplate returns the host and path of the url with numbers and ids replaced, followed by the
+// sorted query keys, e.g. https://a.com/events/2024-05?day=3 becomes a.com/events/{n}-{n}?day
+func urlTemplate(u *url.URL) string {
+	segments := strings.Split(u.EscapedPath(), "/")
+	for i, s := range segments {
+		if isIDSegment(s) {
+			segments[i] = "{id}"
+			continue
+		}
+
+		segments[i] = digitsRegex.ReplaceAllString(s, "{n}")
+	}
+
+	t := u.Host + strings.Join(segments, "/")
+	if u.RawQuery == "" {
+		return t
+	}
+
+	var keys []string
+	for k := range u.Query() {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return t + "?" + strings.Join(keys, "&")
+}
+
+// isIDSegment says if the path segment looks like a session id, hash or uuid:
+// a long run of letters, digits, - and _ mixing letters and digits
+func isIDSegment(s string) bool {
+	if len(s) < 16 {
+		return false
+	}
+
+	letters, digits := false, false
+	for _, c := range s {
+		switch {
+		case 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z':
+			letters = true
+		case '0' <= c && c <= '9':
+			digits = true
+		case c == '-' || c == '_':
+		default:
+			return false
+		}
+	}
+
+	return letters && digits
+}
+
+// repeatedSegment returns the first segment, or run of segments, repeated back to back more than max
+// times in the path, e.g. a/b for /a/b/a/b/a/b, empty when there is none. Segments repeating apart
+// from each other such as the v2 of /docs/v2/api/v2 are left alone
+func repeatedSegment(u *url.URL, max int) string {
+	var segments []string
+	for _, s := range strings.Split(u.EscapedPath(), "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+
+	for i := range segments {
+		for n := 1; i+n*(max+1) <= len(segments); n++ {
+			repeats := 1
+			for i+(repeats+1)*n <= len(segments) && sameSegments(segments[i:i+n], segments[i+repeats*n:i+(repeats+1)*n]) {
+				repeats++
+			}
+
+			if repeats > max {
+				return strings.Join(segments[i:i+n], "/")
+			}
+		}
+	}
+
+	return ""
+}
+
+// sameSegments says if the two runs of path segments are the same
+func sameSegments(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkTrap says if the url looks like a trap, along with the kind of trap, the pattern it shares
+// with the other urls of the trap and why it was flagged. urls passing are counted towards the
+// query variant and template thresholds
+func checkTrap(t *trapDetector, u *url.URL) (kind, pattern, reason string) {
+	path := u.EscapedPath()
+	if t.cfg.MaxSegmentRepeats > 0 {
+		if s := repeatedSegment(u, t.cfg.MaxSegmentRepeats); s != "" {
+			return TrapRepeatedSegments, u.Host + " " + s, fmt.Sprintf("path repeats %q more than %d times in a row", s, t.cfg.MaxSegmentRepeats)
+		}
+	}
+
+	if t.cfg.MaxPathLength > 0 && len(path) > t.cfg.MaxPathLength {
+		return TrapPathLength, u.Host, fmt.Sprintf("path is longer than %d bytes", t.cfg.MaxPathLength)
+	}
+
+	hostPath := u.Host + path
+	if t.cfg.MaxQueryVariants > 0 && u.RawQuery != "" && t.variants[hostPath] >= t.cfg.MaxQueryVariants {
+		return TrapQueryVariants, hostPath, fmt.Sprintf("path has more than %d query variants", t.cfg.MaxQueryVariants)
+	}
+
+	template := urlTemplate(u)
+	if t.cfg.MaxTemplateURLs > 0 && t.templates[template] >= t.cfg.MaxTemplateURLs {
+		return TrapTemplate, template, fmt.Sprintf("more than %d urls share the template %s", t.cfg.MaxTemplateURLs, template)
+	}
+
+	if u.RawQuery != "" {
+		t.variants[hostPath]++
+	}
+	t.templates[template]++
+	return "", "", ""
+}
+
+// quarantine holds the url back as part of the trap
+func quarantine(t *trapDetector, u *url.URL, kind, pattern string) {
+	t.quarantined[u.String()] = true
+	key := kind + " " + pattern
+	trap, ok := t.traps[key]
+	if !ok {
+		trap = &Trap{Kind: kind, Pattern: pattern}
+		t.traps[key] = trap
+		t.order = append(t.order, trap)
+	}
+
+	trap.URLs++
+	if len(trap.Examples) < maxTrapExamples {
+		trap.Examples = append(trap.Examples, u.String())
+	}
+}
+
+// trapProcessor quarantines the md.urls that look like crawler traps and adds them to the skipped
+// urls of the source url along with why they were flagged
+func trapProcessor() processor {
+	return processorFunc(func(g *delegator, md *scraperDump) (proceed bool) {
+		if g.traps == nil {
+			return true
+		}
+
+		source := md.sourceURL.String()
+		var urls []*url.URL
+		seen := make(map[string]bool)
+		for _, u := range md.urls {
+			key := u.String()
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			if g.traps.quarantined[key] {
+				g.skippedURLs[source] = append(g.skippedURLs[source], key)
+				continue
+			}
+
+			kind, pattern, reason := checkTrap(g.traps, u)
+			if kind == "" {
+				urls = append(urls, u)
+				continue
+			}
+
+			logf(g.logger, "quarantined %s as a suspected crawler trap: %s\n", key, reason)
+			quarantine(g.traps, u, kind, pattern)
+			g.skippedURLs[source] = append(g.skippedURLs[source], key)
+			g.skipReasons[key] = "quarantined as a suspected crawler trap: " + reason
+		}
+
+		md.urls = urls
+		return true
+	})
+}
+
+// foundTraps returns the traps found in the order they were found
+func foundTraps(t *trapDetector) []*Trap {
+	if t == nil {
+		return nil
+	}
+
+	return t.order
+}
@@ -0,0 +1,161 @@
+package crawlerlib
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func Test_urlTemplate(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{url: "https://a.com/", expected: "a.com/"},
+		{url: "https://a.com/events/2024-05?day=3&month=5", expected: "a.com/events/{n}-{n}?day&month"},
+		{url: "https://a.com/page-2/", expected: "a.com/page-{n}/"},
+		{url: "https://a.com/s/0a1b2c3d4e5f6a7b8c9d/cart", expected: "a.com/s/{id}/cart"},
+		{url: "https://a.com/docs/getting-started", expected: "a.com/docs/getting-started"},
+	}
+
+	for _, c := range tests {
+		u, _ := url.Parse(c.url)
+		if got := urlTemplate(u); got != c.expected {
+			t.Fatalf("expected template of %s to be %s but got %s", c.url, c.expected, got)
+		}
+	}
+}
+
+func Test_checkTrap(t *testing.T) {
+	td := newTrapDetector(TrapConfig{MaxPathLength: 20, MaxQueryVariants: 2, MaxTemplateURLs: 3})
+	tests := []struct {
+		url  string
+		kind string
+	}{
+		{url: "https://a.com/a/b/a/c", kind: ""},
+		{url: "https://a.com/a/b/a/c/a", kind: ""},
+		{url: "https://a.com/d/v2/x/v2/y", kind: ""},
+		{url: "https://a.com/a/a/a", kind: TrapRepeatedSegments},
+		{url: "https://a.com/x/a/b/a/b/a/b", kind: TrapRepeatedSegments},
+		{url: "https://a.com/a-very-long-path/of-pages", kind: TrapPathLength},
+		{url: "https://a.com/search?q=1", kind: ""},
+		{url: "https://a.com/search?q=2", kind: ""},
+		{url: "https://a.com/search?q=3", kind: TrapQueryVariants},
+		{url: "https://a.com/search", kind: ""},
+		{url: "https://a.com/cal/1", kind: ""},
+		{url: "https://a.com/cal/2", kind: ""},
+		{url: "https://a.com/cal/3", kind: ""},
+		{url: "https://a.com/cal/4", kind: TrapTemplate},
+	}
+
+	for _, c := range tests {
+		u, _ := url.Parse(c.url)
+		if kind, _, _ := checkTrap(td, u); kind != c.kind {
+			t.Fatalf("expected %s to be flagged as %q but got %q", c.url, c.kind, kind)
+		}
+	}
+
+	// turned off heuristics flag nothing
+	td = newTrapDetector(TrapConfig{MaxSegmentRepeats: -1, MaxPathLength: -1})
+	u, _ := url.Parse("https://a.com/a/a/a/a/" + strings.Repeat("x", 2000))
+	if kind, _, _ := checkTrap(td, u); kind != "" {
+		t.Fatalf("expected no trap but got %s", kind)
+	}
+}
+
+func TestCrawler_RunTraps(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch {
+		case r.URL.Path == "/":
+			fmt.Fprint(w, `<a href="a/">a</a><a href="/cal/1">cal</a><a href="/search?q=1">search</a>`)
+		case strings.HasPrefix(r.URL.Path, "/a/"):
+			fmt.Fprint(w, `<a href="a/">deeper</a>`)
+		case strings.HasPrefix(r.URL.Path, "/cal/"):
+			n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/cal/"))
+			fmt.Fprintf(w, `<a href="/cal/%d">next</a>`, n+1)
+		default:
+			n, _ := strconv.Atoi(r.URL.Query().Get("q"))
+			fmt.Fprintf(w, `<a href="/search?q=%d">next</a>`, n+1)
+		}
+	}))
+	defer ts.Close()
+
+	cr, err := New(WithIgnoreRobots(true), WithOutput(nil), WithMaxDepth(-1),
+		WithTraps(TrapConfig{MaxQueryVariants: 3, MaxTemplateURLs: 5}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := cr.Run(context.Background(), ts.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host := strings.TrimPrefix(ts.URL, "http://")
+	expected := map[string]string{
+		TrapRepeatedSegments: ts.URL + "/a/a/a/",
+		TrapTemplate:         ts.URL + "/cal/6",
+		TrapQueryVariants:    ts.URL + "/search?q=4",
+	}
+	if len(resp.Traps) != len(expected) {
+		t.Fatalf("expected %d traps but got %d", len(expected), len(resp.Traps))
+	}
+
+	for _, trap := range resp.Traps {
+		u, ok := expected[trap.Kind]
+		if !ok || trap.URLs != 1 || len(trap.Examples) != 1 || trap.Examples[0] != u {
+			t.Fatalf("unexpected trap %+v", trap)
+		}
+
+		if !strings.HasPrefix(trap.Pattern, host) {
+			t.Fatalf("expected the pattern of %+v to start with the host", trap)
+		}
+
+		if _, ok := resp.Pages[u]; ok {
+			t.Fatalf("expected %s to be quarantined but it was crawled", u)
+		}
+
+		if !strings.HasPrefix(resp.SkipReasons[u], "quarantined") {
+			t.Fatalf("expected %s to be skipped as quarantined but got %q", u, resp.SkipReasons[u])
+		}
+	}
+
+	var pages []string
+	for u := range resp.Pages {
+		pages = append(pages, strings.TrimPrefix(u, ts.URL))
+	}
+	sort.Strings(pages)
+	crawled := []string{"/", "/a/", "/a/a/", "/cal/1", "/cal/2", "/cal/3", "/cal/4", "/cal/5", "/search?q=1", "/search?q=2", "/search?q=3"}
+	if !reflect.DeepEqual(pages, crawled) {
+		t.Fatalf("expected %v to be crawled but got %v", crawled, pages)
+	}
+}
+
+func TestCrawler_RunLargeCatalog(t *testing.T) {
+	const pages = 1500
+	ts := syntheticSite(pages)
+	defer ts.Close()
+
+	cr, err := New(WithIgnoreRobots(true), WithOutput(nil), WithConcurrency(8), WithMaxDepth(-1),
+		WithPoliteness(Politeness{Default: HostLimit{MaxInFlight: 8}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := cr.Run(context.Background(), ts.URL+"/p/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp.Pages) != pages || len(resp.Traps) != 0 {
+		t.Fatalf("expected the %d pages of /p/{n} to be crawled without traps but got %d pages and traps %v", pages, len(resp.Pages), resp.Traps)
+	}
+}
@@ -3,8 +3,10 @@ package crawlerlib
 import (
 	"fmt"
 	"io"
+	"log"
 	"net/url"
 	"strings"
+	"time"
 
 	"golang.org/x/net/html"
 )
@@ -26,7 +28,7 @@ func resolveURL(baseURL *url.URL, href string) (*url.URL, error) {
 	return uri, nil
 }
 
-//normalizeHref will remove # and query params from a given url
+//normalizeHref removes everything from the identifier on, e.g. the fragment of a url
 func normalizeHref(href string, identifier string) string {
 	index := strings.Index(href, identifier)
 	if index == -1 {
@@ -36,52 +38,406 @@ func normalizeHref(href string, identifier string) string {
 	return href[:index]
 }
 
-//extractURLs will extract urls from anchor tags and img tags
-func extractURLs(sourceURL *url.URL, token html.Token, key string) (urls []*url.URL, invalidURLs []string) {
-	for _, attr := range token.Attr {
-		if attr.Key == key {
-			href := normalizeHref(strings.TrimSpace(attr.Val), "#")
-			if href == "" {
-				invalidURLs = append(invalidURLs, attr.Val)
-				continue
-			}
+//addLink adds a link of the kind to the page when the extractor wants it, resolving the href against base.
+//hrefs of followed kinds that can't be resolved are added to the invalid urls. returns nil when no link was added
+func addLink(hp *htmlPage, ex *extractor, base *url.URL, kind LinkKind, href string) *pageLink {
+	if !ex.kinds[kind] {
+		return nil
+	}
+
+	raw := href
+	href = normalizeHref(strings.TrimSpace(href), "#")
+	if href == "" {
+		if ex.follow[kind] {
+			hp.invalidURLs = append(hp.invalidURLs, raw)
+		}
+		return nil
+	}
+
+	// links to schemes we don't crawl, e.g. mailto, are only counted
+	if s := hrefScheme(href); s != "" && !ex.schemes[s] {
+		hp.schemeLinks = append(hp.schemeLinks, schemeLink{scheme: s, targets: contactTargets(s, href)})
+		return nil
+	}
+
+	uri, err := resolveURL(base, href)
+	if err != nil {
+		if ex.follow[kind] {
+			hp.invalidURLs = append(hp.invalidURLs, href)
+		}
+		return nil
+	}
 
-			uri, err := resolveURL(sourceURL, href)
-			if err != nil {
-				invalidURLs = append(invalidURLs, href)
-				continue
+	l := &pageLink{url: ex.normalizer.Normalize(uri), kind: kind, follow: ex.follow[kind]}
+	hp.links = append(hp.links, l)
+	return l
+}
+
+// pageLink is a link found on a page along with its anchor metadata
+type pageLink struct {
+	url    *url.URL // url the link points to
+	kind   LinkKind // kind is the element and attribute the link was found in
+	follow bool     // follow says if the link is crawled for more links
+	anchor string   // anchor is the text of the link, or the alt text of images and areas
+	rel    []string // rel holds the rel attribute values of the link
+}
+
+// htmlPage is what was scraped from an html page
+type htmlPage struct {
+	links       []*pageLink         // links found in the elements the extractor asks for
+	invalidURLs []string            // hrefs which couldn't be normalized
+	schemeLinks []schemeLink        // schemeLinks are the links to schemes the extractor doesn't crawl
+	canonical   string              // canonical is the url of the first link rel=canonical tag, empty if not declared
+	canonicals  []string            // canonicals are the distinct urls of every link rel=canonical tag
+	metas       map[string][]string // metas holds the content of the named meta tags keyed by the lower cased name
+	modified    time.Time           // modified is the last modified date declared in the meta tags, zero if not declared
+	title       string              // title of the page
+	images      []PageImage         // images of img tags and picture sources, without repeats
+	videos      []PageVideo         // videos of video tags that have a source
+	alternates  []PageAlternate     // alternates are the link rel=alternate tags with a hreflang
+}
+
+// addImage adds the image to the page unless it is already there
+func addImage(hp *htmlPage, seen map[string]bool, img PageImage) {
+	if seen[img.URL] {
+		return
+	}
+
+	seen[img.URL] = true
+	hp.images = append(hp.images, img)
+}
+
+// srcsetURLs returns the urls of the image candidates of a srcset attribute
+func srcsetURLs(srcset string) (urls []string) {
+	for _, candidate := range strings.Split(srcset, ",") {
+		if fs := strings.Fields(candidate); len(fs) > 0 {
+			urls = append(urls, fs[0])
+		}
+	}
+
+	return urls
+}
+
+// resolveSrc resolves the src attribute of a media tag, empty if it is not a fetchable url
+func resolveSrc(sourceURL *url.URL, src string) string {
+	src = strings.TrimSpace(src)
+	if src == "" {
+		return ""
+	}
+
+	u, err := resolveURL(sourceURL, src)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+
+	return u.String()
+}
+
+// pageDateMetas are the meta tags, by name, property or itemprop, that carry the last modified date of a page
+var pageDateMetas = map[string]bool{
+	"article:modified_time": true,
+	"og:updated_time":       true,
+	"datemodified":          true,
+	"last-modified":         true,
+	"dc.date.modified":      true,
+}
+
+// pageDateLayouts are the date formats accepted in the page meta tags
+var pageDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02",
+	time.RFC1123,
+	time.RFC1123Z,
+}
+
+// parsePageDate parses a date found in a page meta tag
+func parsePageDate(v string) (time.Time, bool) {
+	v = strings.TrimSpace(v)
+	for _, layout := range pageDateLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+//scrapeHTML extracts the links the extractor asks for from an html along with their kind, anchor text and rel,
+//the canonical url, meta tags, modified date, title, media and language alternates of the page.
+//urls are resolved against the base tag when the page has one. does not close the reader when done
+func scrapeHTML(ex *extractor, sourceURL *url.URL, httpBody io.Reader) *htmlPage {
+	hp := &htmlPage{metas: make(map[string][]string)}
+	page := html.NewTokenizer(httpBody)
+	base, hasBase := sourceURL, false
+	var open []*pageLink // open holds the links of the a tag being read
+	var text []string    // text of the a tag being read
+	var video *PageVideo // video is the video tag being read
+	inTitle, inPicture := false, false
+	seenImages := make(map[string]bool)
+	closeAnchor := func() {
+		anchor := strings.Join(strings.Fields(strings.Join(text, " ")), " ")
+		for _, l := range open {
+			l.anchor = anchor
+		}
+		open, text = nil, nil
+	}
+
+	for {
+		tokenType := page.Next()
+		switch tokenType {
+		case html.ErrorToken:
+			closeAnchor()
+			return hp
+		case html.TextToken:
+			if open != nil {
+				text = append(text, string(page.Text()))
 			}
+			if inTitle {
+				hp.title += string(page.Text())
+			}
+		case html.EndTagToken:
+			name, _ := page.TagName()
+			switch string(name) {
+			case "a":
+				closeAnchor()
+			case "title":
+				inTitle = false
+				hp.title = strings.Join(strings.Fields(hp.title), " ")
+			case "picture":
+				inPicture = false
+			case "video":
+				if video != nil && video.URL != "" {
+					hp.videos = append(hp.videos, *video)
+				}
+				video = nil
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := page.Token()
+			switch token.DataAtom.String() {
+			case "base":
+				// only the first base tag with a href counts
+				if href, ok := lookupAttr(token, "href"); ok && !hasBase {
+					hasBase = true
+					if u, err := resolveURL(sourceURL, strings.TrimSpace(href)); err == nil {
+						base = u
+					}
+				}
+			case "a":
+				closeAnchor()
+				href, ok := lookupAttr(token, "href")
+				if !ok {
+					break
+				}
+				if l := addLink(hp, ex, base, LinkAnchor, href); l != nil {
+					if v := attrValue(token, "rel"); v != "" {
+						l.rel = strings.Fields(strings.ToLower(v))
+					}
+					open = append(open, l)
+				}
+			case "area":
+				if href, ok := lookupAttr(token, "href"); ok {
+					if l := addLink(hp, ex, base, LinkArea, href); l != nil {
+						l.anchor = strings.TrimSpace(attrValue(token, "alt"))
+						if v := attrValue(token, "rel"); v != "" {
+							l.rel = strings.Fields(strings.ToLower(v))
+						}
+					}
+				}
+			case "img":
+				// images without text inside a link describe it with their alt
+				alt := attrValue(token, "alt")
+				if open != nil {
+					text = append(text, alt)
+				}
+				src, ok := lookupAttr(token, "src")
+				if ok {
+					if l := addLink(hp, ex, base, LinkImage, src); l != nil {
+						l.anchor = strings.TrimSpace(alt)
+					}
+				}
+				srcset := srcsetURLs(attrValue(token, "srcset"))
+				for _, s := range srcset {
+					if l := addLink(hp, ex, base, LinkImageSrcset, s); l != nil {
+						l.anchor = strings.TrimSpace(alt)
+					}
+				}
+				for _, s := range append([]string{src}, srcset...) {
+					if u := resolveSrc(base, s); u != "" {
+						addImage(hp, seenImages, PageImage{URL: u, Alt: alt})
+					}
+				}
+			case "picture":
+				inPicture = tokenType == html.StartTagToken
+			case "video":
+				video = &PageVideo{
+					URL:       resolveSrc(base, attrValue(token, "src")),
+					Thumbnail: resolveSrc(base, attrValue(token, "poster")),
+					Title:     strings.TrimSpace(attrValue(token, "title")),
+				}
+				if tokenType == html.SelfClosingTagToken {
+					if video.URL != "" {
+						hp.videos = append(hp.videos, *video)
+					}
+					video = nil
+				}
+			case "source":
+				if video != nil && video.URL == "" {
+					video.URL = resolveSrc(base, attrValue(token, "src"))
+				}
+				for _, s := range srcsetURLs(attrValue(token, "srcset")) {
+					addLink(hp, ex, base, LinkSourceSrcset, s)
+					if u := resolveSrc(base, s); u != "" && inPicture {
+						addImage(hp, seenImages, PageImage{URL: u})
+					}
+				}
+			case "script":
+				if src, ok := lookupAttr(token, "src"); ok {
+					addLink(hp, ex, base, LinkScript, src)
+				}
+			case "iframe":
+				if src, ok := lookupAttr(token, "src"); ok {
+					addLink(hp, ex, base, LinkFrame, src)
+				}
+			case "form":
+				// a form without an action submits to the page itself
+				if action := attrValue(token, "action"); strings.TrimSpace(action) != "" {
+					addLink(hp, ex, base, LinkForm, action)
+				}
+			case "title":
+				// the first title is the page title, svg images have their own
+				inTitle = hp.title == "" && tokenType == html.StartTagToken
+			case "link":
+				rel := attrValue(token, "rel")
+				kind := LinkResource
+				if hasToken(rel, "canonical") {
+					kind = LinkCanonical
+				}
+				if href, ok := lookupAttr(token, "href"); ok {
+					addLink(hp, ex, base, kind, href)
+				}
+				href := resolveSrc(base, attrValue(token, "href"))
+				switch {
+				case href == "":
+				case kind == LinkCanonical:
+					href = ex.normalizer.NormalizeString(href)
+					if hp.canonical == "" {
+						hp.canonical = href
+					}
+					hp.canonicals = appendUnique(hp.canonicals, href)
+				case hasToken(rel, "alternate") && attrValue(token, "hreflang") != "":
+					hp.alternates = append(hp.alternates, PageAlternate{
+						HrefLang: strings.TrimSpace(attrValue(token, "hreflang")),
+						URL:      href,
+					})
+				}
+			case "meta":
+				name := strings.ToLower(attrValue(token, "name"))
+				content := attrValue(token, "content")
+				if name != "" {
+					hp.metas[name] = append(hp.metas[name], content)
+				}
 
-			urls = append(urls, uri)
+				if strings.EqualFold(attrValue(token, "http-equiv"), "refresh") {
+					if u := refreshURL(content); u != "" {
+						addLink(hp, ex, base, LinkRefresh, u)
+					}
+				}
+
+				key := name
+				if key == "" {
+					key = strings.ToLower(attrValue(token, "property"))
+				}
+				if key == "" {
+					key = strings.ToLower(attrValue(token, "itemprop"))
+				}
+				if pageDateMetas[key] && hp.modified.IsZero() {
+					hp.modified, _ = parsePageDate(content)
+				}
+			}
 		}
 	}
+}
 
-	return urls, invalidURLs
+// anchorExtractor only extracts the links of a tags
+var anchorExtractor = &extractor{
+	kinds:   map[LinkKind]bool{LinkAnchor: true},
+	follow:  map[LinkKind]bool{LinkAnchor: true},
+	schemes: map[string]bool{"http": true, "https": true},
+}
+
+//extractLinksFromHTML extracts all href urls inside a tags from an html along with
+//their anchor text and rel. does not close the reader when done
+func extractLinksFromHTML(sourceURL *url.URL, httpBody io.Reader) (links []*pageLink, invalidURLs []string) {
+	hp := scrapeHTML(anchorExtractor, sourceURL, httpBody)
+	return hp.links, hp.invalidURLs
 }
 
 //extractURLsFromHTML extracts all href urls inside a tags from an html
 //does not close the reader when done
 func extractURLsFromHTML(sourceURL *url.URL, httpBody io.Reader) (urls []*url.URL, invalidURLs []string) {
-	page := html.NewTokenizer(httpBody)
-	for {
-		tokenType := page.Next()
-		if tokenType == html.ErrorToken {
-			return urls, invalidURLs
+	links, invalidURLs := extractLinksFromHTML(sourceURL, httpBody)
+	return linksToURLs(links), invalidURLs
+}
+
+// linksToURLs returns the urls of the links
+func linksToURLs(links []*pageLink) (urls []*url.URL) {
+	for _, l := range links {
+		urls = append(urls, l.url)
+	}
+
+	return urls
+}
+
+// followedURLs returns the urls of the links that are followed
+func followedURLs(links []*pageLink) (urls []*url.URL) {
+	for _, l := range links {
+		if l.follow {
+			urls = append(urls, l.url)
 		}
+	}
 
-		if tokenType == html.StartTagToken ||
-			tokenType == html.SelfClosingTagToken {
+	return urls
+}
 
-			token := page.Token()
+// appendUnique appends v to the list unless it is already there
+func appendUnique(list []string, v string) []string {
+	for _, s := range list {
+		if s == v {
+			return list
+		}
+	}
 
-			switch token.DataAtom.String() {
-			case "a":
-				s, ius := extractURLs(sourceURL, token, "href")
-				urls = append(urls, s...)
-				invalidURLs = append(invalidURLs, ius...)
-			}
+	return append(list, v)
+}
+
+// hasToken says if the space separated list holds the token, ignoring case
+func hasToken(list, token string) bool {
+	for _, t := range strings.Fields(list) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// attrValue returns the value of the token attribute, empty if not set
+func attrValue(token html.Token, key string) string {
+	v, _ := lookupAttr(token, key)
+	return v
+}
+
+// lookupAttr returns the value of the token attribute and whether it is set
+func lookupAttr(token html.Token, key string) (string, bool) {
+	for _, attr := range token.Attr {
+		if attr.Key == key {
+			return attr.Val, true
 		}
 	}
+
+	return "", false
 }
 
 // urlsToStr coverts []*url.URL to []string
@@ -106,3 +462,23 @@ func urlStrToURLs(urlsStr []string) (urls []*url.URL, err error) {
 
 	return urls, nil
 }
+
+// logf logs to the given logger, or the standard logger when nil
+func logf(l *log.Logger, format string, v ...interface{}) {
+	if l == nil {
+		log.Output(2, fmt.Sprintf(format, v...))
+		return
+	}
+
+	l.Output(2, fmt.Sprintf(format, v...))
+}
+
+// logln logs to the given logger, or the standard logger when nil
+func logln(l *log.Logger, v ...interface{}) {
+	if l == nil {
+		log.Output(2, fmt.Sprintln(v...))
+		return
+	}
+
+	l.Output(2, fmt.Sprintln(v...))
+}
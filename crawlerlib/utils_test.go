@@ -6,6 +6,7 @@ import (
 	"net/url"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func Test_extractLinksFromHTML(t *testing.T) {
@@ -69,6 +70,30 @@ func Test_extractLinksFromHTML(t *testing.T) {
 
 }
 
+func Test_extractLinksFromHTMLAnchors(t *testing.T) {
+	sourceURL, _ := url.Parse("http://www.test.com/")
+	rawHTML := `<p>intro <a href="/a" rel="NoFollow sponsored">  First
+	<b>link</b></a>
+	<a href="/b"><img src="/logo.png" alt="Logo"></a>
+	<a href="/c"></a> outside</p>`
+	expected := []pageLink{
+		{anchor: "First link", rel: []string{"nofollow", "sponsored"}},
+		{anchor: "Logo"},
+		{anchor: ""},
+	}
+
+	links, _ := extractLinksFromHTML(sourceURL, bytes.NewReader([]byte(rawHTML)))
+	if len(links) != len(expected) {
+		t.Fatalf("expected %d links but got %d", len(expected), len(links))
+	}
+
+	for i, l := range links {
+		if l.anchor != expected[i].anchor || !reflect.DeepEqual(l.rel, expected[i].rel) {
+			t.Fatalf("link %s: expected anchor %q rel %v but got %q %v", l.url, expected[i].anchor, expected[i].rel, l.anchor, l.rel)
+		}
+	}
+}
+
 func Test_resolveURL(t *testing.T) {
 	cases := []struct {
 		sourceURL   string
@@ -137,3 +162,82 @@ func Test_resolveURL(t *testing.T) {
 	}
 }
 
+
+func Test_scrapeHTML(t *testing.T) {
+	sourceURL, _ := url.Parse("http://www.test.com/page?x=1")
+	tests := []struct {
+		rawHTML   string
+		canonical string
+		metas     map[string][]string
+		modified  time.Time
+	}{
+		{
+			rawHTML: `<head><link rel="Canonical" href="/page"><link rel="canonical" href="/other">
+			<meta name="robots" content="noindex"><meta property="article:modified_time" content="2020-01-02T03:04:05Z"></head>`,
+			canonical: "http://www.test.com/page",
+			metas:     map[string][]string{"robots": {"noindex"}},
+			modified:  time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		},
+		{
+			rawHTML:  `<meta itemprop="dateModified" content="2019-05-06"><meta name="last-modified" content="2018-01-01">`,
+			metas:    map[string][]string{"last-modified": {"2018-01-01"}},
+			modified: time.Date(2019, 5, 6, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			rawHTML: `<meta property="og:updated_time" content="yesterday">`,
+			metas:   map[string][]string{},
+		},
+	}
+
+	ex, _ := newExtractor(ExtractConfig{})
+	for i, c := range tests {
+		hp := scrapeHTML(ex, sourceURL, bytes.NewReader([]byte(c.rawHTML)))
+		if hp.canonical != c.canonical || !reflect.DeepEqual(hp.metas, c.metas) || !hp.modified.Equal(c.modified) {
+			t.Fatalf("case %d: expected %s %v %v but got %s %v %v", i, c.canonical, c.metas, c.modified, hp.canonical, hp.metas, hp.modified)
+		}
+	}
+}
+
+func Test_scrapeHTMLMedia(t *testing.T) {
+	sourceURL, _ := url.Parse("http://www.test.com/page")
+	rawHTML := `<html><head><title> My
+	Page </title>
+	<link rel="alternate" hreflang="de" href="/de/page"><link rel="alternate" href="/feed.xml"></head>
+	<body><svg><title>icon</title></svg>
+	<img src="/a.png" alt="A" srcset="/a-2x.png 2x, /a.png 1x"><img src="data:image/png;base64,xx">
+	<picture><source srcset="/b.webp"><img src="/b.png"></picture>
+	<video poster="/thumb.jpg" title="Intro"><source src="/intro.mp4"><source src="/intro.webm"></video>
+	<video><source src=""></video></body></html>`
+	ex, _ := newExtractor(ExtractConfig{})
+	hp := scrapeHTML(ex, sourceURL, bytes.NewReader([]byte(rawHTML)))
+
+	tests := []struct {
+		got      interface{}
+		expected interface{}
+	}{
+		{got: hp.title, expected: "My Page"},
+		{
+			got: hp.images,
+			expected: []PageImage{
+				{URL: "http://www.test.com/a.png", Alt: "A"},
+				{URL: "http://www.test.com/a-2x.png", Alt: "A"},
+				{URL: "http://www.test.com/b.webp"},
+				{URL: "http://www.test.com/b.png"},
+			},
+		},
+		{
+			got:      hp.videos,
+			expected: []PageVideo{{URL: "http://www.test.com/intro.mp4", Thumbnail: "http://www.test.com/thumb.jpg", Title: "Intro"}},
+		},
+		{
+			got:      hp.alternates,
+			expected: []PageAlternate{{HrefLang: "de", URL: "http://www.test.com/de/page"}},
+		},
+	}
+
+	for i, c := range tests {
+		if !reflect.DeepEqual(c.got, c.expected) {
+			t.Fatalf("case %d: expected %+v but got %+v", i, c.expected, c.got)
+		}
+	}
+}